@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"game/engine"
+)
+
+const (
+	MaxAnnouncementLength = 280 // Caracteres de um aviso de /admin/announce
+	MaxRoomPasswordLength = 128 // Caracteres da senha de uma sala privada
+)
+
+// adminOnly protege um endpoint de operação com o token de ADMIN_TOKEN, enviado como "Authorization: Bearer".
+// Sem o cabeçalho responde 401, com um token errado 403, e com ADMIN_TOKEN vazia o endpoint nem existe (404).
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "token de administração ausente", http.StatusUnauthorized)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 { // Tempo constante: não revela o prefixo certo
+			slog.Warn("Token de administração inválido", "path", r.URL.Path, "ip", clientIP(r))
+			http.Error(w, "token de administração inválido", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminPageHandler serve o painel de administração, uma página estática separada do cliente dos jogadores. A
+// página não tem nada secreto e por isso não passa por adminOnly (a navegação do navegador não manda o cabeçalho
+// Authorization): ela pede o token e o envia em cada ação para os endpoints /admin, e lê /stats e /rooms. Com
+// ADMIN_TOKEN vazia o painel some junto com os endpoints.
+func adminPageHandler(w http.ResponseWriter, r *http.Request) {
+	if config.AdminToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	page, err := webFS.ReadFile("web/admin.html")
+	if err != nil {
+		slog.Error("Erro ao ler o painel de administração", "err", err)
+		http.Error(w, "erro interno", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY") // Os botões do painel não podem ser clicados por outra página num iframe
+	w.Write(page)
+}
+
+// adminResetHandler começa uma nova partida na sala de ?room= (a padrão, se omitido), mesmo com a atual em
+// andamento. O reset acontece sob os locks da sala, como o de um jogador, então pode concorrer com o gameLoop.
+func adminResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	roomID := r.URL.Query().Get("room")
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	if !validRoomID.MatchString(roomID) {
+		http.Error(w, "sala inválida", http.StatusBadRequest)
+		return
+	}
+	gs, ok := rooms.get(roomID) // Não cria salas: resetar uma que não existe é quase sempre um erro de digitação
+	if !ok {
+		http.Error(w, "sala não encontrada", http.StatusNotFound)
+		return
+	}
+
+	items := gs.InitializeItems()
+	slog.Info("Partida reiniciada pela administração", "room", roomID, "action", "admin_reset", "items", items)
+
+	writeAdminJSON(w, struct {
+		RoomID string `json:"roomId"`
+		Items  int    `json:"items"`
+	}{roomID, items})
+}
+
+// adminKickHandler expulsa o jogador ou espectador ?id= (procurado em todas as salas, ou só em ?room=). Ele
+// recebe o erro "kicked" e a conexão é fechada; o jogador sai na hora, sem prazo de reconexão.
+func adminKickHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id é obrigatório", http.StatusBadRequest)
+		return
+	}
+	states := rooms.states()
+	if roomID := r.URL.Query().Get("room"); roomID != "" {
+		gs, ok := states[roomID]
+		if !ok {
+			http.Error(w, "sala não encontrada", http.StatusNotFound)
+			return
+		}
+		states = map[string]*engine.GameState{roomID: gs}
+	}
+
+	notice := errorNotice(ErrCodeKicked, ErrCodeKicked)
+	for roomID, gs := range states {
+		if gs.KickPlayer(id, notice) {
+			writeAdminJSON(w, struct {
+				RoomID   string `json:"roomId"`
+				PlayerID string `json:"playerId"`
+			}{roomID, id})
+			return
+		}
+	}
+	http.Error(w, "jogador não encontrado", http.StatusNotFound)
+}
+
+// adminBanHandler bloqueia novas conexões WebSocket do IP ?ip= por BAN_DURATION_SECONDS (ou ?seconds=) e
+// expulsa as que ele já tem abertas
+func adminBanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	ip, ok := normalizeIP(r.URL.Query().Get("ip"))
+	if !ok {
+		http.Error(w, "ip inválido", http.StatusBadRequest)
+		return
+	}
+	duration := config.BanDuration
+	if raw := r.URL.Query().Get("seconds"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			http.Error(w, "seconds deve ser um inteiro positivo", http.StatusBadRequest)
+			return
+		}
+		duration = time.Duration(n) * time.Second
+	}
+
+	until := time.Now().Add(duration)
+	bans.ban(ip, until) // Antes de expulsar, para que as conexões expulsas não possam voltar no intervalo
+	kicked := kickIP(ip, errorNotice(ErrCodeKicked, msgBanned))
+	slog.Info("IP bloqueado pela administração", "ip", ip, "action", "ban", "duration", duration, "kicked", kicked)
+
+	writeAdminJSON(w, struct {
+		IP     string    `json:"ip"`
+		Until  time.Time `json:"until"`
+		Kicked int       `json:"kicked"`
+	}{ip, until, kicked})
+}
+
+// adminAnnounceHandler envia um aviso (MsgTypeSystem) a todos os jogadores e espectadores conectados, em todas
+// as salas. O corpo é um JSON {"message": "..."}; a resposta diz quantas salas e conexões receberam o aviso.
+func adminAnnounceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		http.Error(w, `corpo deve ser um JSON {"message": "..."}`, http.StatusBadRequest)
+		return
+	}
+	text := strings.TrimSpace(body.Message)
+	if text == "" || utf8.RuneCountInString(text) > MaxAnnouncementLength {
+		http.Error(w, fmt.Sprintf("message deve ter de 1 a %d caracteres", MaxAnnouncementLength), http.StatusBadRequest)
+		return
+	}
+
+	message, _ := json.Marshal(SystemMessage{Type: MsgTypeSystem, Message: text, Time: time.Now()})
+	states := rooms.states()
+	delivered := 0
+	for _, gs := range states {
+		delivered += gs.Broadcast(message)
+	}
+	slog.Info("Aviso enviado pela administração", "action", "announce", "rooms", len(states), "delivered", delivered, "message", text)
+
+	writeAdminJSON(w, struct {
+		Rooms     int `json:"rooms"`
+		Delivered int `json:"delivered"`
+	}{len(states), delivered})
+}
+
+// adminRoomPasswordHandler define a senha da sala ?room= a partir do corpo {"password": "..."}, criando a sala se
+// ela ainda não existir; uma senha vazia torna a sala pública. Quem já está na sala não é afetado.
+func adminRoomPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	roomID := r.URL.Query().Get("room")
+	if !validRoomID.MatchString(roomID) {
+		http.Error(w, "sala inválida", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		http.Error(w, `corpo deve ser um JSON {"password": "..."}`, http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(body.Password) > MaxRoomPasswordLength {
+		http.Error(w, fmt.Sprintf("password deve ter no máximo %d caracteres", MaxRoomPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	created := rooms.setPassword(roomID, body.Password)
+	private := body.Password != ""
+	slog.Info("Senha da sala alterada pela administração", "room", roomID, "action", "room_password", "private", private, "created", created)
+
+	writeAdminJSON(w, struct {
+		RoomID  string `json:"roomId"`
+		Private bool   `json:"private"`
+		Created bool   `json:"created"`
+	}{roomID, private, created})
+}
+
+// writeAdminJSON envia a resposta de um endpoint de administração
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Erro ao responder requisição de administração", "err", err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"game/engine"
+)
+
+const (
+	DefaultBanSec      = 3600              // Duração padrão de um bloqueio por /admin/ban-ip
+	DefaultProxyHeader = "X-Forwarded-For" // Cabeçalho com o IP do cliente atrás de TRUSTED_PROXY_HOPS proxies
+	DefaultConnRate    = 30                // Conexões WebSocket novas por minuto de um mesmo IP, em média
+	DefaultConnBurst   = 10                // Conexões seguidas que um IP pode abrir antes de o limite de CONN_RATE_PER_MIN valer
+	MaxRateLimitedIPs  = 10000             // IPs acompanhados pelo limite de conexões; acima disso os mais antigos saem
+)
+
+var (
+	bans        = newBanList()      // IPs impedidos de abrir conexões WebSocket
+	connections = newConnRegistry() // Conexões abertas por IP, para que um bloqueio derrube também as atuais
+	connLimiter *ConnLimiter        // Limite de conexões novas por IP; inicializado em main() (nil desliga)
+)
+
+// clientIP retorna o IP do cliente. Atrás de proxies reversos (TRUSTED_PROXY_HOPS > 0), o endereço da conexão é
+// o do proxy, e o do cliente é o que o proxy mais externo anotou no cabeçalho TRUSTED_PROXY_HEADER (por padrão
+// X-Forwarded-For): contando da direita, já que cada proxy acrescenta ao fim, e ignorando o que vem antes, que o
+// próprio cliente pode ter forjado. Um cabeçalho de valor único, como X-Real-IP, funciona com um proxy só.
+func clientIP(r *http.Request) string {
+	if hops := config.TrustedProxyHops; hops > 0 {
+		var forwarded []string
+		for _, header := range r.Header.Values(config.ProxyHeader) {
+			for _, addr := range strings.Split(header, ",") {
+				forwarded = append(forwarded, strings.TrimSpace(addr))
+			}
+		}
+		if len(forwarded) >= hops {
+			if ip, ok := normalizeIP(forwarded[len(forwarded)-hops]); ok {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip, ok := normalizeIP(host); ok {
+		return ip
+	}
+	return host
+}
+
+// normalizeIP valida um IP e o escreve na forma canônica (IPv4 mapeado em IPv6 vira IPv4), para que o mesmo
+// endereço sempre caia na mesma entrada do bloqueio
+func normalizeIP(raw string) (string, bool) {
+	addr, err := netip.ParseAddr(raw)
+	if err != nil {
+		return "", false
+	}
+	return addr.Unmap().String(), true
+}
+
+// BanList guarda os IPs bloqueados e até quando. Bloqueios vencidos são descartados na próxima consulta.
+type BanList struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newBanList() *BanList {
+	return &BanList{until: make(map[string]time.Time)}
+}
+
+// ban bloqueia ip até o instante informado (um novo bloqueio substitui o anterior)
+func (b *BanList) ban(ip string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.until[ip] = until
+}
+
+// banned diz se ip está bloqueado agora
+func (b *BanList) banned(ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	until, ok := b.until[ip]
+	if ok && time.Now().After(until) {
+		delete(b.until, ip)
+		return false
+	}
+	return ok
+}
+
+// ConnLimiter limita a frequência com que cada IP abre conexões WebSocket, com um balde de fichas por IP: o
+// balde comporta burst fichas, cada conexão gasta uma e elas voltam a perRate por segundo. A memória é limitada
+// a MaxRateLimitedIPs baldes: um balde que já voltou a encher é igual a um novo e pode ser descartado, e, se
+// mesmo assim não couber, sai o IP que está há mais tempo sem conectar.
+type ConnLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // Fichas devolvidas por segundo
+	burst   float64 // Capacidade do balde
+	buckets map[string]*connBucket
+}
+
+// connBucket é o balde de um IP: as fichas que sobraram na última conexão e quando ela foi
+type connBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newConnLimiter cria um limite de perMinute conexões por minuto, com rajadas de até burst
+func newConnLimiter(perMinute int, burst int) *ConnLimiter {
+	return &ConnLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*connBucket),
+	}
+}
+
+// allow gasta uma ficha do balde de ip, se houver. Sem ficha, retorna false e quanto falta para a próxima.
+func (l *ConnLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= MaxRateLimitedIPs {
+			l.evictLocked(now)
+		}
+		b = &connBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evictLocked abre espaço para um IP novo: descarta os baldes que já encheram de novo e, se nenhum encheu, o
+// mais antigo. Quem chama deve segurar o mutex.
+func (l *ConnLimiter) evictLocked(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second)) // Tempo para um balde vazio encher
+	var oldestIP string
+	var oldest time.Time
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, ip)
+			continue
+		}
+		if oldestIP == "" || b.last.Before(oldest) {
+			oldestIP, oldest = ip, b.last
+		}
+	}
+	if len(l.buckets) >= MaxRateLimitedIPs {
+		delete(l.buckets, oldestIP)
+	}
+}
+
+// clientConn identifica uma conexão WebSocket aberta: a sala, o jogador (ou espectador) que ela controla e o
+// canal de envio, que distingue a conexão atual de uma antiga do mesmo jogador que reconectou
+type clientConn struct {
+	gs       *engine.GameState
+	id       string
+	sendChan chan []byte
+}
+
+// ConnRegistry agrupa as conexões abertas pelo IP de origem
+type ConnRegistry struct {
+	mu   sync.Mutex
+	byIP map[string]map[clientConn]struct{}
+}
+
+func newConnRegistry() *ConnRegistry {
+	return &ConnRegistry{byIP: make(map[string]map[clientConn]struct{})}
+}
+
+func (c *ConnRegistry) add(ip string, conn clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.byIP[ip] == nil {
+		c.byIP[ip] = make(map[clientConn]struct{})
+	}
+	c.byIP[ip][conn] = struct{}{}
+}
+
+func (c *ConnRegistry) remove(ip string, conn clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.byIP[ip], conn)
+	if len(c.byIP[ip]) == 0 {
+		delete(c.byIP, ip)
+	}
+}
+
+// fromIP retorna uma cópia das conexões abertas por ip, para que quem as expulsa não segure o mutex do registro
+// enquanto espera o lock de cada sala
+func (c *ConnRegistry) fromIP(ip string) []clientConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conns := make([]clientConn, 0, len(c.byIP[ip]))
+	for conn := range c.byIP[ip] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// kickIP expulsa todas as conexões abertas por ip com o aviso de notice (veja errorNotice), retornando quantas saíram
+func kickIP(ip string, notice func(lang string) []byte) int {
+	kicked := 0
+	for _, conn := range connections.fromIP(ip) {
+		if conn.gs.KickPlayer(conn.id, notice) {
+			kicked++
+		}
+	}
+	if kicked > 0 {
+		slog.Info("Conexões do IP bloqueado expulsas", "ip", ip, "kicked", kicked)
+	}
+	return kicked
+}
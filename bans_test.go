@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnLimiterBurstAndRefill(t *testing.T) {
+	l := newConnLimiter(60, 3) // Uma ficha por segundo, rajadas de até 3
+	start := time.Now()
+	ip := "203.0.113.7"
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow(ip, start); !ok {
+			t.Fatalf("conexão %d da rajada recusada", i+1)
+		}
+	}
+	if ok, wait := l.allow(ip, start); ok || wait != time.Second {
+		t.Errorf("depois da rajada: permitida %v, espera %v; deveria ser recusada com 1s de espera", ok, wait)
+	}
+	if ok, _ := l.allow("203.0.113.8", start); !ok {
+		t.Error("outro IP não deveria ser afetado")
+	}
+	if ok, wait := l.allow(ip, start.Add(500*time.Millisecond)); ok || wait != 500*time.Millisecond {
+		t.Errorf("meia ficha depois: permitida %v, espera %v; deveria ser recusada com 500ms de espera", ok, wait)
+	}
+
+	// Uma ficha por segundo: depois de um segundo, uma conexão só
+	if ok, _ := l.allow(ip, start.Add(time.Second)); !ok {
+		t.Error("a ficha devolvida em um segundo não foi aceita")
+	}
+	if ok, _ := l.allow(ip, start.Add(time.Second)); ok {
+		t.Error("só uma ficha deveria ter voltado em um segundo")
+	}
+
+	// Parado por bem mais que o tempo de encher, o balde volta à rajada cheia, e não além dela
+	later := start.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow(ip, later); !ok {
+			t.Fatalf("conexão %d da nova rajada recusada", i+1)
+		}
+	}
+	if ok, _ := l.allow(ip, later); ok {
+		t.Error("o balde passou da capacidade burst")
+	}
+}
+
+// rateLimitedTotal lê jogo_rate_limited_connections_total em /metrics
+func rateLimitedTotal(t *testing.T, srv *httptest.Server) float64 {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		if value, ok := strings.CutPrefix(line, "jogo_rate_limited_connections_total "); ok {
+			total, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return total
+		}
+	}
+	t.Fatal("jogo_rate_limited_connections_total fora de /metrics")
+	return 0
+}
+
+func TestRapidConnectsGet429(t *testing.T) {
+	connLimiter = newConnLimiter(60, 3) // Como main com CONN_RATE_PER_MIN=60 e CONN_BURST=3
+	t.Cleanup(func() { connLimiter = nil }) // Depois do encerramento do servidor, registrado em seguida
+	srv := startServer(t, nil)
+	before := rateLimitedTotal(t, srv)
+
+	// Todas do mesmo IP (o do httptest): as três da rajada entram, as seguintes são recusadas antes do upgrade
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/rajada"
+	for i := 0; i < 3; i++ {
+		readUntil(t, dial(t, srv, "/ws/rajada"), MsgTypeWelcome)
+	}
+	for i := 0; i < 2; i++ {
+		conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("conexão %d acima da rajada foi aceita", i+4)
+		}
+		if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("conexão %d acima da rajada: %v, %v; deveria ser %d", i+4, resp, err, http.StatusTooManyRequests)
+		}
+		if wait, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || wait < 1 {
+			t.Errorf("Retry-After %q, deveria ser um número de segundos positivo", resp.Header.Get("Retry-After"))
+		}
+	}
+	if got := rateLimitedTotal(t, srv) - before; got != 2 {
+		t.Errorf("jogo_rate_limited_connections_total subiu %v, deveria subir 2", got)
+	}
+	if stats := rooms.stats()["rajada"]; stats.Players != 3 {
+		t.Errorf("a sala deveria ter só os três jogadores da rajada: %+v", stats)
+	}
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// botDirections são os passos possíveis de um bot, na ordem em que a busca de caminho os tenta
+var botDirections = []string{"up", "down", "left", "right"}
+
+// BotManager mantém jogadores controlados pelo servidor numa sala enquanto há poucos jogadores reais, para que
+// a partida não fique parada. Os bots entram por addPlayer como qualquer jogador e disputam a vitória normalmente.
+type BotManager struct {
+	gs        *GameState
+	count     int           // Quantidade de bots mantida enquanto a sala tem poucos jogadores reais
+	threshold int           // A partir desse número de jogadores reais ativos, os bots saem
+	interval  time.Duration // Intervalo entre os movimentos de cada bot
+	skill     int           // Porcentagem de movimentos calculados pelo menor caminho; o resto é aleatório
+	rng       *rand.Rand    // Gera a seed de cada bot; só usado pela goroutine de Run
+	bots      []string      // IDs dos bots na sala; só usado pela goroutine de Run
+	nextBot   int           // Sequência usada para gerar IDs e nomes de bots
+}
+
+// NewBotManager cria o gerenciador de bots da sala. Os bots se movem no ritmo permitido a um jogador:
+// um movimento por tick, ou menos se MoveInterval for maior.
+func NewBotManager(gs *GameState, cfg Config) *BotManager {
+	seed := cfg.RandomSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &BotManager{
+		gs:        gs,
+		count:     cfg.BotCount,
+		threshold: cfg.BotThreshold,
+		interval:  max(cfg.TickDelay, cfg.MoveInterval),
+		skill:     cfg.BotSkill,
+		rng:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Run confere a cada intervalo quantos jogadores reais estão na sala, colocando ou tirando bots, até ctx ser
+// cancelado
+func (bm *BotManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(bm.interval)
+	defer ticker.Stop()
+
+	for {
+		bm.sync()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sync coloca bots até count enquanto houver menos de threshold jogadores reais, e tira todos a partir daí
+func (bm *BotManager) sync() {
+	want := bm.count
+	if bm.gs.humanCount() >= bm.threshold {
+		want = 0
+	}
+
+	for len(bm.bots) > want {
+		id := bm.bots[len(bm.bots)-1]
+		bm.bots = bm.bots[:len(bm.bots)-1]
+		bm.gs.RemovePlayer(id) // Fecha o canal do bot, encerrando sua goroutine
+	}
+	for len(bm.bots) < want {
+		bm.nextBot++
+		id := fmt.Sprintf("bot-%d", bm.nextBot)
+		_, sendChan, err := bm.gs.addPlayer(id, fmt.Sprintf("Bot %d", bm.nextBot), 0, true)
+		if err != nil {
+			slog.Warn("Não foi possível colocar um bot", "room", bm.gs.RoomID, "err", err)
+			return
+		}
+		bm.bots = append(bm.bots, id)
+		go bm.runBot(id, sendChan, rand.New(rand.NewSource(bm.rng.Int63()))) // Cada goroutine com seu gerador, que não é seguro para uso concorrente
+	}
+}
+
+// runBot faz o papel do cliente de um bot: descarta as mensagens do servidor (o bot consulta o GameState
+// diretamente) e, a cada intervalo, pede um movimento pelo caminho até o item alvo. Com chance 100-skill %,
+// o passo é sorteado em vez de calculado, para que o bot erre às vezes e possa ser vencido.
+func (bm *BotManager) runBot(id string, sendChan chan []byte, rng *rand.Rand) {
+	ticker := time.NewTicker(bm.interval)
+	defer ticker.Stop()
+
+	target := "" // ID do item que o bot está perseguindo
+	for {
+		select {
+		case _, ok := <-sendChan:
+			if !ok {
+				return // Bot removido da sala (ou servidor encerrando)
+			}
+		case <-ticker.C:
+			var direction string
+			if rng.Intn(100) < bm.skill {
+				direction, target = bm.gs.botDirection(id, target)
+			} else {
+				direction = botDirections[rng.Intn(len(botDirections))]
+			}
+			if direction != "" {
+				bm.gs.QueueMove(id, direction) // Movimentos rejeitados (fim de partida, limite de taxa, parede) são só ignorados
+			}
+		}
+	}
+}
+
+// humanCount conta os jogadores reais (não bots) ativos na sala
+func (gs *GameState) humanCount() int {
+	gs.playersMu.RLock()
+	defer gs.playersMu.RUnlock()
+
+	count := 0
+	for _, p := range gs.Players {
+		if p.IsActive && !p.bot {
+			count++
+		}
+	}
+	return count
+}
+
+// botDirection escolhe o próximo passo do bot pelo menor caminho (busca em largura) até o item alvo, desviando
+// de paredes e de outros jogadores. O alvo é mantido enquanto existir e for alcançável; se outro jogador o
+// coletar (ou o caminho fechar), o bot passa para o item mais próximo. Retorna a direção e o ID do alvo, ou
+// direção "" se não houver item alcançável.
+func (gs *GameState) botDirection(id string, target string) (string, string) {
+	gs.rLockAll()
+	defer gs.rUnlockAll()
+
+	bot, ok := gs.Players[id]
+	if !ok || !bot.IsActive || gs.GameOver {
+		return "", ""
+	}
+
+	for _, item := range gs.Items {
+		if item.ID == target {
+			if direction, _, ok := gs.pathLocked(bot.Pos, func(p Point) bool { return p == item.Pos }); ok {
+				return direction, target
+			}
+			break // Alvo bloqueado: escolhe outro
+		}
+	}
+
+	direction, goal, ok := gs.pathLocked(bot.Pos, func(p Point) bool {
+		item, exists := gs.Items[fmt.Sprintf("%d,%d", p.X, p.Y)]
+		return exists && item.Value >= 0 // Bombas não são alvo
+	})
+	if !ok {
+		return "", ""
+	}
+	return direction, gs.Items[fmt.Sprintf("%d,%d", goal.X, goal.Y)].ID
+}
+
+// pathLocked faz uma busca em largura a partir de 'from' até a célula mais próxima que satisfaz isGoal,
+// sem atravessar paredes nem jogadores ativos. Como todo passo custa o mesmo, a busca em largura já encontra
+// o menor caminho (o mesmo que um A*). Retorna a direção do primeiro passo e a célula encontrada.
+// Quem chama deve segurar os dois mutexes (leitura basta).
+func (gs *GameState) pathLocked(from Point, isGoal func(Point) bool) (string, Point, bool) {
+	firstStep := map[Point]string{from: ""} // Também marca as células já visitadas
+	queue := []Point{from}
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		if pos != from && isGoal(pos) {
+			return firstStep[pos], pos, true
+		}
+		for _, direction := range botDirections {
+			next, _ := gs.neighbor(pos, direction) // Na borda sem wrap, volta a própria posição, que já foi visitada
+			if _, seen := firstStep[next]; seen || gs.blockedLocked(next) {
+				continue
+			}
+			if pos == from {
+				firstStep[next] = direction
+			} else {
+				firstStep[next] = firstStep[pos]
+			}
+			queue = append(queue, next)
+		}
+	}
+	return "", Point{}, false
+}
+
+// blockedLocked diz se um bot deve evitar a célula (parede, outro jogador, rastro ou bomba). Quem chama deve
+// segurar os dois mutexes.
+func (gs *GameState) blockedLocked(pos Point) bool {
+	if item, ok := gs.Items[fmt.Sprintf("%d,%d", pos.X, pos.Y)]; ok && item.Value < 0 {
+		return true
+	}
+	return gs.obstacleSet[pos] || gs.playerAt(pos) != nil || gs.trailAt(pos) != nil
+}
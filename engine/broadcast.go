@@ -0,0 +1,379 @@
+package engine
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// playerView é a parte pública de um jogador enviada aos clientes
+type playerView struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name,omitempty"`
+	Pos   Point   `json:"pos"`
+	Score int     `json:"score"`
+	Team  int     `json:"team,omitempty"`
+	Color string  `json:"color"`
+	Body  []Point `json:"body,omitempty"` // Rastro, no modo rastro
+	Out   bool    `json:"out,omitempty"`  // Eliminado nesta partida
+	Fast  bool    `json:"fast,omitempty"` // Com o power-up de velocidade ativo
+	Bot   bool    `json:"bot,omitempty"`
+	Ping  int     `json:"pingMs,omitempty"` // Latência da conexão em ms (ping/pong do WebSocket); omitida enquanto desconhecida
+	Combo int     `json:"combo,omitempty"`  // Multiplicador da sequência de coletas em andamento; omitido sem sequência (x1)
+}
+
+// stateSnapshot é a cópia do estado da sala enviada a cada tick
+type stateSnapshot struct {
+	Seq          uint64                 `json:"seq"`        // Número do broadcast; snapshots avulsos repetem o do último
+	Tick         int                    `json:"tick"`       // Ticks processados pela sala até este estado
+	ServerTime   int64                  `json:"serverTime"` // Relógio do servidor (Unix, em ms), para medir atraso
+	ServerMs     int64                  `json:"serverMs"`   // Tempo monotônico desde a criação da sala (ms), imune a ajustes do relógio, para interpolar
+	RoomID       string                 `json:"roomId"`
+	Players      map[string]*playerView `json:"players"`
+	Scoreboard   []PlayerStats          `json:"scoreboard"` // Jogadores ativos da maior para a menor pontuação (empates por ID), para um placar estável
+	Items        map[string]*Item       `json:"items"`
+	ItemsLeft    int                    `json:"itemsRemaining"` // Itens no tabuleiro inteiro (len(Items) da sala), mesmo quando Items vem recortado pela visão limitada
+	PlayerCount  int                    `json:"playerCount"`    // Jogadores ativos na sala (inclusive bots), mesmo os fora do recorte da visão limitada
+	Spectators   int                    `json:"spectators"`     // Quantidade de espectadores na sala
+	Obstacles    []Point                `json:"obstacles"`
+	Ice          []Point                `json:"ice,omitempty"`       // Células de gelo, onde o movimento desliza
+	Teleports    []Teleport             `json:"teleports,omitempty"` // Pares de portais
+	BoardWidth   int                    `json:"boardWidth"`
+	BoardHeight  int                    `json:"boardHeight"`
+	GameOver     bool                   `json:"gameOver"`
+	WinnerIDs    []string               `json:"winnerIds,omitempty"`
+	TeamScores   map[int]int            `json:"teamScores,omitempty"`   // Soma de cada equipe, só no modo de equipes
+	WinningTeams []int                  `json:"winningTeams,omitempty"` // Equipe(s) vencedora(s), só no modo de equipes
+	Round        int                    `json:"round,omitempty"`        // Rodada atual, só no modo de rodadas
+	Rounds       int                    `json:"rounds,omitempty"`       // Rodadas por série, só no modo de rodadas
+	ChampionIDs  []string               `json:"championIds,omitempty"`  // Campeão(ões) da série, depois da última rodada
+	TickMs       int                    `json:"tickMs"`
+	ViewRadius   int                    `json:"viewRadius,omitempty"`       // Raio do modo de visão limitada: fora dele, jogadores e itens não são enviados
+	Wrap         bool                   `json:"wrap,omitempty"`             // Tabuleiro toroidal, para o cliente medir distâncias como o servidor
+	TargetScore  int                    `json:"targetScore,omitempty"`      // Pontos para vencer, quando a meta está ligada
+	Remaining    *int                   `json:"remainingSeconds,omitempty"` // Só presente no modo com tempo limite
+	Overtime     bool                   `json:"overtime,omitempty"`         // Prorrogação por empate em andamento; remainingSeconds passa a contar o tempo dela
+	RestartIn    *int                   `json:"restartSeconds,omitempty"`   // Contagem para a próxima rodada, só após o fim com reinício automático
+
+	buffers *snapshotBuffers // Memória reaproveitada dos mapas acima; devolvida por release depois da serialização
+}
+
+// snapshotBuffers é a memória de um snapshot (visões dos jogadores, mapas e placar), reaproveitada entre ticks
+// por snapshotPool para que o broadcast não aloque tudo de novo a cada tick. Os mapas são esvaziados na
+// devolução, mas mantêm a capacidade.
+type snapshotBuffers struct {
+	views   []playerView // Os valores de players apontam para cá, então a capacidade é reservada antes de preenchê-la
+	players map[string]*playerView
+	items   map[string]*Item
+	scores  []PlayerStats
+}
+
+var snapshotPool = sync.Pool{New: func() any {
+	return &snapshotBuffers{players: make(map[string]*playerView), items: make(map[string]*Item), scores: []PlayerStats{}}
+}}
+
+// release devolve a memória do snapshot ao pool. Só pode ser chamado depois da última serialização dele (e de
+// qualquer recorte de visibleFrom, que aponta para as mesmas visões).
+func (s *stateSnapshot) release() {
+	b := s.buffers
+	if b == nil {
+		return
+	}
+	s.buffers, s.Players, s.Items, s.Scoreboard = nil, nil, nil, nil
+	clear(b.views) // Solta as referências (rastros, nomes) para o coletor de lixo
+	b.views = b.views[:0]
+	clear(b.players)
+	clear(b.items)
+	clear(b.scores)
+	b.scores = b.scores[:0]
+	snapshotPool.Put(b)
+}
+
+// snapshotLocked copia o estado visível da sala, com apenas os jogadores ativos. Quem chama deve segurar os dois mutexes (leitura basta).
+func (gs *GameState) snapshotLocked() stateSnapshot {
+	now := gs.now()
+	buf := snapshotPool.Get().(*snapshotBuffers)
+	if cap(buf.views) < len(gs.Players) { // Sem realocar no meio do laço, os ponteiros em players continuam válidos
+		buf.views = make([]playerView, 0, len(gs.Players))
+	}
+	for id, p := range gs.Players {
+		if p.IsActive {
+			buf.views = append(buf.views, playerView{p.ID, p.Name, p.Pos, p.Score, p.Team, p.Color, p.Body, p.Out, p.boosted(now), p.bot, p.latencyMs(), 0}) // Body nunca é alterado no lugar, então pode ser compartilhado
+			if combo := gs.comboLocked(p, now); combo > 1 {
+				buf.views[len(buf.views)-1].Combo = combo
+			}
+			buf.players[id] = &buf.views[len(buf.views)-1]
+		}
+	}
+
+	for id, i := range gs.Items {
+		buf.items[id] = i
+	}
+	buf.scores = gs.appendScoresLocked(buf.scores)
+
+	snapshot := stateSnapshot{
+		Tick:         gs.ticks,
+		ServerTime:   now.UnixMilli(),
+		ServerMs:     now.Sub(gs.createdAt).Milliseconds(),
+		RoomID:       gs.RoomID,
+		Players:      buf.players,
+		Scoreboard:   buf.scores,
+		Items:        buf.items,
+		ItemsLeft:    len(gs.Items),
+		PlayerCount:  len(buf.views),
+		Spectators:   len(gs.Spectators),
+		Obstacles:    gs.Obstacles, // Nunca muda depois da criação da sala, então pode ser compartilhado
+		Ice:          gs.Ice,       // Também fixo desde a criação da sala
+		Teleports:    gs.Teleports,
+		BoardWidth:   gs.BoardWidth,
+		BoardHeight:  gs.BoardHeight,
+		GameOver:     gs.GameOver,
+		WinnerIDs:    gs.WinnerIDs,
+		TeamScores:   gs.teamScoresLocked(),
+		WinningTeams: gs.WinningTeams,
+		ChampionIDs:  gs.ChampionIDs,
+		TickMs:       gs.TickMs,
+		ViewRadius:   gs.viewRadius,
+		Wrap:         gs.wrap,
+		TargetScore:  gs.targetScore,
+		buffers:      buf,
+	}
+	if gs.duration > 0 {
+		remaining := int((gs.remainingLocked() + time.Second - 1) / time.Second) // Arredonda para cima
+		snapshot.Remaining = &remaining
+		snapshot.Overtime = gs.inOvertimeLocked()
+	}
+	if gs.roundsOn() {
+		snapshot.Round, snapshot.Rounds = gs.round, gs.rounds
+	}
+	if gs.GameOver && gs.autoRestart > 0 {
+		restartIn := int((gs.restartInLocked() + time.Second - 1) / time.Second)
+		snapshot.RestartIn = &restartIn
+	}
+	return snapshot
+}
+
+// snapshotForClient serializa o estado completo da sala como é enviado aos clientes.
+// É o mesmo conteúdo do broadcast de cada tick, da conexão inicial e do pedido de "resync". Com advance (só o
+// broadcast), o snapshot recebe o próximo número de sequência; os avulsos levam o do último broadcast, que
+// serve de base para o cliente conferir os seguintes. No modo de visão limitada, o snapshot é recortado em
+// volta do jogador viewerID (espectadores e IDs desconhecidos recebem tudo).
+func (gs *GameState) snapshotForClient(advance bool, viewerID string) ([]byte, error) {
+	gs.rLockAll() // Só leitura: vários snapshots (broadcast, resync, conexões novas) podem ser montados ao mesmo tempo
+	snapshot := gs.snapshotLocked()
+	if advance {
+		snapshot.Seq = gs.seq.Add(1) // Atômico, pois o lock aqui é só de leitura
+	} else {
+		snapshot.Seq = gs.seq.Load()
+	}
+	visible := snapshot
+	if p, ok := gs.Players[viewerID]; ok && gs.viewRadius > 0 {
+		visible = gs.visibleFrom(snapshot, p.Pos, gs.itemsWithinLocked(p.Pos, gs.viewRadius))
+	}
+	gs.rUnlockAll() // Libera o mutex assim que a cópia é feita
+
+	defer snapshot.release()
+	return json.Marshal(visible)
+}
+
+// SendSnapshot envia o estado completo da sala para uma única conexão, fora da cadência dos ticks
+func (gs *GameState) SendSnapshot(id string, sendChan chan []byte) bool {
+	message, err := gs.snapshotForClient(false, id)
+	if err != nil {
+		slog.Error("Erro ao serializar estado do jogo", "room", gs.RoomID, "err", err)
+		return false
+	}
+	return gs.Send(id, sendChan, message)
+}
+
+// Send entrega uma mensagem a uma única conexão, sem bloquear. O canal é conferido sob o lock, então uma
+// conexão que já foi fechada (por desconexão, reconexão ou shutdown) é ignorada em vez de causar pânico.
+// Retorna false se a mensagem não foi entregue.
+func (gs *GameState) Send(id string, sendChan chan []byte, message []byte) bool {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	p, ok := gs.Players[id]
+	if !ok {
+		p, ok = gs.Spectators[id]
+	}
+	if !ok || !p.IsActive || p.sendChan != sendChan {
+		return false
+	}
+	select {
+	case sendChan <- message:
+		gs.noteDeliveryLocked(p, true)
+		return true
+	default:
+		slog.Debug("Canal de envio cheio, descartando mensagem", "room", gs.RoomID, "player_id", id)
+		gs.metrics.MessageDropped(gs.RoomID)
+		gs.noteDeliveryLocked(p, false)
+		return false
+	}
+}
+
+// noteDeliveryLocked conta envios descartados seguidos de uma conexão. Passando de slowClientLimit, o cliente
+// é considerado lento demais e desconectado, para reconectar e receber o estado completo em vez de continuar
+// com uma visão corrompida. Quem chama deve segurar playersMu para escrita (e não itemsMu).
+func (gs *GameState) noteDeliveryLocked(p *Player, delivered bool) {
+	if delivered {
+		p.dropped = 0
+		return
+	}
+	p.dropped++
+	if !p.overflowed { // Um canal cheio de vez em quando é normal; o aviso ajuda a calibrar SEND_BUFFER
+		p.overflowed = true
+		slog.Warn("Canal de envio cheio, mensagens descartadas", "room", gs.RoomID, "player_id", p.ID, "send_buffer", gs.sendBuffer)
+	}
+	if gs.slowClientLimit <= 0 || p.dropped < gs.slowClientLimit {
+		return
+	}
+	slog.Warn("Desconectando cliente lento (canal de envio cheio)", "room", gs.RoomID, "player_id", p.ID, "dropped", p.dropped)
+	if p.Spectator {
+		gs.removeSpectatorLocked(p.ID)
+	} else {
+		gs.disconnectLocked(p)
+	}
+}
+
+// recipient é uma conexão que recebe os broadcasts, copiada sob o lock para que os envios aconteçam sem ele
+type recipient struct {
+	id       string
+	sendChan chan []byte
+	pos      Point
+	player   bool   // Jogador (com posição), e não espectador
+	message  []byte // O que enviar; nil pula a conexão
+}
+
+// recipientPool reaproveita a lista de destinatários entre broadcasts
+var recipientPool = sync.Pool{New: func() any {
+	recipients := make([]recipient, 0, 16)
+	return &recipients
+}}
+
+// releaseRecipients devolve a lista ao pool, sem as referências aos canais e mensagens
+func releaseRecipients(buf *[]recipient, recipients []recipient) {
+	clear(recipients)
+	*buf = recipients[:0]
+	recipientPool.Put(buf)
+}
+
+// recipientsLocked acrescenta a dst os jogadores e espectadores ativos e atualiza as métricas de tamanho da sala.
+// O canal é lido sob o lock porque uma reconexão troca o sendChan do jogador. Quem chama deve segurar os dois
+// mutexes (leitura basta).
+func (gs *GameState) recipientsLocked(dst []recipient) []recipient {
+	for _, player := range gs.Players {
+		if player.IsActive {
+			dst = append(dst, recipient{id: player.ID, sendChan: player.sendChan, pos: player.Pos, player: true})
+		}
+	}
+	activePlayers := len(dst)
+	for _, spectator := range gs.Spectators {
+		if spectator.IsActive {
+			dst = append(dst, recipient{id: spectator.ID, sendChan: spectator.sendChan})
+		}
+	}
+	gs.metrics.RoomSize(gs.RoomID, activePlayers, len(dst)-activePlayers, len(gs.Items))
+	return dst
+}
+
+// BroadcastGameState envia o estado atual do jogo para todos os jogadores e espectadores ativos. Por padrão o
+// estado é serializado uma vez só para todos; no modo de visão limitada, cada jogador recebe o seu recorte,
+// serializado separadamente.
+func (gs *GameState) BroadcastGameState() {
+	start := time.Now()
+	defer func() { gs.metrics.BroadcastDuration(gs.RoomID, time.Since(start)) }()
+
+	if gs.viewRadius == 0 {
+		message, err := gs.snapshotForClient(true, "")
+		if err != nil {
+			slog.Error("Erro ao serializar estado do jogo", "room", gs.RoomID, "err", err)
+			return
+		}
+		gs.Broadcast(message)
+		return
+	}
+
+	buf := recipientPool.Get().(*[]recipient)
+	gs.rLockAll()
+	snapshot := gs.snapshotLocked()
+	snapshot.Seq = gs.seq.Add(1)
+	recipients := gs.recipientsLocked((*buf)[:0])
+	visible := make([][]*Item, len(recipients)) // Itens de cada jogador, buscados no índice espacial enquanto o lock está travado
+	for i, r := range recipients {
+		if r.player {
+			visible[i] = gs.itemsWithinLocked(r.pos, gs.viewRadius)
+		}
+	}
+	gs.rUnlockAll()
+	defer releaseRecipients(buf, recipients)
+	defer snapshot.release()
+
+	full, err := json.Marshal(snapshot) // Para os espectadores
+	if err != nil {
+		slog.Error("Erro ao serializar estado do jogo", "room", gs.RoomID, "err", err)
+		return
+	}
+	for i, r := range recipients {
+		if !r.player {
+			recipients[i].message = full
+			continue
+		}
+		if recipients[i].message, err = json.Marshal(gs.visibleFrom(snapshot, r.pos, visible[i])); err != nil {
+			slog.Error("Erro ao serializar estado do jogo", "room", gs.RoomID, "player_id", r.id, "err", err)
+		}
+	}
+	gs.deliver(recipients)
+}
+
+// Broadcast entrega uma mensagem a todos os jogadores e espectadores ativos da sala, sem bloquear, com a mesma
+// contagem de descartes de Send. Retorna quantas conexões receberam a mensagem.
+func (gs *GameState) Broadcast(message []byte) int {
+	buf := recipientPool.Get().(*[]recipient)
+	gs.rLockAll() // itemsMu só para a contagem de itens das métricas
+	recipients := gs.recipientsLocked((*buf)[:0])
+	gs.rUnlockAll()
+	defer releaseRecipients(buf, recipients)
+
+	for i := range recipients {
+		recipients[i].message = message
+	}
+	return gs.deliver(recipients)
+}
+
+// deliver envia a mensagem de cada destinatário sem bloquear (mensagens nil são puladas) e registra as entregas
+// e descartes de cada conexão. Retorna quantas conexões receberam a mensagem.
+//
+// Os envios acontecem sob playersMu, conferindo se o canal ainda é o atual da conexão: um sendChan só é fechado
+// sob esse mesmo lock, junto com IsActive = false ou a troca do canal numa reconexão, então quem saiu ou
+// reconectou desde a cópia dos destinatários é pulado em vez de causar "send on closed channel". Os envios não
+// bloqueiam, então o lock fica travado só pelo tempo de percorrer a lista; a serialização já ficou de fora.
+func (gs *GameState) deliver(recipients []recipient) int {
+	gs.playersMu.Lock() // A contagem de descartes altera os jogadores, então aqui o lock é exclusivo
+	defer gs.playersMu.Unlock()
+
+	count := 0
+	for _, r := range recipients {
+		if r.message == nil {
+			continue
+		}
+		p, ok := gs.Players[r.id]
+		if !ok {
+			p, ok = gs.Spectators[r.id]
+		}
+		if !ok || !p.IsActive || p.sendChan != r.sendChan {
+			continue
+		}
+		select {
+		case r.sendChan <- r.message:
+			count++
+			gs.noteDeliveryLocked(p, true)
+		default:
+			slog.Debug("Canal de envio cheio, descartando mensagem", "room", gs.RoomID, "player_id", r.id)
+			gs.metrics.MessageDropped(gs.RoomID)
+			gs.noteDeliveryLocked(p, false)
+		}
+	}
+	return count
+}
@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drain esvazia os canais de envio sem bloquear, no papel dos 'writer's
+func drain(chans []chan []byte) {
+	for _, ch := range chans {
+		for len(ch) > 0 {
+			<-ch
+		}
+	}
+}
+
+// readState decodifica a próxima mensagem do canal como um snapshot de estado
+func readState(t *testing.T, ch chan []byte) stateSnapshot {
+	t.Helper()
+	select {
+	case data := <-ch:
+		var state stateSnapshot
+		if err := json.Unmarshal(data, &state); err != nil {
+			t.Fatalf("snapshot inválido %q: %v", data, err)
+		}
+		return state
+	default:
+		t.Fatal("nenhum snapshot no canal")
+		return stateSnapshot{}
+	}
+}
+
+func TestItemsRemainingAfterEachCollection(t *testing.T) {
+	for _, viewRadius := range []int{0, 1} {
+		t.Run(fmt.Sprintf("visao=%d", viewRadius), func(t *testing.T) {
+			gs := newTestGame(t, Config{ViewRadius: viewRadius})
+			p := joinAt(t, gs, "a", Point{0, 2})
+			ch := joinAt(t, gs, "b", Point{4, 0}).sendChan // Com a visão limitada, b não vê nenhum item, mas a contagem é a mesma
+			for x := 1; x <= 3; x++ {
+				putItem(gs, Point{x, 2}, 1)
+			}
+			putItem(gs, Point{4, 4}, 1) // Fora do raio de a e de b
+
+			for want := 4; want > 0; want-- {
+				if want < 4 {
+					if err := gs.QueueMove("a", "right"); err != nil {
+						t.Fatalf("QueueMove: %v", err)
+					}
+					gs.ProcessTick()
+				}
+				gs.BroadcastGameState()
+				state := readState(t, ch)
+				if state.ItemsLeft != want || len(gs.Items) != want {
+					t.Errorf("depois de %d coletas: itemsRemaining %d, a sala tem %d, deveriam ser %d", p.Score, state.ItemsLeft, len(gs.Items), want)
+				}
+				if viewRadius == 0 && len(state.Items) != state.ItemsLeft {
+					t.Errorf("itemsRemaining %d com %d itens no snapshot completo", state.ItemsLeft, len(state.Items))
+				}
+			}
+
+			// O último item encerra a partida, e o estado final mostra zero
+			gs.lockAll()
+			gs.moveHeadLocked(p, Point{4, 3})
+			gs.unlockAll()
+			if err := gs.QueueMove("a", "down"); err != nil {
+				t.Fatalf("QueueMove: %v", err)
+			}
+			gs.ProcessTick()
+			gs.BroadcastGameState()
+			if state := readState(t, ch); state.ItemsLeft != 0 || !state.GameOver {
+				t.Errorf("estado final: itemsRemaining %d, gameOver %v", state.ItemsLeft, state.GameOver)
+			}
+		})
+	}
+}
+
+func TestSnapshotLifecycle(t *testing.T) {
+	gs := newTestGame(t, Config{})
+	obs := joinAt(t, gs, "obs", Point{0, 0})
+	for x := 1; x <= 4; x++ {
+		putItem(gs, Point{x, 0}, 1)
+	}
+	putItem(gs, Point{4, 4}, 1) // Para a partida não acabar
+
+	var lastSeq uint64
+	for round := 0; round < 4; round++ {
+		gs.BroadcastGameState()
+		state := readState(t, obs.sendChan)
+		if round > 0 && state.Seq != lastSeq+1 {
+			t.Errorf("broadcast %d: seq %d depois de %d", round, state.Seq, lastSeq)
+		}
+		lastSeq = state.Seq
+		if state.ItemsLeft != len(state.Items) || state.ItemsLeft != 5-round {
+			t.Errorf("broadcast %d: itemsRemaining %d com %d itens no snapshot, deveriam ser %d", round, state.ItemsLeft, len(state.Items), 5-round)
+		}
+		if _, ok := state.Players["fantasma"]; ok || state.PlayerCount != 1 || len(state.Scoreboard) != 1 {
+			t.Errorf("broadcast %d: %d jogadores (placar com %d), deveria ter só obs", round, state.PlayerCount, len(state.Scoreboard))
+		}
+
+		// Entre dois broadcasts: um jogador entra e sai, e obs coleta um item
+		if _, _, err := gs.AddPlayer("fantasma", "fantasma", 0); err != nil {
+			t.Fatal(err)
+		}
+		gs.RemovePlayer("fantasma")
+		gs.QueueMove("obs", "right")
+		gs.ProcessTick()
+
+		// O snapshot avulso (conexão nova ou resync) repete o seq do último broadcast
+		gs.SendSnapshot("obs", obs.sendChan)
+		if state := readState(t, obs.sendChan); state.Seq != lastSeq {
+			t.Errorf("snapshot avulso com seq %d, deveria repetir %d", state.Seq, lastSeq)
+		}
+	}
+}
+
+// Compare as alocações com -benchmem (ou pelo b.ReportAllocs) antes e depois de mexer no snapshot
+func BenchmarkBroadcastGameState(b *testing.B) {
+	for _, bc := range []struct {
+		players, viewRadius int
+	}{{20, 0}, {100, 0}, {20, 5}} {
+		b.Run(fmt.Sprintf("jogadores=%d/visao=%d", bc.players, bc.viewRadius), func(b *testing.B) {
+			gs := newTestGame(b, Config{BoardWidth: 40, BoardHeight: 30, NumItems: 60, ViewRadius: bc.viewRadius})
+			var chans []chan []byte
+			for i := 0; i < bc.players; i++ {
+				id := fmt.Sprintf("p%d", i)
+				_, ch, err := gs.AddPlayer(id, id, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				chans = append(chans, ch)
+			}
+			gs.InitializeItems()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				gs.BroadcastGameState()
+				drain(chans)
+			}
+		})
+	}
+}
+
+// Rode com -race: broadcasts contínuos enquanto jogadores caem e voltam e, no fim, enquanto a sala fecha todas as
+// conexões. Um envio num canal já fechado derrubaria o teste com pânico.
+func TestBroadcastDuringDisconnect(t *testing.T) {
+	gs := newTestGame(t, Config{BoardWidth: 10, BoardHeight: 10, NumItems: 10, ReconnectGrace: time.Minute})
+	var writers sync.WaitGroup
+	consume := func(ch chan []byte) { // O papel do 'writer': lê até o canal ser fechado
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for range ch {
+			}
+		}()
+	}
+	ids := make([]string, 8)
+	chans := make(map[string]chan []byte)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("p%d", i)
+		_, ch, err := gs.AddPlayer(ids[i], ids[i], 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chans[ids[i]] = ch
+		consume(ch)
+	}
+	_, spectator := gs.AddSpectator("espectador")
+	consume(spectator)
+	gs.InitializeItems()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // O papel do gameLoop
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			gs.ProcessTick()
+			gs.BroadcastGameState()
+		}
+	}()
+
+	for round := 0; round < 300; round++ {
+		id := ids[round%len(ids)]
+		gs.DisconnectPlayer(id, chans[id])
+		_, ch, err := gs.ReconnectPlayer(id)
+		if err != nil {
+			t.Fatalf("ReconnectPlayer(%q): %v", id, err)
+		}
+		chans[id] = ch
+		consume(ch)
+	}
+	gs.CloseAllPlayers()
+	time.Sleep(10 * time.Millisecond) // Mais alguns broadcasts com a sala já fechada
+	close(stop)
+	wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("algum canal de envio não foi fechado")
+	}
+}
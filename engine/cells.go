@@ -0,0 +1,71 @@
+package engine
+
+import "fmt"
+
+// As células livres (sem parede, item, jogador ativo nem rastro) ficam num slice, com o índice de cada uma num mapa,
+// para que o nascimento de itens e jogadores sorteie uma posição em tempo constante em vez de tentar posições
+// aleatórias até acertar uma vazia, o que fica lento com o tabuleiro quase cheio e nunca termina com ele cheio.
+// As funções abaixo mexem nessa estrutura; quem chama deve segurar itemsMu para escrita e playersMu (leitura basta).
+// A ocupação por jogadores vem de playerCells e trailCells, mantidos junto com Pos, Body e IsActive de cada
+// jogador, para que conferir uma célula não dependa do número de jogadores na sala.
+
+// resetFreeCellsLocked recalcula do zero o índice de ocupação e as células livres a partir do tabuleiro, dos itens
+// e dos jogadores ativos. Aqui playersMu precisa estar travado para escrita.
+func (gs *GameState) resetFreeCellsLocked() {
+	gs.playerCells = make(map[Point]*Player, len(gs.Players))
+	gs.trailCells = make(map[Point]*Player)
+	for _, p := range gs.Players {
+		if !p.IsActive {
+			continue
+		}
+		gs.playerCells[p.Pos] = p
+		for _, segment := range p.Body {
+			gs.trailCells[segment] = p
+		}
+	}
+	gs.freeCells = make([]Point, 0, gs.BoardWidth*gs.BoardHeight)
+	gs.freeIndex = make(map[Point]int, gs.BoardWidth*gs.BoardHeight)
+	for y := 0; y < gs.BoardHeight; y++ {
+		for x := 0; x < gs.BoardWidth; x++ {
+			gs.refreshCellLocked(Point{X: x, Y: y})
+		}
+	}
+}
+
+// refreshCellLocked coloca a célula no conjunto de livres ou a tira dele conforme sua ocupação atual.
+// Deve ser chamada para toda célula em que um item, jogador ativo ou segmento de rastro entrou ou saiu.
+func (gs *GameState) refreshCellLocked(pos Point) {
+	_, hasItem := gs.Items[fmt.Sprintf("%d,%d", pos.X, pos.Y)]
+	occupied := hasItem || gs.obstacleSet[pos] || gs.playerAt(pos) != nil || gs.trailAt(pos) != nil
+	idx, isFree := gs.freeIndex[pos]
+	switch {
+	case occupied && isFree: // Remove trocando com o último, sem deslocar o slice
+		last := gs.freeCells[len(gs.freeCells)-1]
+		gs.freeCells[idx] = last
+		gs.freeIndex[last] = idx
+		gs.freeCells = gs.freeCells[:len(gs.freeCells)-1]
+		delete(gs.freeIndex, pos)
+	case !occupied && !isFree:
+		gs.freeIndex[pos] = len(gs.freeCells)
+		gs.freeCells = append(gs.freeCells, pos)
+	}
+}
+
+// moveHeadLocked leva o jogador ativo p para a célula to, atualizando o índice de ocupação e as células livres.
+// Aqui playersMu precisa estar travado para escrita.
+func (gs *GameState) moveHeadLocked(p *Player, to Point) {
+	from := p.Pos
+	delete(gs.playerCells, from)
+	p.Pos = to
+	gs.playerCells[to] = p
+	gs.refreshCellLocked(from)
+	gs.refreshCellLocked(to)
+}
+
+// randomFreeCellLocked sorteia uma célula livre. Retorna false se o tabuleiro estiver cheio.
+func (gs *GameState) randomFreeCellLocked() (Point, bool) {
+	if len(gs.freeCells) == 0 {
+		return Point{}, false
+	}
+	return gs.freeCells[gs.rng.Intn(len(gs.freeCells))], true
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	MaxChatLength = 140 // Tamanho máximo (em caracteres) de uma mensagem de chat; o excesso é cortado
+)
+
+// ChatLine é uma mensagem de chat aceita, já limpa e identificada com o remetente
+type ChatLine struct {
+	PlayerID string
+	Name     string
+	Text     string
+	Time     time.Time
+}
+
+// Chat valida uma mensagem de chat de um jogador: remove caracteres de controle, corta o texto em MaxChatLength
+// e aplica o limite de taxa (Config.ChatInterval). A camada de transporte monta a mensagem e a envia à sala
+// com Broadcast. Retorna ErrChatEmpty se não sobrar texto, ErrChatRateExceeded acima do limite ou
+// ErrPlayerInactive se o jogador não está conectado.
+func (gs *GameState) Chat(playerID string, text string) (ChatLine, error) {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	player, ok := gs.Players[playerID]
+	if !ok || !player.IsActive {
+		return ChatLine{}, ErrPlayerInactive
+	}
+	text = sanitizeText(text, MaxChatLength)
+	if text == "" {
+		return ChatLine{}, ErrChatEmpty
+	}
+	now := gs.now()
+	if gs.chatInterval > 0 && now.Sub(player.lastChat) < gs.chatInterval {
+		return ChatLine{}, ErrChatRateExceeded // Só mensagens aceitas contam, então quem insiste não fica bloqueado para sempre
+	}
+	player.lastChat = now
+
+	slog.Debug("Mensagem de chat", "room", gs.RoomID, "player_id", playerID, "action", "chat", "text", text)
+	return ChatLine{PlayerID: playerID, Name: player.Name, Text: text, Time: now}, nil
+}
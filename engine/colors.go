@@ -0,0 +1,28 @@
+package engine
+
+import "hash/fnv"
+
+// playerPalette são as cores dos jogadores, escolhidas para se distinguirem bem entre si e do fundo do tabuleiro
+var playerPalette = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4", "#42d4f4",
+	"#f032e6", "#bfef45", "#469990", "#9a6324", "#800000", "#000075",
+}
+
+// assignColorLocked escolhe a cor de um jogador que está entrando: a primeira da paleta que nenhum jogador da sala
+// usa (contando os que aguardam reconexão, que voltam com a mesma cor). A cor volta a ficar livre quando o jogador
+// sai, então a paleta nunca se esgota num servidor de longa duração; só com mais jogadores que cores na mesma sala
+// a cor é derivada do ID e pode se repetir. Quem chama deve segurar playersMu.
+func (gs *GameState) assignColorLocked(id string) string {
+	used := make(map[string]bool, len(gs.Players))
+	for _, p := range gs.Players {
+		used[p.Color] = true
+	}
+	for _, color := range playerPalette {
+		if !used[color] {
+			return color
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return playerPalette[h.Sum32()%uint32(len(playerPalette))]
+}
@@ -0,0 +1,83 @@
+package engine
+
+import "time"
+
+// EventType identifica o acontecimento descrito por um GameEvent
+type EventType string
+
+const (
+	EventPlayerJoined  EventType = "player_joined"
+	EventPlayerLeft    EventType = "player_left" // Saída definitiva (não conta desconexões ainda dentro do prazo de reconexão)
+	EventItemCollected EventType = "item_collected"
+	EventGameOver      EventType = "game_over"
+)
+
+// GameEvent descreve um acontecimento da partida para quem quiser reagir a ele (webhooks, análises, logs).
+// Só os campos que fazem sentido para o Type são preenchidos.
+type GameEvent struct {
+	Type        EventType     `json:"type"`
+	RoomID      string        `json:"roomId"`
+	Time        time.Time     `json:"time"`
+	PlayerID    string        `json:"playerId,omitempty"`    // Entrada, saída e coleta
+	Name        string        `json:"name,omitempty"`        // Entrada
+	Bot         bool          `json:"bot,omitempty"`         // Entrada e saída de bots
+	ItemKind    string        `json:"itemKind,omitempty"`    // Coleta
+	Value       int           `json:"value,omitempty"`       // Coleta: pontos ganhos, já com o multiplicador de sequência
+	Score       int           `json:"score,omitempty"`       // Coleta: pontuação do jogador depois dela
+	WinnerIDs   []string      `json:"winnerIds,omitempty"`   // Fim de jogo
+	Scores      []PlayerStats `json:"scores,omitempty"`      // Fim de jogo: pontuação final dos jogadores ativos
+	Duration    time.Duration `json:"duration,omitempty"`    // Fim de jogo: duração da partida
+	Round       int           `json:"round,omitempty"`       // Fim de jogo no modo de rodadas: rodada que terminou
+	ChampionIDs []string      `json:"championIds,omitempty"` // Fim de jogo na última rodada: campeão(ões) da série
+}
+
+// EventSink recebe os eventos de uma sala. Publish é chamado com os mutexes da sala travados, então não pode
+// bloquear nem chamar de volta o GameState: quem precisa de trabalho demorado deve repassar o evento para
+// outra goroutine (como faz ChannelSink).
+type EventSink interface {
+	Publish(GameEvent)
+}
+
+// noEvents é usado quando Config.Events não é definido
+type noEvents struct{}
+
+func (noEvents) Publish(GameEvent) {}
+
+// ChannelSink entrega os eventos num canal bufferizado, a ser consumido por outra goroutine. Se o consumidor
+// ficar para trás e o buffer encher, os eventos novos são descartados em vez de travar a partida.
+type ChannelSink struct {
+	C       chan GameEvent
+	dropped func(GameEvent) // Chamado para cada evento descartado (pode ser nil)
+}
+
+// NewChannelSink cria um ChannelSink com buffer para 'size' eventos. onDrop, se não for nil, é avisado de cada
+// evento descartado por buffer cheio.
+func NewChannelSink(size int, onDrop func(GameEvent)) *ChannelSink {
+	return &ChannelSink{C: make(chan GameEvent, size), dropped: onDrop}
+}
+
+func (s *ChannelSink) Publish(e GameEvent) {
+	select {
+	case s.C <- e:
+	default:
+		if s.dropped != nil {
+			s.dropped(e)
+		}
+	}
+}
+
+// publish completa o evento com a sala e o horário e o entrega ao EventSink da sala
+func (gs *GameState) publish(e GameEvent) {
+	e.RoomID = gs.RoomID
+	e.Time = gs.now()
+	gs.events.Publish(e)
+}
+
+// MultiSink repassa cada evento para vários EventSinks, na ordem
+type MultiSink []EventSink
+
+func (m MultiSink) Publish(e GameEvent) {
+	for _, sink := range m {
+		sink.Publish(e)
+	}
+}
@@ -0,0 +1,884 @@
+// Package engine contém as regras do jogo: tabuleiro, jogadores, itens, movimentos e o estado enviado aos clientes.
+// Não conhece WebSocket nem estado global; a camada de transporte (package main) só troca mensagens pelos sendChan.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config reúne os parâmetros de uma sala de jogo
+type Config struct {
+	BoardWidth      int
+	BoardHeight     int
+	NumItems        int
+	ItemDensity     float64           // Fração das células sem parede coberta de itens a cada partida (0 = usa NumItems)
+	TickDelay       time.Duration     // Passo fixo da simulação: intervalo entre ticks (ProcessTick) do gameLoop
+	BroadcastDelay  time.Duration     // Intervalo entre broadcasts do estado (0 = um por tick, TickDelay)
+	ObstacleCount   int               // Quantidade de paredes geradas em cada sala
+	ObstacleSeed    int64             // Seed do layout de paredes, para reproduzir o mesmo tabuleiro
+	IceCount        int               // Células de gelo geradas em cada sala, com o mesmo seed das paredes (0 = sem gelo)
+	TeleportPairs   int               // Pares de portais gerados em cada sala, com o mesmo seed das paredes (0 = sem portais)
+	RandomSeed      int64             // Seed do sorteio de itens e posições iniciais (0 = derivado do relógio em cada sala)
+	GameDuration    time.Duration     // Duração máxima de uma partida (0 = sem limite de tempo)
+	RespawnInterval time.Duration     // Intervalo entre reaparições de itens (0 desliga o modo contínuo)
+	RespawnTarget   int               // Quantidade de itens que o modo contínuo tenta manter no tabuleiro
+	MoveInterval    time.Duration     // Intervalo mínimo entre movimentos de um mesmo jogador (0 desliga o limite)
+	MovesPerTick    int               // Movimentos de um jogador aplicados por tick, em ordem (0 ou 1 = só a intenção mais recente)
+	ReconnectGrace  time.Duration     // Janela em que um jogador desconectado mantém posição e pontuação
+	SlowClientLimit int               // Mensagens descartadas seguidas antes de desconectar um cliente lento (0 = nunca desconecta)
+	AutoRestart     time.Duration     // Espera entre o fim de uma partida e o início automático da próxima (0 = só reset manual)
+	Trail           bool              // Modo rastro: itens coletados viram segmentos atrás do jogador, e bater num rastro elimina
+	SpeedBoost      time.Duration     // Duração do power-up de velocidade (0 = o power-up não aparece)
+	GoldenLifetime  time.Duration     // Tempo que o diamante dourado fica no tabuleiro antes de sumir (0 = ele não aparece)
+	GoldenValue     int               // Pontos do diamante dourado
+	GoldenChance    float64           // Chance, a cada segundo de jogo, de o diamante dourado aparecer (0 = ele não aparece)
+	ItemSymbols     map[string]string // Símbolo de cada tipo de item, enviado em Item.Symbol (tipo ausente = símbolo padrão do cliente)
+	ComboWindow     time.Duration     // Tempo máximo entre duas coletas para a sequência continuar (0 desliga o multiplicador)
+	ComboMax        int               // Multiplicador máximo da sequência (1 ou menos desliga)
+	TieBreak        bool              // Empate na maior pontuação vai para quem a atingiu primeiro (fora do modo de equipes)
+	Overtime        time.Duration     // Duração máxima da prorrogação quando o tempo acaba com empate (0 = empatados dividem a vitória)
+	Wrap            bool              // Tabuleiro toroidal: sair por uma borda entra pela oposta em vez de parar nela
+	TargetScore     int               // Pontuação que encerra a partida, dando a vitória a quem a atingir primeiro (0 = desligado)
+	Teams           int               // Quantidade de equipes (0 = todos contra todos, até MaxTeams)
+	BotCount        int               // Bots mantidos na sala enquanto há poucos jogadores reais (0 = sem bots)
+	BotThreshold    int               // Número de jogadores reais a partir do qual os bots saem
+	BotSkill        int               // Porcentagem (0 a 100) de movimentos dos bots que seguem o menor caminho; o resto é aleatório
+	MaxPlayers      int               // Jogadores reais por sala, contando os que aguardam reconexão (0 = sem limite); bots e espectadores não contam
+	IdleTimeout     time.Duration     // Tempo sem movimentos depois do qual um jogador é removido por KickIdle (0 = nunca)
+	ChatInterval    time.Duration     // Intervalo mínimo entre mensagens de chat de um mesmo jogador (0 desliga o limite)
+	ViewRadius      int               // Distância (em células) até onde cada jogador recebe os outros jogadores e os itens (0 = tabuleiro inteiro)
+	Rounds          int               // Partidas por série no modo de rodadas, que declara um campeão ao fim da última (0 ou 1 = desligado)
+	SendBuffer      int               // Mensagens enfileiradas por conexão antes de começar a descartar (0 = DefaultSendBuffer)
+	StartScore      int               // Pontuação de cada jogador ao entrar e a cada nova partida
+	ScoreFloor      int               // Pontuação mínima: bombas não levam ninguém abaixo dela
+	NoScoreFloor    bool              // Sem pontuação mínima: bombas podem deixar a pontuação negativa (ignora ScoreFloor)
+	Metrics         Metrics           `json:"-"` // Destino das métricas da sala (nil = nenhum)
+	Events          EventSink         `json:"-"` // Destino dos eventos da partida (nil = nenhum)
+	Recorder        *Recorder         `json:"-"` // Gravação da sala, para reproduzi-la com Replay (nil = não grava)
+}
+
+// BroadcastInterval é a cadência efetiva dos broadcasts: BroadcastDelay ou, sem ele, a dos ticks
+func (c Config) BroadcastInterval() time.Duration {
+	if c.BroadcastDelay > 0 {
+		return c.BroadcastDelay
+	}
+	return c.TickDelay
+}
+
+// Erros retornados por QueueMove (e pelas demais ações dos jogadores), para que a camada de transporte avise o cliente
+var (
+	ErrGameOver         = errors.New("a partida já terminou")
+	ErrInvalidDirection = errors.New("direção inválida")
+	ErrMoveRateExceeded = errors.New("movimentos rápidos demais")
+	ErrPlayerInactive   = errors.New("jogador não está ativo na sala")
+	ErrBoardFull        = errors.New("não há célula livre no tabuleiro")
+	ErrEliminated       = errors.New("jogador eliminado nesta partida")
+	ErrRoomFull         = errors.New("a sala atingiu o limite de jogadores")
+	ErrChatEmpty        = errors.New("mensagem de chat vazia")
+	ErrChatRateExceeded = errors.New("mensagens de chat rápidas demais")
+)
+
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type Item struct {
+	ID     string `json:"id"`
+	Pos    Point  `json:"pos"`
+	Kind   string `json:"kind"`             // Tipo do item, usado pelo cliente para escolher o símbolo
+	Value  int    `json:"value"`            // Pontos ganhos ao coletar
+	Symbol string `json:"symbol,omitempty"` // Símbolo configurado para o tipo, que substitui o padrão do cliente
+
+	ExpiresMs int64     `json:"expiresMs,omitempty"` // Só no diamante dourado: instante (na escala de serverMs) em que ele some
+	expiresAt time.Time // O mesmo instante no relógio da sala
+}
+
+type GameState struct {
+	RoomID          string             `json:"roomId"`
+	Players         map[string]*Player `json:"players"`
+	Items           map[string]*Item   `json:"items"`
+	Spectators      map[string]*Player `json:"-"`         // Conexões que apenas assistem à partida
+	Obstacles       []Point            `json:"obstacles"` // Paredes fixas, geradas na criação da sala
+	obstacleSet     map[Point]bool     // Mesmas paredes, indexadas para consulta rápida
+	Ice             []Point            `json:"ice"` // Células de gelo, onde o movimento continua deslizando
+	iceSet          map[Point]bool     // Mesmo gelo, indexado para consulta rápida
+	Teleports       []Teleport         `json:"teleports"` // Pares de portais
+	teleportTo      map[Point]Point    // Destino de cada portal (o outro do par)
+	itemGrid        itemGrid           // Os mesmos itens de Items, indexados por região para buscas por raio
+	wake            chan struct{}      // Sinalizado a cada conexão nova; lido pelo gameLoop via Wake
+	freeCells       []Point            // Células sem parede, item nem jogador ativo, sorteadas ao nascer itens e jogadores
+	freeIndex       map[Point]int      // Posição de cada célula livre em freeCells
+	playerCells     map[Point]*Player  // Jogador ativo em cada célula, para playerAt não percorrer a sala
+	trailCells      map[Point]*Player  // Dono de cada segmento de rastro, para trailAt não percorrer a sala
+	BoardWidth      int                `json:"boardWidth"`
+	BoardHeight     int                `json:"boardHeight"`
+	GameOver        bool               `json:"gameOver"`
+	WinnerIDs       []string           `json:"winnerIds,omitempty"`    // Mais de um ID em caso de empate
+	WinningTeams    []int              `json:"winningTeams,omitempty"` // No modo de equipes, a(s) equipe(s) vencedora(s)
+	ChampionIDs     []string           `json:"championIds,omitempty"`  // No modo de rodadas, campeão(ões) da série, só depois da última rodada
+	TickMs          int                `json:"tickMs"`                 // Cadência dos broadcasts, para o cliente saber com que frequência esperar atualizações
+	numItems        int                // Quantidade de itens espalhados a cada partida
+	itemDensity     float64            // Com valor positivo, substitui numItems por uma fração das células sem parede
+	startedAt       time.Time          // Início da partida atual, para o modo com tempo limite
+	duration        time.Duration      // Duração máxima da partida; 0 desliga o cronômetro
+	endedAt         time.Time          // Fim da partida atual, para o reinício automático
+	autoRestart     time.Duration      // Espera até o reinício automático; 0 deixa a sala em GameOver até um reset manual
+	targetScore     int                // Pontos para vencer na hora; 0 deixa a partida ir até o fim dos itens ou do tempo
+	startScore      int                // Pontuação inicial de cada jogador em cada partida
+	scoreFloor      int                // Pontuação mínima, quando noScoreFloor é falso
+	noScoreFloor    bool               // Pontuações negativas sem limite
+	rounds          int                // Partidas por série no modo de rodadas; 0 ou 1 desliga
+	round           int                // Rodada atual da série (a partir de 1), só no modo de rodadas
+	teams           int                // Quantidade de equipes; 0 desliga o modo de equipes
+	wrap            bool               // Bordas ligadas às opostas (tabuleiro toroidal)
+	trail           bool               // Modo rastro (estilo snake)
+	speedBoost      time.Duration      // Duração do power-up de velocidade
+	goldenLifetime  time.Duration      // Tempo de vida do diamante dourado; 0 desliga
+	goldenValue     int                // Pontos do diamante dourado
+	golden          *Item              // Diamante dourado no tabuleiro, se houver (também está em Items)
+	goldenChance    float64            // Chance, a cada tick, de ProcessTick colocar o diamante dourado; 0 desliga
+	goldenRng       *rand.Rand         // Sorteio do diamante dourado, do mesmo seed mas fora da sequência de rng; usado com itemsMu travado para escrita
+	comboWindow     time.Duration      // Janela entre coletas de uma sequência; 0 desliga o multiplicador
+	comboMax        int                // Teto do multiplicador de sequência
+	tieBreak        bool               // Desempate pelo momento em que cada jogador atingiu a pontuação
+	overtime        time.Duration      // Duração máxima da prorrogação; 0 desliga
+	overtimeUntil   time.Time          // Fim da prorrogação em andamento; zero fora dela
+	itemSymbols     map[string]string  // Símbolos configurados por tipo de item
+	itemKinds       []ItemKind         // Tipos de item sorteados nesta sala (sem o power-up, se ele estiver desligado)
+	nextItemID      int                // Sequência usada para gerar IDs únicos de itens
+	respawnInterval time.Duration      // Intervalo entre reaparições de itens; 0 mantém o modo clássico
+	respawnTarget   int                // Quantidade máxima de itens mantida pelo modo contínuo
+	moveInterval    time.Duration      // Intervalo mínimo entre movimentos de um jogador
+	movesPerTick    int                // Movimentos enfileirados aplicados por tick; sempre pelo menos 1
+	reconnectGrace  time.Duration      // Tempo que um jogador desconectado fica reservado aguardando reconexão
+	slowClientLimit int                // Descartes seguidos tolerados antes de desconectar um cliente lento
+	maxPlayers      int                // Limite de jogadores reais; 0 desliga
+	idleTimeout     time.Duration      // Inatividade tolerada antes de KickIdle remover o jogador; 0 desliga
+	chatInterval    time.Duration      // Intervalo mínimo entre mensagens de chat de um jogador
+	viewRadius      int                // Raio do modo de visão limitada; 0 envia o tabuleiro inteiro a todos
+	sendBuffer      int                // Capacidade do sendChan de cada conexão
+	metrics         Metrics            // Nunca nil: noMetrics quando a configuração não define um
+	events          EventSink          // Nunca nil: noEvents quando a configuração não define um
+	recorder        *Recorder          // Gravação da sala; nil quando desligada
+	replaying       bool               // Sala recriada por Replay: remoções por prazo de reconexão vêm da gravação, não de timers
+	clock           func() time.Time   // Relógio da sala: time.Now, ou o relógio da gravação durante um replay
+	frozenAt        time.Time          // Instante em que freezeClock parou o relógio; zero com ele andando
+	createdAt       time.Time          // Criação da sala (com leitura monotônica), base de serverMs nos snapshots
+	seq             atomic.Uint64      // Número do último broadcast da sala; continua crescendo entre partidas
+	ticks           int                // Ticks processados desde a criação da sala, para numerar a gravação; só muda com os dois mutexes travados, então ler com qualquer um deles basta
+	rng             *rand.Rand         // Fonte dos sorteios da sala; não é segura para uso concorrente, então só é usada com itemsMu travado para escrita
+	playersMu       sync.RWMutex       // Protege Players, Spectators, os campos de cada Player e o índice de ocupação (playerCells, trailCells)
+	itemsMu         sync.RWMutex       // Protege Items, itemGrid, golden, nextItemID, rng, freeCells e o andamento da partida (GameOver, WinnerIDs, WinningTeams, startedAt, endedAt, overtimeUntil)
+}
+
+// Ordem dos locks: playersMu sempre antes de itemsMu. Quem precisa dos dois usa lockAll/rLockAll, e quem só
+// precisa de um deles não deve tentar pegar playersMu enquanto segura itemsMu. Os demais campos do GameState
+// não mudam depois de NewGameState e podem ser lidos sem lock.
+
+// lockAll trava os dois mutexes para escrita, na ordem definida
+func (gs *GameState) lockAll() {
+	gs.playersMu.Lock()
+	gs.itemsMu.Lock()
+}
+
+func (gs *GameState) unlockAll() {
+	gs.itemsMu.Unlock()
+	gs.playersMu.Unlock()
+}
+
+// rLockAll trava os dois mutexes para leitura, na ordem definida
+func (gs *GameState) rLockAll() {
+	gs.playersMu.RLock()
+	gs.itemsMu.RLock()
+}
+
+func (gs *GameState) rUnlockAll() {
+	gs.itemsMu.RUnlock()
+	gs.playersMu.RUnlock()
+}
+
+// now retorna o instante atual da sala. Quem chama deve segurar playersMu ou itemsMu.
+func (gs *GameState) now() time.Time {
+	if !gs.frozenAt.IsZero() {
+		return gs.frozenAt
+	}
+	return gs.clock()
+}
+
+// freezeClock para o relógio da sala no instante atual e retorna a função que o solta. Assim todas as regras de
+// um tick (ou de um reset) veem o mesmo instante, que é também o gravado, e o replay chega ao mesmo resultado.
+// Quem chama deve segurar os dois mutexes para escrita até soltar o relógio.
+func (gs *GameState) freezeClock() func() {
+	gs.frozenAt = gs.clock()
+	return func() { gs.frozenAt = time.Time{} }
+}
+
+// NewGameState cria o estado vazio de uma sala com as dimensões e o layout de paredes da configuração
+func NewGameState(roomID string, cfg Config) *GameState {
+	layoutRng := rand.New(rand.NewSource(cfg.ObstacleSeed))
+	obstacles := generateObstacles(cfg.BoardWidth, cfg.BoardHeight, cfg.ObstacleCount, layoutRng)
+	if len(obstacles) < cfg.ObstacleCount {
+		slog.Warn("Nem todas as paredes couberam sem isolar regiões do tabuleiro", "room", roomID, "placed", len(obstacles), "requested", cfg.ObstacleCount)
+	}
+	obstacleSet := make(map[Point]bool, len(obstacles))
+	for _, p := range obstacles {
+		obstacleSet[p] = true
+	}
+	ice := generateIce(cfg.BoardWidth, cfg.BoardHeight, cfg.IceCount, layoutRng, obstacleSet)
+	iceSet := make(map[Point]bool, len(ice))
+	blocked := make(map[Point]bool, len(obstacles)+len(ice)) // Portais ficam fora das paredes e do gelo
+	for _, p := range ice {
+		iceSet[p] = true
+		blocked[p] = true
+	}
+	for _, p := range obstacles {
+		blocked[p] = true
+	}
+	teleports := generateTeleports(cfg.BoardWidth, cfg.BoardHeight, cfg.TeleportPairs, layoutRng, blocked)
+	teleportTo := make(map[Point]Point, 2*len(teleports))
+	for _, t := range teleports {
+		teleportTo[t.A], teleportTo[t.B] = t.B, t.A
+	}
+
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = noMetrics{}
+	}
+
+	events := cfg.Events
+	if events == nil {
+		events = noEvents{}
+	}
+
+	sendBuffer := cfg.SendBuffer
+	if sendBuffer <= 0 {
+		sendBuffer = DefaultSendBuffer
+	}
+
+	seed := cfg.RandomSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	// A chance é por segundo, então a de cada tick depende de TickDelay: 1 - (1-c)^segundos. Assim GAME_TICK_MS não
+	// muda com que frequência o diamante aparece.
+	goldenChance := 0.0
+	if cfg.GoldenChance > 0 && cfg.GoldenLifetime > 0 && cfg.TickDelay > 0 {
+		goldenChance = 1 - math.Pow(1-min(cfg.GoldenChance, 1), cfg.TickDelay.Seconds())
+	}
+
+	kinds := itemKinds
+	if cfg.SpeedBoost <= 0 {
+		kinds = nil
+		for _, kind := range itemKinds {
+			if kind.Name != ItemKindSpeed {
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+
+	gs := &GameState{
+		RoomID:          roomID,
+		Players:         make(map[string]*Player),
+		Items:           make(map[string]*Item),
+		itemGrid:        make(itemGrid),
+		wake:            make(chan struct{}, 1),
+		Spectators:      make(map[string]*Player),
+		Obstacles:       obstacles,
+		obstacleSet:     obstacleSet,
+		Ice:             ice,
+		iceSet:          iceSet,
+		Teleports:       teleports,
+		teleportTo:      teleportTo,
+		BoardWidth:      cfg.BoardWidth,
+		BoardHeight:     cfg.BoardHeight,
+		GameOver:        false,
+		TickMs:          int(cfg.BroadcastInterval() / time.Millisecond),
+		numItems:        cfg.NumItems,
+		itemDensity:     cfg.ItemDensity,
+		duration:        cfg.GameDuration,
+		autoRestart:     cfg.AutoRestart,
+		targetScore:     cfg.TargetScore,
+		startScore:      cfg.StartScore,
+		scoreFloor:      cfg.ScoreFloor,
+		noScoreFloor:    cfg.NoScoreFloor,
+		rounds:          cfg.Rounds,
+		teams:           min(cfg.Teams, MaxTeams),
+		wrap:            cfg.Wrap,
+		trail:           cfg.Trail,
+		speedBoost:      cfg.SpeedBoost,
+		goldenLifetime:  cfg.GoldenLifetime,
+		goldenValue:     cfg.GoldenValue,
+		goldenChance:    goldenChance,
+		comboWindow:     cfg.ComboWindow,
+		comboMax:        cfg.ComboMax,
+		tieBreak:        cfg.TieBreak,
+		overtime:        cfg.Overtime,
+		itemSymbols:     cfg.ItemSymbols,
+		itemKinds:       kinds,
+		respawnInterval: cfg.RespawnInterval,
+		respawnTarget:   cfg.RespawnTarget,
+		moveInterval:    cfg.MoveInterval,
+		movesPerTick:    max(cfg.MovesPerTick, 1),
+		reconnectGrace:  cfg.ReconnectGrace,
+		slowClientLimit: cfg.SlowClientLimit,
+		maxPlayers:      cfg.MaxPlayers,
+		idleTimeout:     cfg.IdleTimeout,
+		chatInterval:    cfg.ChatInterval,
+		viewRadius:      cfg.ViewRadius,
+		sendBuffer:      sendBuffer,
+		metrics:         metrics,
+		events:          events,
+		recorder:        cfg.Recorder,
+		clock:           time.Now,
+		rng:             rand.New(rand.NewSource(seed)),
+		// Derivado do mesmo seed, mas num gerador próprio: o sorteio acontece em todo tick, inclusive nos que não
+		// são gravados, e não pode adiantar a sequência de rng, que o replay precisa percorrer igual
+		goldenRng: rand.New(rand.NewSource(seed + 1)),
+	}
+	gs.createdAt = gs.now()
+	gs.resetFreeCellsLocked() // Ainda não há itens nem jogadores: só as paredes ocupam células
+	if gs.recorder != nil {
+		gs.recorder.start(roomID, seed, cfg, gs.now())
+	}
+	return gs
+}
+
+// InitializeItems coloca os itens no tabuleiro em posições aleatórias e começa uma nova partida, mesmo que a
+// atual ainda esteja em andamento. Retorna a quantidade de itens posicionados.
+func (gs *GameState) InitializeItems() int {
+	gs.lockAll()
+	defer gs.unlockAll()
+	defer gs.freezeClock()()
+
+	gs.record(recordEntry{Type: recordReset})
+	gs.initializeItemsLocked()
+	return len(gs.Items)
+}
+
+// initializeItemsLocked é o corpo de InitializeItems; quem chama deve segurar os dois mutexes para escrita
+func (gs *GameState) initializeItemsLocked() {
+	gs.startRoundLocked() // Antes de GameOver ser apagado: ele diz se a rodada anterior chegou ao fim
+	gs.Items = make(map[string]*Item)
+	gs.itemGrid = make(itemGrid)
+	gs.golden = nil
+	gs.nextItemID = 0
+	for _, player := range gs.Players { // Rastros, eliminações e power-ups valem só para a partida em que aconteceram
+		player.Body = nil
+		player.Out = false
+		player.speedUntil = time.Time{}
+		player.streak = 0
+	}
+	gs.resetFreeCellsLocked() // As células dos itens e rastros da partida anterior voltam a ficar livres
+	count := gs.itemCountLocked()
+	for i := 0; i < count; i++ {
+		if gs.spawnItemLocked() == nil {
+			slog.Warn("Tabuleiro cheio, nem todos os itens foram posicionados", "room", gs.RoomID, "placed", i, "requested", count)
+			break
+		}
+	}
+
+	gs.GameOver = false
+	gs.WinnerIDs = nil
+	gs.WinningTeams = nil
+	gs.startedAt = gs.now() // Reinicia o cronômetro do modo com tempo limite
+	gs.overtimeUntil = time.Time{}
+
+	for _, player := range gs.Players { // Inclui desconectados aguardando reconexão, para não voltarem com pontos da partida anterior
+		player.Score = gs.startScore
+		player.scoredAt = gs.startedAt
+		player.lastActivity = gs.startedAt // A espera entre partidas não conta como inatividade
+	}
+
+	slog.Info("Partida iniciada, pontuações zeradas", "room", gs.RoomID, "action", "game_start", "items", len(gs.Items), "round", gs.round)
+}
+
+// itemCountLocked é a quantidade de itens de uma nova partida: Config.NumItems ou, com Config.ItemDensity, a
+// fração das células sem parede, com no mínimo 1 e no máximo as células livres no momento. Quem chama deve
+// segurar itemsMu, com as células livres já recalculadas.
+func (gs *GameState) itemCountLocked() int {
+	if gs.itemDensity <= 0 {
+		return gs.numItems
+	}
+	open := gs.BoardWidth*gs.BoardHeight - len(gs.Obstacles)
+	return max(1, min(int(math.Round(gs.itemDensity*float64(open))), len(gs.freeCells)))
+}
+
+// ResetIfOver começa uma nova partida se a atual já terminou, retornando se o reset aconteceu.
+// A verificação e o reset acontecem sob o mesmo lock, então dois pedidos simultâneos resetam uma vez só.
+func (gs *GameState) ResetIfOver() bool {
+	gs.lockAll()
+	defer gs.unlockAll()
+	defer gs.freezeClock()()
+
+	if !gs.GameOver {
+		return false
+	}
+	gs.record(recordEntry{Type: recordReset})
+	gs.initializeItemsLocked()
+	return true
+}
+
+// spawnItemLocked coloca um novo item numa posição livre (sem item nem jogador), retornando nil se o tabuleiro
+// estiver cheio. Quem chama deve segurar itemsMu para escrita e playersMu.
+func (gs *GameState) spawnItemLocked() *Item {
+	itemPos, ok := gs.randomFreeCellLocked()
+	if !ok {
+		return nil
+	}
+	return gs.placeItemLocked(itemPos, randomItemKind(gs.rng, gs.itemKinds))
+}
+
+// placeItemLocked cria um item do tipo indicado numa célula livre. Quem chama deve segurar itemsMu para escrita.
+func (gs *GameState) placeItemLocked(pos Point, kind ItemKind) *Item {
+	itemID := "item_" + strconv.Itoa(gs.nextItemID)
+	gs.nextItemID++
+	item := &Item{ID: itemID, Pos: pos, Kind: kind.Name, Value: kind.Value, Symbol: gs.itemSymbols[kind.Name]}
+	gs.Items[fmt.Sprintf("%d,%d", pos.X, pos.Y)] = item
+	gs.itemGrid.add(item)
+	gs.refreshCellLocked(pos)
+	return item
+}
+
+// SpawnGolden coloca o diamante dourado numa célula livre, por Config.GoldenLifetime. Não faz nada com ele
+// desligado, com a partida encerrada ou se já houver um no tabuleiro. Retorna se o diamante apareceu.
+func (gs *GameState) SpawnGolden() bool {
+	gs.playersMu.RLock() // Só para consultar as posições dos jogadores
+	defer gs.playersMu.RUnlock()
+	gs.itemsMu.Lock()
+	defer gs.itemsMu.Unlock()
+
+	return gs.spawnGoldenLocked()
+}
+
+// spawnGoldenLocked é o SpawnGolden de quem já segura itemsMu para escrita e playersMu
+func (gs *GameState) spawnGoldenLocked() bool {
+	if gs.goldenLifetime == 0 || gs.GameOver || gs.golden != nil {
+		return false
+	}
+	gs.record(recordEntry{Type: recordGolden})
+	pos, ok := gs.randomFreeCellLocked()
+	if !ok {
+		return false
+	}
+	item := gs.placeItemLocked(pos, ItemKind{Name: ItemKindGolden, Value: gs.goldenValue})
+	item.expiresAt = gs.now().Add(gs.goldenLifetime)
+	item.ExpiresMs = item.expiresAt.Sub(gs.createdAt).Milliseconds()
+	gs.golden = item
+	slog.Info("Diamante dourado apareceu", "room", gs.RoomID, "item", item.ID, "x", pos.X, "y", pos.Y, "value", item.Value, "lifetime", gs.goldenLifetime)
+	return true
+}
+
+// expireGoldenLocked remove o diamante dourado quando o tempo dele acaba, retornando se removeu.
+// Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) expireGoldenLocked() bool {
+	item := gs.golden
+	if item == nil || gs.now().Before(item.expiresAt) {
+		return false
+	}
+	delete(gs.Items, fmt.Sprintf("%d,%d", item.Pos.X, item.Pos.Y))
+	gs.itemGrid.remove(item)
+	gs.golden = nil
+	gs.refreshCellLocked(item.Pos)
+	slog.Info("Diamante dourado sumiu sem ser coletado", "room", gs.RoomID, "item", item.ID)
+	return true
+}
+
+// RespawnItem repõe um único item no modo contínuo, se o tabuleiro estiver abaixo da quantidade alvo
+func (gs *GameState) RespawnItem() {
+	gs.playersMu.RLock() // Só para consultar as posições dos jogadores
+	defer gs.playersMu.RUnlock()
+	gs.itemsMu.Lock()
+	defer gs.itemsMu.Unlock()
+
+	if gs.GameOver || len(gs.Items) >= gs.respawnTarget {
+		return
+	}
+	gs.record(recordEntry{Type: recordRespawn})
+	item := gs.spawnItemLocked()
+	if item == nil {
+		return // Tabuleiro cheio: tenta de novo no próximo intervalo
+	}
+	slog.Debug("Item reapareceu", "room", gs.RoomID, "item", item.ID, "x", item.Pos.X, "y", item.Pos.Y, "items", len(gs.Items))
+}
+
+// playerAt retorna o jogador ativo que ocupa a posição, ou nil. É a definição de "célula ocupada"
+// usada tanto no nascimento de jogadores e itens quanto no bloqueio de movimentos. Quem chama deve segurar playersMu.
+func (gs *GameState) playerAt(pos Point) *Player {
+	return gs.playerCells[pos]
+}
+
+// QueueMove registra a direção pedida pelo jogador. O movimento só é aplicado no próximo tick,
+// por ProcessTick, para que o resultado não dependa da ordem em que as goroutines 'reader' rodam.
+// Retorna um dos erros Err* quando o movimento é descartado.
+func (gs *GameState) QueueMove(playerID string, direction string) error {
+	gs.playersMu.Lock() // Grava a intenção no jogador
+	defer gs.playersMu.Unlock()
+	gs.itemsMu.RLock() // Só para consultar GameOver
+	gameOver := gs.GameOver
+	gs.itemsMu.RUnlock()
+
+	if gameOver {
+		return ErrGameOver
+	}
+
+	player, ok := gs.Players[playerID]
+	if !ok || !player.IsActive {
+		return ErrPlayerInactive
+	}
+	if player.Out {
+		return ErrEliminated
+	}
+
+	if _, ok := gs.neighbor(Point{}, direction); !ok { // Só confere se a direção existe; a célula é calculada no tick
+		return ErrInvalidDirection
+	}
+
+	// Limite de taxa por jogador: o estado fica no próprio Player, então some junto com ele em RemovePlayer
+	now := gs.now()
+	if gs.moveInterval > 0 && now.Sub(player.lastMove) < gs.moveInterval {
+		return ErrMoveRateExceeded // O excesso de movimentos é descartado
+	}
+
+	if len(player.intents) < gs.movesPerTick {
+		player.intents = append(player.intents, direction)
+	} else { // Fila cheia (com um movimento por tick, sempre): vale a intenção mais recente no lugar da última
+		player.intents[len(player.intents)-1] = direction
+	}
+	player.lastMove = now
+	return nil
+}
+
+// ProcessTick aplica as intenções de movimento pendentes de todos os jogadores, em ordem fixa (por ID). Com
+// Config.MovesPerTick acima de 1, as filas são aplicadas em rodadas: o primeiro movimento de cada jogador, depois
+// o segundo de cada um, e assim por diante, para que quem enfileira mais não passe na frente dos outros.
+//
+// Regra de desempate: se dois ou mais jogadores tentam entrar na mesma célula no mesmo tick, o de menor ID
+// (o primeiro na ordem de processamento) entra e coleta o item, se houver; para os demais a célula já está
+// ocupada e o movimento é bloqueado. Da mesma forma, um jogador só libera sua célula quando seu próprio
+// movimento é processado, então quem vem antes na ordem não pode entrar nela naquele tick. Um movimento que
+// desliza pelo gelo é aplicado inteiro antes do próximo jogador, com as mesmas regras em cada célula.
+func (gs *GameState) ProcessTick() {
+	gs.lockAll()
+	defer gs.unlockAll()
+	defer gs.freezeClock()()
+
+	gs.ticks++
+	wasOver, wasOvertime := gs.GameOver, gs.inOvertimeLocked()
+	ids := make([]string, 0, len(gs.Players))
+	for id, player := range gs.Players {
+		if len(player.intents) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	// O sorteio fica de fora no replay: o diamante que apareceu está gravado e é recolocado por SpawnGolden. Vem
+	// antes de expireGoldenLocked, que no replay só roda depois da entrada do diamante.
+	if gs.goldenChance > 0 && !gs.replaying && gs.golden == nil && !gs.GameOver && gs.goldenRng.Float64() < gs.goldenChance {
+		gs.spawnGoldenLocked()
+	}
+	expired := gs.expireGoldenLocked() // Antes dos movimentos: no tick em que o prazo acaba, já não dá para pegá-lo
+
+	var moves []recordedMove
+	for round := 0; round < gs.movesPerTick; round++ {
+		for _, id := range ids {
+			player := gs.Players[id]
+			if round >= len(player.intents) {
+				continue
+			}
+			direction := player.intents[round]
+			if gs.recorder != nil {
+				moves = append(moves, recordedMove{PlayerID: id, Direction: direction})
+			}
+			if !gs.GameOver && player.IsActive { // Se o último item sair neste tick, os movimentos seguintes são descartados
+				gs.movePlayerLocked(player, direction)
+				if !gs.GameOver && !player.Out && player.boosted(gs.now()) {
+					gs.movePlayerLocked(player, direction) // Power-up de velocidade: um segundo passo, com as mesmas regras
+				}
+			}
+		}
+	}
+	for _, id := range ids {
+		gs.Players[id].intents = gs.Players[id].intents[:0]
+	}
+
+	// Uma partida que começou só com itens sem pontos (bombas e power-ups, que ninguém precisa pegar) não teria
+	// coleta que a encerrasse
+	if gs.respawnInterval == 0 && !gs.GameOver && gs.scoringItemsLocked() == 0 && !gs.inOvertimeLocked() {
+		gs.endGameLocked()
+	}
+
+	// O cronômetro é verificado depois dos movimentos: se o tempo acabar no mesmo tick em que o último item
+	// é coletado, a partida já terminou pela coleta e o vencedor é o mesmo calculado ali
+	if gs.duration > 0 && !gs.GameOver && gs.remainingLocked() == 0 {
+		gs.timeUpLocked()
+	}
+
+	// Um reset manual antes do prazo já começa a nova partida, então não há reinício pendente a cancelar
+	if gs.GameOver && gs.autoRestart > 0 && gs.restartInLocked() == 0 {
+		slog.Info("Reiniciando a partida automaticamente", "room", gs.RoomID)
+		gs.initializeItemsLocked()
+	}
+
+	// Um tick sem movimentos que não encerrou nem reiniciou a partida, não começou a prorrogação nem tirou o
+	// diamante dourado não muda nada, então não precisa ser gravado
+	if len(moves) > 0 || gs.GameOver != wasOver || gs.inOvertimeLocked() != wasOvertime || expired {
+		gs.record(recordEntry{Type: recordTick, Moves: moves})
+	}
+}
+
+// handlePlayerMove move o jogador uma célula na direção indicada e trata a coleta de itens.
+// Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) handlePlayerMove(player *Player, direction string) {
+	player.lastActivity = gs.now() // Conta mesmo se o movimento for bloqueado: o jogador está presente
+	newPos, ok := gs.neighbor(player.Pos, direction)
+	if !ok || newPos == player.Pos {
+		return // Direção inválida ou borda do tabuleiro
+	}
+	if gs.obstacleSet[newPos] {
+		return // Parede: o jogador fica onde está
+	}
+	if gs.playerAt(newPos) != nil {
+		return // Célula ocupada por outro jogador: o jogador fica onde está
+	}
+	if gs.trailAt(newPos) != nil {
+		gs.eliminateLocked(player) // Bateu num rastro (inclusive o próprio)
+		return
+	}
+
+	oldPos := player.Pos
+	gs.moveHeadLocked(player, newPos) // Atualiza a posição do jogador
+	itemKey := fmt.Sprintf("%d,%d", newPos.X, newPos.Y)
+	if gs.trail {
+		// O rastro anda junto: a célula deixada vira o primeiro segmento e o último sai, a não ser que o
+		// jogador esteja coletando um item, caso em que o rastro cresce um segmento
+		body := append([]Point{oldPos}, player.Body...) // Sempre um slice novo, pois snapshots podem estar lendo o antigo
+		gs.trailCells[oldPos] = player
+		if _, collecting := gs.Items[itemKey]; !collecting {
+			tail := body[len(body)-1]
+			body = body[:len(body)-1]
+			player.Body = body
+			delete(gs.trailCells, tail)
+			gs.refreshCellLocked(tail)
+		} else {
+			player.Body = body
+		}
+		gs.refreshCellLocked(oldPos)
+	}
+
+	gs.collectItemLocked(player, newPos)
+	if !gs.GameOver {
+		gs.teleportLocked(player)
+	}
+}
+
+// collectItemLocked dá ao jogador o item da célula pos, se houver, e trata o que a coleta desencadeia: power-up,
+// meta, morte súbita e fim da partida. Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) collectItemLocked(player *Player, pos Point) {
+	itemKey := fmt.Sprintf("%d,%d", pos.X, pos.Y)
+	item, exists := gs.Items[itemKey]
+	if !exists {
+		return
+	}
+	points, combo := item.Value, 1
+	switch {
+	case item.Value > 0: // Só itens com pontos entram na sequência e são multiplicados
+		combo = gs.collectComboLocked(player, gs.now())
+		points *= combo
+	case item.Value < 0: // Uma bomba quebra a sequência
+		player.streak = 0
+	}
+	if score := gs.applyFloor(player.Score + points); score != player.Score { // Bombas tiram pontos, até a pontuação mínima
+		player.Score = score
+		player.scoredAt = gs.now() // Parado durante o tick: quem pontua no mesmo tick empata
+	}
+	delete(gs.Items, itemKey) // Remove o item do jogo
+	gs.itemGrid.remove(item)
+	if item == gs.golden {
+		gs.golden = nil
+	}
+	gs.metrics.ItemCollected(gs.RoomID)
+	slog.Info("Item coletado", "room", gs.RoomID, "player_id", player.ID, "action", "collect", "item", item.ID, "kind", item.Kind, "value", item.Value, "combo", combo, "score", player.Score, "items_left", len(gs.Items))
+	gs.publish(GameEvent{Type: EventItemCollected, PlayerID: player.ID, ItemKind: item.Kind, Value: points, Score: player.Score})
+	if item.Kind == ItemKindSpeed {
+		player.speedUntil = gs.now().Add(gs.speedBoost)
+		slog.Debug("Velocidade dobrada", "room", gs.RoomID, "player_id", player.ID, "duration", gs.speedBoost)
+	}
+
+	// Quem atinge a meta primeiro vence sozinho, mesmo com itens no tabuleiro (inclusive no modo contínuo).
+	// Os demais jogadores ainda estão abaixo da meta, senão a partida já teria terminado.
+	// No modo de equipes, a meta vale para a soma da equipe.
+	if gs.teams > 0 && gs.targetScore > 0 {
+		if teamScore := gs.teamScoresLocked()[player.Team]; teamScore >= gs.targetScore {
+			slog.Info("Equipe atingiu a meta", "room", gs.RoomID, "team", player.Team, "target_score", gs.targetScore)
+			gs.finishTeamsLocked([]int{player.Team}, teamScore)
+			return
+		}
+	} else if gs.targetScore > 0 && player.Score >= gs.targetScore {
+		slog.Info("Jogador atingiu a meta", "room", gs.RoomID, "player_id", player.ID, "target_score", gs.targetScore)
+		gs.finishGameLocked([]string{player.ID}, player.Score)
+		return
+	}
+
+	if gs.suddenDeathLocked() { // Na prorrogação, a primeira coleta que deixa um único líder encerra a partida
+		return
+	}
+	if gs.scoringItemsLocked() == 0 && gs.respawnInterval == 0 { // Verifica se o jogo acabou (no modo contínuo, os itens voltam)
+		if gs.inOvertimeLocked() {
+			gs.restockLocked() // Ainda empatado: a prorrogação continua com itens novos
+		} else {
+			gs.endGameLocked()
+		}
+	}
+}
+
+// neighbor retorna a célula vizinha na direção indicada (as quatro básicas ou as diagonais, como "up_left").
+// Nas bordas, cada eixo para no limite do tabuleiro (um passo que não sai do lugar retorna a própria posição)
+// ou, com wrap, continua pelo lado oposto. Retorna false para direção inválida.
+// Não olha paredes nem jogadores, então serve tanto para o movimento quanto para a busca de caminho dos bots.
+func (gs *GameState) neighbor(pos Point, direction string) (Point, bool) {
+	switch direction {
+	case "up":
+		pos.Y--
+	case "down":
+		pos.Y++
+	case "left":
+		pos.X--
+	case "right":
+		pos.X++
+	case "up_left":
+		pos.X, pos.Y = pos.X-1, pos.Y-1
+	case "up_right":
+		pos.X, pos.Y = pos.X+1, pos.Y-1
+	case "down_left":
+		pos.X, pos.Y = pos.X-1, pos.Y+1
+	case "down_right":
+		pos.X, pos.Y = pos.X+1, pos.Y+1
+	default:
+		return pos, false
+	}
+	if gs.wrap {
+		pos.X = (pos.X + gs.BoardWidth) % gs.BoardWidth
+		pos.Y = (pos.Y + gs.BoardHeight) % gs.BoardHeight
+	} else {
+		pos.X = min(max(pos.X, 0), gs.BoardWidth-1)
+		pos.Y = min(max(pos.Y, 0), gs.BoardHeight-1)
+	}
+	return pos, true
+}
+
+// scoringItemsLocked conta os itens que valem pontos. A partida clássica termina quando eles acabam, mesmo que
+// sobrem bombas, power-ups ou o diamante dourado no tabuleiro. Quem chama deve segurar itemsMu.
+func (gs *GameState) scoringItemsLocked() int {
+	count := 0
+	for _, item := range gs.Items {
+		if item.Value > 0 && item != gs.golden {
+			count++
+		}
+	}
+	return count
+}
+
+// endGameLocked encerra a partida e declara vencedor(es) o(s) jogador(es) ativo(s) com maior pontuação
+// (ou, no modo de equipes, os da equipe com maior soma).
+// Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) endGameLocked() {
+	if gs.teams > 0 {
+		gs.endTeamsLocked()
+		return
+	}
+	winners, winnerScore := gs.leadersLocked()
+	gs.finishGameLocked(winners, winnerScore)
+}
+
+// leadersLocked retorna o(s) jogador(es) ativo(s) com a maior pontuação, já desempatados por TIE_BREAK, em
+// ordem de ID, e a pontuação deles. Quem chama deve segurar playersMu.
+func (gs *GameState) leadersLocked() ([]string, int) {
+	winnerScore := 0
+	var winners []string
+	for _, p := range gs.Players {
+		if p.IsActive {
+			if winners == nil || p.Score > winnerScore { // Sem pontuação mínima, o melhor placar pode ser negativo
+				winnerScore = p.Score
+				winners = []string{p.ID}
+			} else if p.Score == winnerScore {
+				winners = append(winners, p.ID)
+			}
+		}
+	}
+	if gs.tieBreak && len(winners) > 1 {
+		winners = gs.firstToScoreLocked(winners)
+	}
+	sort.Strings(winners) // Ordem estável, independente da iteração do mapa
+	return winners, winnerScore
+}
+
+// firstToScoreLocked desempata os vencedores pelo momento em que atingiram a pontuação: fica quem chegou a ela
+// primeiro, ou todos os que chegaram no mesmo instante (o mesmo tick). Quem chama deve segurar playersMu.
+func (gs *GameState) firstToScoreLocked(ids []string) []string {
+	var first []string
+	var at time.Time
+	for _, id := range ids {
+		p := gs.Players[id]
+		if first == nil || p.scoredAt.Before(at) {
+			first, at = []string{id}, p.scoredAt
+		} else if p.scoredAt.Equal(at) {
+			first = append(first, id)
+		}
+	}
+	return first
+}
+
+// finishGameLocked marca a partida como encerrada com os vencedores informados (mais de um em caso de empate,
+// nenhum se não houver jogadores ativos). Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) finishGameLocked(winners []string, winnerScore int) {
+	gs.GameOver = true
+	gs.overtimeUntil = time.Time{}
+	gs.endedAt = gs.now()
+	gs.metrics.GameCompleted(gs.RoomID)
+	gs.scoreRoundLocked(winners)
+	gs.publish(GameEvent{Type: EventGameOver, WinnerIDs: winners, Scores: gs.scoresLocked(), Duration: gs.endedAt.Sub(gs.startedAt), Round: gs.round, ChampionIDs: gs.ChampionIDs})
+	if len(winners) > 0 {
+		gs.WinnerIDs = winners
+		slog.Info("FIM DE JOGO", "room", gs.RoomID, "action", "game_over", "winners", winners, "score", winnerScore)
+	} else {
+		slog.Info("FIM DE JOGO sem jogadores ativos para declarar vencedor", "room", gs.RoomID, "action", "game_over")
+	}
+	if gs.recorder != nil {
+		gs.recorder.Flush() // Uma queda do servidor não perde as partidas já terminadas
+	}
+}
+
+// restartInLocked retorna quanto falta para o reinício automático de uma partida encerrada.
+// Quem chama deve segurar itemsMu.
+func (gs *GameState) restartInLocked() time.Duration {
+	remaining := gs.autoRestart - gs.now().Sub(gs.endedAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// remainingLocked retorna o tempo restante da partida no modo com tempo limite (na prorrogação, o dela).
+// Quem chama deve segurar itemsMu.
+func (gs *GameState) remainingLocked() time.Duration {
+	if gs.inOvertimeLocked() {
+		return gs.overtimeLeftLocked()
+	}
+	remaining := gs.duration - gs.now().Sub(gs.startedAt)
+	if remaining < 0 || gs.GameOver {
+		return 0
+	}
+	return remaining
+}
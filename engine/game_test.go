@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestGame cria uma sala sem paredes nem itens, com seed fixa, para o teste montar o tabuleiro à mão. Sem
+// Config.BoardWidth, o tabuleiro é de 5x5.
+func newTestGame(t testing.TB, cfg Config) *GameState {
+	t.Helper()
+	if cfg.BoardWidth == 0 {
+		cfg.BoardWidth, cfg.BoardHeight = 5, 5
+	}
+	if cfg.RandomSeed == 0 {
+		cfg.RandomSeed = 1
+	}
+	return NewGameState("teste", cfg)
+}
+
+// joinAt coloca um jogador novo na sala e o leva para pos, que precisa estar livre
+func joinAt(t testing.TB, gs *GameState, id string, pos Point) *Player {
+	t.Helper()
+	p, _, err := gs.AddPlayer(id, id, 0)
+	if err != nil {
+		t.Fatalf("AddPlayer(%q): %v", id, err)
+	}
+	gs.lockAll()
+	defer gs.unlockAll()
+	gs.moveHeadLocked(p, pos)
+	return p
+}
+
+// putItem coloca um item de value pontos em pos, que precisa estar livre
+func putItem(gs *GameState, pos Point, value int) *Item {
+	gs.lockAll()
+	defer gs.unlockAll()
+	return gs.placeItemLocked(pos, ItemKind{Name: "common", Value: value})
+}
+
+// itemAt retorna o item da célula pos, ou nil
+func itemAt(gs *GameState, pos Point) *Item {
+	gs.itemsMu.RLock()
+	defer gs.itemsMu.RUnlock()
+	return gs.Items[fmt.Sprintf("%d,%d", pos.X, pos.Y)]
+}
+
+// checkBoard confere as invariantes do tabuleiro, recalculadas do zero a partir dos jogadores e dos itens: cada
+// jogador está no mapa sob o próprio ID, nenhuma célula tem dois jogadores ativos, o índice de ocupação aponta
+// para quem está em cada célula e o conjunto de células livres é exatamente o das células sem parede, item,
+// jogador ativo nem rastro
+func checkBoard(t *testing.T, gs *GameState) {
+	t.Helper()
+	gs.rLockAll()
+	defer gs.rUnlockAll()
+
+	taken := make(map[Point]string)
+	for id, p := range gs.Players {
+		if p.ID != id {
+			t.Errorf("jogador %q guardado sob o ID %q", p.ID, id)
+		}
+		if !p.IsActive {
+			continue
+		}
+		if other, ok := taken[p.Pos]; ok {
+			t.Errorf("jogadores %q e %q na mesma célula %v", other, id, p.Pos)
+		}
+		taken[p.Pos] = id
+		if gs.playerCells[p.Pos] != p {
+			t.Errorf("playerCells não aponta para %q em %v", id, p.Pos)
+		}
+		for _, segment := range p.Body {
+			taken[segment] = id
+			if gs.trailCells[segment] != p {
+				t.Errorf("trailCells não aponta para %q em %v", id, segment)
+			}
+		}
+	}
+	if len(gs.playerCells)+len(gs.trailCells) != len(taken) {
+		t.Errorf("índice de ocupação com %d células, os jogadores ocupam %d", len(gs.playerCells)+len(gs.trailCells), len(taken))
+	}
+
+	free := 0
+	for y := 0; y < gs.BoardHeight; y++ {
+		for x := 0; x < gs.BoardWidth; x++ {
+			pos := Point{x, y}
+			_, hasItem := gs.Items[fmt.Sprintf("%d,%d", x, y)]
+			_, hasPlayer := taken[pos]
+			idx, isFree := gs.freeIndex[pos]
+			if want := !hasItem && !hasPlayer && !gs.obstacleSet[pos]; want != isFree {
+				t.Errorf("célula %v: livre = %v no conjunto, deveria ser %v", pos, isFree, want)
+			} else if isFree {
+				free++
+				if idx >= len(gs.freeCells) || gs.freeCells[idx] != pos {
+					t.Errorf("célula %v: índice %d não aponta para ela em freeCells", pos, idx)
+				}
+			}
+		}
+	}
+	if free != len(gs.freeCells) {
+		t.Errorf("freeCells tem %d células, o tabuleiro tem %d livres", len(gs.freeCells), free)
+	}
+}
+
+func TestProcessTickSameItemTieBreak(t *testing.T) {
+	// Os dois jogadores querem entrar em (2,2) no mesmo tick; a ordem em que os movimentos chegam não importa
+	for _, order := range [][]string{{"a", "b"}, {"b", "a"}} {
+		t.Run(order[0]+" primeiro", func(t *testing.T) {
+			gs := newTestGame(t, Config{})
+			a := joinAt(t, gs, "a", Point{1, 2})
+			b := joinAt(t, gs, "b", Point{3, 2})
+			putItem(gs, Point{2, 2}, 1)
+			putItem(gs, Point{0, 0}, 1) // Para a partida não acabar com a coleta
+
+			directions := map[string]string{"a": "right", "b": "left"}
+			for _, id := range order {
+				if err := gs.QueueMove(id, directions[id]); err != nil {
+					t.Fatalf("QueueMove(%q): %v", id, err)
+				}
+			}
+			gs.ProcessTick()
+
+			if a.Pos != (Point{2, 2}) || a.Score != 1 {
+				t.Errorf("a (menor ID) deveria entrar e coletar: pos %v, score %d", a.Pos, a.Score)
+			}
+			if b.Pos != (Point{3, 2}) || b.Score != 0 {
+				t.Errorf("b deveria ficar bloqueado sem pontuar: pos %v, score %d", b.Pos, b.Score)
+			}
+			if itemAt(gs, Point{2, 2}) != nil || len(gs.Items) != 1 {
+				t.Errorf("o item disputado deveria sair uma única vez, restam %d itens", len(gs.Items))
+			}
+		})
+	}
+}
+
+func TestWrapAllEdges(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      Point
+		direction string
+		want      Point
+	}{
+		{"esquerda", Point{0, 2}, "left", Point{4, 2}},
+		{"direita", Point{4, 2}, "right", Point{0, 2}},
+		{"topo", Point{2, 0}, "up", Point{2, 4}},
+		{"base", Point{2, 4}, "down", Point{2, 0}},
+		{"canto", Point{0, 0}, "up_left", Point{4, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGame(t, Config{Wrap: true})
+			p := joinAt(t, gs, "a", tt.from)
+			putItem(gs, Point{2, 2}, 1)
+
+			if err := gs.QueueMove("a", tt.direction); err != nil {
+				t.Fatalf("QueueMove: %v", err)
+			}
+			gs.ProcessTick()
+			if p.Pos != tt.want {
+				t.Errorf("de %v para %s: foi para %v, deveria ir para %v", tt.from, tt.direction, p.Pos, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoveDirectionsAndEdges(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      Point
+		direction string
+		want      Point
+	}{
+		{"cima", Point{2, 2}, "up", Point{2, 1}},
+		{"baixo", Point{2, 2}, "down", Point{2, 3}},
+		{"esquerda", Point{2, 2}, "left", Point{1, 2}},
+		{"direita", Point{2, 2}, "right", Point{3, 2}},
+		{"cima e esquerda", Point{2, 2}, "up_left", Point{1, 1}},
+		{"cima e direita", Point{2, 2}, "up_right", Point{3, 1}},
+		{"baixo e esquerda", Point{2, 2}, "down_left", Point{1, 3}},
+		{"baixo e direita", Point{2, 2}, "down_right", Point{3, 3}},
+		// Sem WRAP, a borda segura o jogador; na diagonal, só o eixo que sairia fica parado
+		{"borda esquerda", Point{0, 2}, "left", Point{0, 2}},
+		{"borda direita", Point{4, 2}, "right", Point{4, 2}},
+		{"borda de cima", Point{2, 0}, "up", Point{2, 0}},
+		{"borda de baixo", Point{2, 4}, "down", Point{2, 4}},
+		{"diagonal na borda", Point{0, 2}, "down_left", Point{0, 3}},
+		{"canto", Point{4, 4}, "down_right", Point{4, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGame(t, Config{})
+			p := joinAt(t, gs, "a", tt.from)
+			putItem(gs, Point{0, 0}, 1) // Longe de todos os destinos, para a partida não acabar no tick
+
+			if err := gs.QueueMove("a", tt.direction); err != nil {
+				t.Fatalf("QueueMove: %v", err)
+			}
+			gs.ProcessTick()
+			if p.Pos != tt.want {
+				t.Errorf("de %v para %s: foi para %v, deveria ir para %v", tt.from, tt.direction, p.Pos, tt.want)
+			}
+			checkBoard(t, gs)
+		})
+	}
+}
+
+func TestMoveCollectsAndEndsGame(t *testing.T) {
+	gs := newTestGame(t, Config{})
+	a := joinAt(t, gs, "a", Point{0, 2})
+	b := joinAt(t, gs, "b", Point{4, 4})
+	putItem(gs, Point{1, 2}, 1)
+	putItem(gs, Point{2, 2}, 3)
+
+	steps := []struct {
+		wantScore, wantItems int
+		wantOver             bool
+	}{
+		{1, 1, false},
+		{4, 0, true}, // O último item encerra a partida
+	}
+	for i, step := range steps {
+		if err := gs.QueueMove("a", "right"); err != nil {
+			t.Fatalf("passo %d: QueueMove: %v", i+1, err)
+		}
+		gs.ProcessTick()
+		if a.Score != step.wantScore || len(gs.Items) != step.wantItems || gs.GameOver != step.wantOver {
+			t.Errorf("passo %d: pontuação %d, %d itens, fim %v; deveria ser %d, %d, %v", i+1, a.Score, len(gs.Items), gs.GameOver, step.wantScore, step.wantItems, step.wantOver)
+		}
+		if itemAt(gs, a.Pos) != nil {
+			t.Errorf("passo %d: o item coletado continua em %v", i+1, a.Pos)
+		}
+	}
+	if len(gs.WinnerIDs) != 1 || gs.WinnerIDs[0] != "a" || b.Score != 0 {
+		t.Errorf("vencedores %v, deveria ser só a", gs.WinnerIDs)
+	}
+
+	// Com a partida encerrada, nenhum movimento é aceito até o reset
+	for _, id := range []string{"a", "b"} {
+		if err := gs.QueueMove(id, "up"); !errors.Is(err, ErrGameOver) {
+			t.Errorf("QueueMove(%q) depois do fim: %v, deveria ser ErrGameOver", id, err)
+		}
+	}
+	from := a.Pos
+	gs.ProcessTick()
+	if a.Pos != from {
+		t.Errorf("o jogador andou depois do fim: de %v para %v", from, a.Pos)
+	}
+}
+
+// goldenSpawns roda ticks de tickDelay, com o relógio da sala andando junto, e retorna em quais ticks o diamante
+// dourado apareceu. Ele some no tick seguinte, então cada aparição é contada uma vez.
+func goldenSpawns(t *testing.T, seed int64, tickDelay time.Duration, ticks int) []int {
+	t.Helper()
+	gs := newTestGame(t, Config{BoardWidth: 10, BoardHeight: 10, RandomSeed: seed, TickDelay: tickDelay, GoldenChance: 0.05, GoldenLifetime: time.Millisecond, GoldenValue: 20})
+	now := time.Now()
+	gs.clock = func() time.Time { return now }
+	putItem(gs, Point{0, 0}, 1) // Para a partida não acabar
+	var spawns []int
+	for tick := 0; tick < ticks; tick++ {
+		now = now.Add(tickDelay)
+		gs.ProcessTick()
+		if gs.golden != nil {
+			spawns = append(spawns, tick)
+		}
+	}
+	return spawns
+}
+
+func TestGoldenChancePerSecond(t *testing.T) {
+	// 2000 s de jogo com 5% por segundo: cerca de 100 diamantes, qualquer que seja o tick
+	const seconds = 2000
+	for _, tickDelay := range []time.Duration{50 * time.Millisecond, 150 * time.Millisecond, 500 * time.Millisecond} {
+		spawns := goldenSpawns(t, 7, tickDelay, int(seconds*time.Second/tickDelay))
+		if len(spawns) < 70 || len(spawns) > 130 {
+			t.Errorf("tick de %v: %d diamantes em %d s, deveriam ser cerca de 100", tickDelay, len(spawns), seconds)
+		}
+	}
+
+	// O sorteio vem do seed da sala: o mesmo seed repete os mesmos ticks
+	a, b := goldenSpawns(t, 7, 150*time.Millisecond, 2000), goldenSpawns(t, 7, 150*time.Millisecond, 2000)
+	if len(a) == 0 || fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Errorf("mesmo seed, aparições diferentes: %v e %v", a, b)
+	}
+}
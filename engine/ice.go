@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"math/rand"
+)
+
+// generateIce sorteia 'count' células de gelo fora das paredes, com o mesmo gerador do layout de paredes (assim o
+// mesmo seed gera também o mesmo gelo). O gelo não bloqueia nada, então não precisa verificar a conectividade.
+func generateIce(width, height, count int, rng *rand.Rand, obstacles map[Point]bool) []Point {
+	ice := make([]Point, 0, count)
+	for _, idx := range rng.Perm(width * height) {
+		if len(ice) == count {
+			break
+		}
+		p := Point{X: idx % width, Y: idx / width}
+		if obstacles[p] {
+			continue
+		}
+		ice = append(ice, p)
+	}
+	return ice
+}
+
+// movePlayerLocked aplica um movimento do jogador: um passo e, enquanto ele estiver no gelo, mais um passo na mesma
+// direção, até parar numa célula sem gelo ou ser bloqueado (parede, borda ou outro jogador). Cada passo passa por
+// handlePlayerMove, então os itens pelo caminho são coletados um a um e nenhum fica para trás.
+// Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) movePlayerLocked(player *Player, direction string) {
+	// Com wrap, uma linha inteira de gelo faria o jogador deslizar para sempre: um deslize nunca passa por mais
+	// células de gelo do que existem
+	for steps := 0; steps <= len(gs.Ice); steps++ {
+		from := player.Pos
+		gs.handlePlayerMove(player, direction)
+		if player.Pos == from || !gs.iceSet[player.Pos] || gs.GameOver || player.Out {
+			return
+		}
+	}
+}
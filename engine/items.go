@@ -0,0 +1,56 @@
+package engine
+
+import "math/rand"
+
+// ItemKind descreve um tipo de item: quanto vale e com que peso ele é sorteado
+type ItemKind struct {
+	Name   string
+	Value  int
+	Weight int
+}
+
+// ItemKindSpeed é o power-up de velocidade: não vale pontos, mas deixa o jogador andar duas células por tick
+// durante Config.SpeedBoost
+const ItemKindSpeed = "speed"
+
+// ItemKindGolden é o diamante dourado: fica fora do sorteio de itemKinds, aparece de vez em quando por
+// SpawnGolden e some se ninguém o coletar dentro de Config.GoldenLifetime
+const ItemKindGolden = "golden"
+
+// itemKinds é a mistura de itens sorteada em InitializeItems e nas reaparições
+var itemKinds = []ItemKind{
+	{Name: "common", Value: 1, Weight: 80},
+	{Name: "rare", Value: 3, Weight: 17},
+	{Name: "legendary", Value: 5, Weight: 3},
+	{Name: ItemKindSpeed, Value: 0, Weight: 5},
+	{Name: "bomb", Value: -2, Weight: 8}, // Armadilha: tira pontos de quem pisa
+}
+
+// IsItemKind informa se name é um dos tipos de item do jogo, inclusive os que ficam fora do sorteio
+func IsItemKind(name string) bool {
+	if name == ItemKindGolden {
+		return true
+	}
+	for _, kind := range itemKinds {
+		if kind.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// randomItemKind sorteia um tipo de item respeitando os pesos de kinds
+func randomItemKind(rng *rand.Rand, kinds []ItemKind) ItemKind {
+	total := 0
+	for _, kind := range kinds {
+		total += kind.Weight
+	}
+	n := rng.Intn(total)
+	for _, kind := range kinds {
+		if n < kind.Weight {
+			return kind
+		}
+		n -= kind.Weight
+	}
+	return kinds[0]
+}
@@ -0,0 +1,23 @@
+package engine
+
+import "time"
+
+// Metrics recebe os eventos de uma sala para que a camada de transporte os exponha (por exemplo, no /metrics).
+// Todos os métodos podem ser chamados com os mutexes da sala travados, então não devem chamar de volta o GameState.
+// Para reagir aos acontecimentos da partida em si, veja EventSink.
+type Metrics interface {
+	RoomSize(roomID string, players int, spectators int, items int) // Chamado a cada broadcast com as contagens atuais
+	ItemCollected(roomID string)
+	GameCompleted(roomID string)
+	MessageDropped(roomID string) // Mensagem descartada por canal de envio cheio
+	BroadcastDuration(roomID string, d time.Duration)
+}
+
+// noMetrics é usado quando Config.Metrics não é definido
+type noMetrics struct{}
+
+func (noMetrics) RoomSize(string, int, int, int)          {}
+func (noMetrics) ItemCollected(string)                    {}
+func (noMetrics) GameCompleted(string)                    {}
+func (noMetrics) MessageDropped(string)                   {}
+func (noMetrics) BroadcastDuration(string, time.Duration) {}
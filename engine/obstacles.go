@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"math/rand"
+)
+
+// generateObstacles sorteia 'count' paredes no tabuleiro usando o gerador informado (o mesmo seed gera o mesmo
+// layout). Cada parede só é aceita se as células livres continuarem formando uma única região conectada,
+// garantindo que nenhuma parte do tabuleiro fique isolada.
+func generateObstacles(width, height, count int, rng *rand.Rand) []Point {
+	blocked := make(map[Point]bool, count)
+	obstacles := make([]Point, 0, count)
+
+	candidates := rng.Perm(width * height)
+	for _, idx := range candidates {
+		if len(obstacles) == count {
+			break
+		}
+		p := Point{X: idx % width, Y: idx / width}
+		blocked[p] = true
+		if !freeCellsConnected(width, height, blocked) {
+			delete(blocked, p) // Essa parede isolaria uma região: tenta a próxima célula
+			continue
+		}
+		obstacles = append(obstacles, p)
+	}
+	return obstacles
+}
+
+// freeCellsConnected verifica, com uma busca em largura, se todas as células livres são alcançáveis entre si
+func freeCellsConnected(width, height int, blocked map[Point]bool) bool {
+	free := width*height - len(blocked)
+	if free == 0 {
+		return true
+	}
+
+	var start Point
+search:
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !blocked[Point{X: x, Y: y}] {
+				start = Point{X: x, Y: y}
+				break search
+			}
+		}
+	}
+
+	visited := map[Point]bool{start: true}
+	queue := []Point{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range []Point{
+			{X: current.X, Y: current.Y - 1},
+			{X: current.X, Y: current.Y + 1},
+			{X: current.X - 1, Y: current.Y},
+			{X: current.X + 1, Y: current.Y},
+		} {
+			if next.X < 0 || next.Y < 0 || next.X >= width || next.Y >= height {
+				continue
+			}
+			if blocked[next] || visited[next] {
+				continue
+			}
+			visited[next] = true
+			queue = append(queue, next)
+		}
+	}
+	return len(visited) == free
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Prorrogação (morte súbita): no modo com tempo limite, se o cronômetro zera com a maior pontuação empatada, a
+// partida continua por até Config.Overtime, e a primeira coleta que deixa um único líder a encerra. Se a
+// prorrogação também acabar empatada, os empatados dividem a vitória, como sem ela.
+
+// inOvertimeLocked diz se a partida está na prorrogação. Quem chama deve segurar itemsMu.
+func (gs *GameState) inOvertimeLocked() bool {
+	return !gs.overtimeUntil.IsZero()
+}
+
+// tiedLocked diz se mais de um jogador (ou, no modo de equipes, mais de uma equipe) divide a liderança.
+// Quem chama deve segurar playersMu.
+func (gs *GameState) tiedLocked() bool {
+	if gs.teams > 0 {
+		teams, _ := gs.leadingTeamsLocked()
+		return len(teams) > 1
+	}
+	winners, _ := gs.leadersLocked()
+	return len(winners) > 1
+}
+
+// timeUpLocked trata o fim do cronômetro: começa a prorrogação se a liderança estiver empatada (e ela estiver
+// ligada) ou encerra a partida, inclusive quando é a prorrogação que acaba.
+// Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) timeUpLocked() {
+	switch {
+	case gs.inOvertimeLocked():
+		slog.Info("Prorrogação esgotada sem desempate", "room", gs.RoomID)
+		gs.endGameLocked()
+	case gs.overtime > 0 && gs.tiedLocked():
+		gs.overtimeUntil = gs.now().Add(gs.overtime)
+		slog.Info("Tempo esgotado com empate, começando a prorrogação", "room", gs.RoomID, "action", "overtime", "overtime", gs.overtime)
+		gs.restockLocked()
+	default:
+		slog.Info("Tempo esgotado", "room", gs.RoomID)
+		gs.endGameLocked()
+	}
+}
+
+// suddenDeathLocked encerra a prorrogação se a liderança deixou de estar empatada, retornando se encerrou.
+// Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) suddenDeathLocked() bool {
+	if !gs.inOvertimeLocked() || gs.tiedLocked() {
+		return false
+	}
+	slog.Info("Empate desfeito na prorrogação", "room", gs.RoomID)
+	gs.endGameLocked()
+	return true
+}
+
+// restockLocked repõe itens na prorrogação até haver algum que valha pontos, para que o empate ainda possa ser
+// desfeito; com o tabuleiro cheio, desiste. Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) restockLocked() {
+	for gs.scoringItemsLocked() == 0 {
+		item := gs.spawnItemLocked()
+		if item == nil {
+			return
+		}
+		slog.Debug("Item reposto na prorrogação", "room", gs.RoomID, "item", item.ID, "kind", item.Kind)
+	}
+}
+
+// overtimeLeftLocked é o tempo que falta da prorrogação (0 fora dela). Quem chama deve segurar itemsMu.
+func (gs *GameState) overtimeLeftLocked() time.Duration {
+	if !gs.inOvertimeLocked() {
+		return 0
+	}
+	return max(gs.overtimeUntil.Sub(gs.now()), 0)
+}
@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Persistência entre reinícios: Save copia a partida em andamento para uma estrutura serializável, e Restore a
+// aplica numa sala recém-criada com a mesma configuração. Conexões, canais, bots e espectadores ficam de fora;
+// os jogadores voltam como desconectados, à espera de uma reconexão com o token dentro de Config.ReconnectGrace.
+
+// ErrSavedBoard é retornado por Restore quando o estado salvo não cabe no tabuleiro da sala
+var ErrSavedBoard = errors.New("estado salvo não corresponde ao tabuleiro da sala")
+
+// SavedPlayer é o que um jogador leva para o disco: identidade, posição e pontuação, sem a conexão
+type SavedPlayer struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name,omitempty"`
+	Pos        Point         `json:"pos"`
+	Score      int           `json:"score"`
+	Team       int           `json:"team,omitempty"`
+	Color      string        `json:"color"`
+	Out        bool          `json:"out,omitempty"`
+	RoundsWon  int           `json:"roundsWon,omitempty"`
+	TotalScore int           `json:"totalScore,omitempty"`
+	ScoredAgo  time.Duration `json:"scoredAgo,omitempty"` // Há quanto tempo a pontuação atual foi atingida, para o desempate
+}
+
+// SavedState é a partida de uma sala como Save a deixa, pronta para virar JSON
+type SavedState struct {
+	BoardWidth   int           `json:"boardWidth"`
+	BoardHeight  int           `json:"boardHeight"`
+	Items        []Item        `json:"items"`
+	NextItemID   int           `json:"nextItemId"`
+	Players      []SavedPlayer `json:"players"` // Sem bots: o BotManager da sala coloca os seus
+	GameOver     bool          `json:"gameOver"`
+	WinnerIDs    []string      `json:"winnerIds,omitempty"`
+	WinningTeams []int         `json:"winningTeams,omitempty"`
+	ChampionIDs  []string      `json:"championIds,omitempty"`
+	Round        int           `json:"round,omitempty"`
+	Elapsed      time.Duration `json:"elapsed"`            // Tempo de partida já jogado, para o cronômetro continuar de onde parou
+	SinceEnd     time.Duration `json:"sinceEnd,omitempty"` // Tempo desde o fim da partida, para o reinício automático
+	Overtime     time.Duration `json:"overtime,omitempty"` // Tempo que falta da prorrogação em andamento
+}
+
+// Save copia a partida atual sob o lock de leitura, sem alterar o estado
+func (gs *GameState) Save() SavedState {
+	gs.rLockAll()
+	defer gs.rUnlockAll()
+
+	now := gs.now()
+	saved := SavedState{
+		BoardWidth:   gs.BoardWidth,
+		BoardHeight:  gs.BoardHeight,
+		Items:        make([]Item, 0, len(gs.Items)),
+		NextItemID:   gs.nextItemID,
+		Players:      []SavedPlayer{},
+		GameOver:     gs.GameOver,
+		WinnerIDs:    append([]string(nil), gs.WinnerIDs...),
+		WinningTeams: append([]int(nil), gs.WinningTeams...),
+		ChampionIDs:  append([]string(nil), gs.ChampionIDs...),
+		Round:        gs.round,
+		Elapsed:      now.Sub(gs.startedAt),
+		Overtime:     gs.overtimeLeftLocked(),
+	}
+	if gs.GameOver {
+		saved.Elapsed = gs.endedAt.Sub(gs.startedAt)
+		saved.SinceEnd = now.Sub(gs.endedAt)
+	}
+	for _, item := range gs.Items {
+		if item == gs.golden {
+			continue // Passageiro: não sobreviveria à espera do reinício
+		}
+		saved.Items = append(saved.Items, *item)
+	}
+	for _, p := range gs.Players {
+		if p.bot {
+			continue
+		}
+		saved.Players = append(saved.Players, SavedPlayer{
+			ID: p.ID, Name: p.Name, Pos: p.Pos, Score: p.Score, Team: p.Team, Color: p.Color, Out: p.Out,
+			RoundsWon: p.roundsWon, TotalScore: p.totalScore, ScoredAgo: now.Sub(p.scoredAt),
+		})
+	}
+	return saved
+}
+
+// Restore substitui a partida da sala pela salva. O tabuleiro precisa ter as mesmas dimensões, e itens e
+// jogadores precisam estar dentro dele e fora das paredes; senão retorna ErrSavedBoard e a sala não muda.
+// Os jogadores entram desconectados e são removidos se não reconectarem dentro de Config.ReconnectGrace (sem
+// prazo de reconexão, só os itens e o andamento da partida são restaurados). Jogadores que já estão na sala
+// mantêm o seu estado.
+func (gs *GameState) Restore(saved SavedState) error {
+	if err := gs.validateSaved(saved); err != nil {
+		return err
+	}
+
+	gs.lockAll()
+	defer gs.unlockAll()
+	defer gs.freezeClock()()
+
+	gs.record(recordEntry{Type: recordRestore, State: &saved})
+	gs.restoreLocked(saved)
+	return nil
+}
+
+// validateSaved confere o estado salvo contra o tabuleiro; as paredes não mudam depois de NewGameState, então
+// não precisa de lock
+func (gs *GameState) validateSaved(saved SavedState) error {
+	if saved.BoardWidth != gs.BoardWidth || saved.BoardHeight != gs.BoardHeight {
+		return fmt.Errorf("%w: tabuleiro salvo de %dx%d, configurado de %dx%d", ErrSavedBoard, saved.BoardWidth, saved.BoardHeight, gs.BoardWidth, gs.BoardHeight)
+	}
+	inside := func(p Point) bool {
+		return p.X >= 0 && p.X < gs.BoardWidth && p.Y >= 0 && p.Y < gs.BoardHeight && !gs.obstacleSet[p]
+	}
+	cells := make(map[Point]bool, len(saved.Items))
+	for _, item := range saved.Items {
+		if !inside(item.Pos) || cells[item.Pos] {
+			return fmt.Errorf("%w: item %s em (%d,%d)", ErrSavedBoard, item.ID, item.Pos.X, item.Pos.Y)
+		}
+		cells[item.Pos] = true
+	}
+	for _, p := range saved.Players {
+		if p.ID == "" || !inside(p.Pos) {
+			return fmt.Errorf("%w: jogador %q em (%d,%d)", ErrSavedBoard, p.ID, p.Pos.X, p.Pos.Y)
+		}
+	}
+	return nil
+}
+
+// restoreLocked é o corpo de Restore, compartilhado com o replay; quem chama deve segurar os dois mutexes para
+// escrita
+func (gs *GameState) restoreLocked(saved SavedState) {
+	now := gs.now()
+	gs.Items = make(map[string]*Item, len(saved.Items))
+	gs.itemGrid = make(itemGrid)
+	gs.golden = nil
+	for _, item := range saved.Items {
+		item.Symbol = gs.itemSymbols[item.Kind] // Vale a configuração atual, não a de quem salvou
+		gs.Items[fmt.Sprintf("%d,%d", item.Pos.X, item.Pos.Y)] = &item
+		gs.itemGrid.add(&item)
+	}
+	gs.nextItemID = max(gs.nextItemID, saved.NextItemID)
+	gs.GameOver = saved.GameOver
+	gs.WinnerIDs = saved.WinnerIDs
+	gs.WinningTeams = saved.WinningTeams
+	gs.ChampionIDs = saved.ChampionIDs
+	gs.round = saved.Round
+	gs.startedAt = now.Add(-saved.Elapsed)
+	gs.overtimeUntil = time.Time{}
+	if saved.Overtime > 0 {
+		gs.overtimeUntil = now.Add(saved.Overtime)
+	}
+	if saved.GameOver {
+		gs.startedAt = now.Add(-saved.SinceEnd - saved.Elapsed)
+		gs.endedAt = now.Add(-saved.SinceEnd)
+	}
+
+	restored := 0
+	if gs.reconnectGrace > 0 {
+		for _, s := range saved.Players {
+			if _, ok := gs.Players[s.ID]; ok {
+				continue
+			}
+			player := &Player{
+				ID:           s.ID,
+				Name:         sanitizeName(s.Name),
+				Pos:          s.Pos,
+				Score:        s.Score,
+				Team:         gs.assignTeamLocked(s.Team),
+				Color:        s.Color,
+				Out:          s.Out,
+				lastActivity: now,
+				roundsWon:    s.RoundsWon,
+				totalScore:   s.TotalScore,
+				scoredAt:     now.Add(-s.ScoredAgo),
+			}
+			if player.Color == "" {
+				player.Color = gs.assignColorLocked(s.ID)
+			}
+			gs.Players[s.ID] = player
+			gs.scheduleExpiryLocked(player)
+			restored++
+		}
+	}
+	gs.resetFreeCellsLocked()
+	slog.Info("Partida restaurada", "room", gs.RoomID, "action", "restore", "items", len(gs.Items), "players", restored, "game_over", gs.GameOver)
+}
@@ -0,0 +1,519 @@
+package engine
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	MaxNameLength     = 16  // Tamanho máximo (em caracteres) do apelido de um jogador
+	DefaultSendBuffer = 256 // Capacidade do sendChan de cada conexão quando Config.SendBuffer não é definido
+)
+
+type Player struct {
+	ID           string        `json:"id"`
+	Name         string        `json:"name,omitempty"`
+	Pos          Point         `json:"pos"`
+	Score        int           `json:"score"`
+	Team         int           `json:"team,omitempty"` // Equipe do jogador (1 a Config.Teams); 0 fora do modo de equipes
+	Color        string        `json:"color"`          // Cor do jogador no cliente (hexadecimal), única na sala enquanto houver cores livres
+	Body         []Point       `json:"body,omitempty"` // Segmentos do rastro, do mais próximo ao mais distante (modo rastro)
+	Out          bool          `json:"out,omitempty"`  // Eliminado nesta partida por bater num rastro
+	sendChan     chan []byte   // Mensagens de saída, consumidas pelo 'writer' da conexão atual
+	IsActive     bool          `json:"isActive"`
+	Spectator    bool          `json:"spectator,omitempty"` // Espectadores recebem o estado mas não ocupam célula nem jogam
+	lastMove     time.Time     // Momento do último movimento aceito, para o limite de taxa
+	intents      []string      // Direções pedidas pelo cliente, aplicadas no próximo tick do gameLoop (até Config.MovesPerTick)
+	session      int           // Incrementado a cada desconexão, para que remoções agendadas antigas não afetem uma reconexão
+	dropped      int           // Mensagens descartadas seguidas por canal cheio; zerado a cada entrega
+	overflowed   bool          // O canal da conexão atual já encheu alguma vez (o aviso é registrado só na primeira)
+	bot          bool          // Controlado pelo servidor (BotManager), sem conexão WebSocket
+	speedUntil   time.Time     // Fim do power-up de velocidade; zero quando o jogador não tem o power-up
+	lastActivity time.Time     // Último movimento processado (ou entrada, reconexão e início de partida), para KickIdle
+	lastChat     time.Time     // Momento da última mensagem de chat aceita, para o limite de taxa do chat
+	roundsWon    int           // Rodadas vencidas na série atual (modo de rodadas)
+	totalScore   int           // Pontos somados nas rodadas já encerradas da série, para desempatar o campeão
+	latency      time.Duration // Último RTT medido por ping/pong na conexão atual; 0 quando desconhecido
+	lang         string        // Idioma da conexão atual (SetLang), passado a quem monta os avisos de KickPlayer e KickIdle
+	streak       int           // Coletas com pontos seguidas, cada uma até Config.ComboWindow depois da anterior
+	lastCollect  time.Time     // Momento da última coleta que entrou na sequência
+	scoredAt     time.Time     // Momento em que a pontuação atual foi atingida, para o desempate de Config.TieBreak
+
+	teleportedTick int // Último tick em que o jogador passou por um portal, para não teleportá-lo duas vezes no mesmo tick
+}
+
+// sanitizeName remove caracteres de controle e espaços nas bordas e limita o apelido a MaxNameLength caracteres
+func sanitizeName(name string) string {
+	return sanitizeText(name, MaxNameLength)
+}
+
+// sanitizeText remove caracteres de controle e espaços nas bordas e limita o texto a limit caracteres
+func sanitizeText(text string, limit int) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.TrimSpace(text)
+	if runes := []rune(text); len(runes) > limit {
+		text = strings.TrimSpace(string(runes[:limit]))
+	}
+	return text
+}
+
+// AddPlayer coloca um novo jogador numa posição livre e retorna o canal por onde ele recebe mensagens.
+// O canal também identifica a conexão em DisconnectPlayer. No modo de equipes, team escolhe a equipe (0 ou um
+// número inválido distribui em rodízio). Retorna ErrRoomFull se a sala já tiver Config.MaxPlayers jogadores
+// reais, ou ErrBoardFull se não houver célula livre.
+func (gs *GameState) AddPlayer(id string, name string, team int) (*Player, chan []byte, error) {
+	return gs.addPlayer(id, name, team, false)
+}
+
+// addPlayer é o corpo de AddPlayer, compartilhado com os bots
+func (gs *GameState) addPlayer(id string, name string, team int, bot bool) (*Player, chan []byte, error) {
+	gs.lockAll() // Precisa das células livres e do rng
+	defer gs.unlockAll()
+
+	if !bot && gs.maxPlayers > 0 && gs.playerSlotsLocked() >= gs.maxPlayers {
+		return nil, nil, ErrRoomFull
+	}
+
+	startPos, ok := gs.randomFreeCellLocked() // Nem sobre outro jogador, nem numa parede, nem em cima de um item
+	if !ok {
+		return nil, nil, ErrBoardFull
+	}
+
+	player := &Player{
+		ID:           id,
+		Name:         sanitizeName(name),
+		Pos:          startPos,
+		Score:        gs.startScore,
+		Team:         gs.assignTeamLocked(team),
+		Color:        gs.assignColorLocked(id),
+		sendChan:     make(chan []byte, gs.sendBuffer), // Canal bufferizado para mensagens de saída
+		IsActive:     true,
+		bot:          bot,
+		lastActivity: gs.now(),
+		scoredAt:     gs.now(),
+	}
+	gs.Players[id] = player
+	gs.playerCells[startPos] = player
+	gs.record(recordEntry{Type: recordJoin, PlayerID: id, Name: player.Name, Team: player.Team, Bot: bot})
+	gs.refreshCellLocked(startPos)
+	gs.publish(GameEvent{Type: EventPlayerJoined, PlayerID: id, Name: player.Name, Bot: bot})
+	slog.Info("Jogador entrou", "room", gs.RoomID, "player_id", id, "action", "join", "x", player.Pos.X, "y", player.Pos.Y, "players", len(gs.Players))
+	if !bot {
+		gs.signalWake()
+	}
+	return player, player.sendChan, nil
+}
+
+// AddSpectator registra uma conexão que apenas assiste à partida, sem entrar em gs.Players
+func (gs *GameState) AddSpectator(id string) (*Player, chan []byte) {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	spectator := &Player{
+		ID:        id,
+		sendChan:  make(chan []byte, gs.sendBuffer),
+		IsActive:  true,
+		Spectator: true,
+	}
+	gs.Spectators[id] = spectator
+	slog.Info("Espectador entrou", "room", gs.RoomID, "player_id", id, "action", "spectate", "spectators", len(gs.Spectators))
+	gs.signalWake()
+	return spectator, spectator.sendChan
+}
+
+// Wake recebe um sinal a cada conexão que chega à sala (jogador, espectador ou reconexão), para que o gameLoop
+// saia do ritmo ocioso sem esperar o próximo tick lento
+func (gs *GameState) Wake() <-chan struct{} {
+	return gs.wake
+}
+
+// signalWake avisa Wake sem bloquear; sinais acumulados valem por um só
+func (gs *GameState) signalWake() {
+	select {
+	case gs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Empty informa se a sala está vazia: sem jogadores reais, nem mesmo aguardando reconexão, e sem espectadores.
+// Bots não contam.
+func (gs *GameState) Empty() bool {
+	gs.playersMu.RLock()
+	defer gs.playersMu.RUnlock()
+
+	return len(gs.Spectators) == 0 && gs.playerSlotsLocked() == 0
+}
+
+// HasConnections informa se há alguém conectado à sala: um jogador real ativo ou um espectador. Bots não contam,
+// pois não recebem o estado por rede.
+func (gs *GameState) HasConnections() bool {
+	gs.playersMu.RLock()
+	defer gs.playersMu.RUnlock()
+
+	if len(gs.Spectators) > 0 {
+		return true
+	}
+	for _, p := range gs.Players {
+		if p.IsActive && !p.bot {
+			return true
+		}
+	}
+	return false
+}
+
+func (gs *GameState) RemoveSpectator(id string) {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	gs.removeSpectatorLocked(id)
+}
+
+// removeSpectatorLocked remove o espectador e fecha seu canal; quem chama deve segurar playersMu
+func (gs *GameState) removeSpectatorLocked(id string) {
+	if spectator, ok := gs.Spectators[id]; ok {
+		spectator.IsActive = false
+		close(spectator.sendChan)
+		delete(gs.Spectators, id)
+		slog.Info("Espectador saiu", "room", gs.RoomID, "player_id", id, "action", "leave", "spectators", len(gs.Spectators))
+	}
+}
+
+// applyFloor limita uma pontuação à mínima da sala (Config.ScoreFloor), a não ser que ela esteja desligada
+func (gs *GameState) applyFloor(score int) int {
+	if gs.noScoreFloor {
+		return score
+	}
+	return max(score, gs.scoreFloor)
+}
+
+// SetLatency registra o RTT medido na conexão de sendChan (0 marca a latência como desconhecida, por exemplo
+// quando um pong não chegou). Conexões antigas de um jogador que já reconectou são ignoradas.
+func (gs *GameState) SetLatency(id string, sendChan chan []byte, rtt time.Duration) {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	p, ok := gs.Players[id]
+	if !ok {
+		p, ok = gs.Spectators[id]
+	}
+	if ok && p.IsActive && p.sendChan == sendChan {
+		p.latency = rtt
+	}
+}
+
+// SetLang registra o idioma da conexão de sendChan, para que os avisos de expulsão saiam nele. O engine não
+// interpreta o valor; só o repassa a quem monta o aviso. Conexões antigas de um jogador que já reconectou são
+// ignoradas.
+func (gs *GameState) SetLang(id string, sendChan chan []byte, lang string) {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	p, ok := gs.Players[id]
+	if !ok {
+		p, ok = gs.Spectators[id]
+	}
+	if ok && p.IsActive && p.sendChan == sendChan {
+		p.lang = lang
+	}
+}
+
+// latencyMs é a latência do jogador em milissegundos, para o JSON: 0 quando desconhecida e pelo menos 1 quando
+// medida, para uma rede local não parecer sem medição. Quem chama deve segurar playersMu.
+func (p *Player) latencyMs() int {
+	if p.latency <= 0 {
+		return 0
+	}
+	return max(1, int(p.latency.Round(time.Millisecond)/time.Millisecond))
+}
+
+// SetPlayerName troca o apelido de um jogador já conectado
+func (gs *GameState) SetPlayerName(id string, name string) {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	if player, ok := gs.Players[id]; ok {
+		player.Name = sanitizeName(name)
+		gs.record(recordEntry{Type: recordName, PlayerID: id, Name: player.Name})
+		slog.Info("Jogador trocou de apelido", "room", gs.RoomID, "player_id", id, "action", "set_name", "name", player.Name)
+	}
+}
+
+// DisconnectPlayer é chamada quando a conexão de um jogador cai. O jogador fica inativo, mas mantém posição
+// e pontuação por reconnectGrace para poder voltar com seu token; só depois disso é removido de fato.
+// sendChan é o canal recebido ao entrar, para distinguir a conexão atual de uma antiga.
+func (gs *GameState) DisconnectPlayer(id string, sendChan chan []byte) {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	player, ok := gs.Players[id]
+	if !ok || !player.IsActive || player.sendChan != sendChan { // Ignora conexões antigas de um jogador que já reconectou
+		return
+	}
+	gs.disconnectLocked(player)
+}
+
+// disconnectLocked marca o jogador como desconectado e agenda sua remoção; quem chama deve segurar playersMu
+// (e não itemsMu, que é travado aqui para liberar a célula do jogador)
+func (gs *GameState) disconnectLocked(player *Player) {
+	id := player.ID
+	if gs.reconnectGrace <= 0 {
+		gs.removePlayerLocked(id)
+		return
+	}
+
+	gs.record(recordEntry{Type: recordDisconnect, PlayerID: id})
+	player.IsActive = false
+	close(player.sendChan) // Para o 'writer' desta conexão
+	player.speedUntil = time.Time{}
+	player.streak = 0
+	gs.dropTrail(player)
+	delete(gs.playerCells, player.Pos)
+	gs.updateCell(player.Pos)
+	player.session++
+	slog.Info("Jogador desconectado, aguardando reconexão", "room", gs.RoomID, "player_id", id, "action", "disconnect", "grace", gs.reconnectGrace)
+	gs.scheduleExpiryLocked(player)
+}
+
+// scheduleExpiryLocked agenda a remoção de um jogador desconectado para o fim do prazo de reconexão; uma
+// reconexão antes disso (que incrementa session) cancela a remoção. Quem chama deve segurar playersMu.
+func (gs *GameState) scheduleExpiryLocked(player *Player) {
+	if gs.replaying {
+		return
+	}
+	id, session := player.ID, player.session
+	time.AfterFunc(gs.reconnectGrace, func() {
+		gs.playersMu.Lock()
+		defer gs.playersMu.Unlock()
+		if p, ok := gs.Players[id]; ok && p == player && !p.IsActive && p.session == session {
+			gs.expirePlayerLocked(p)
+		}
+	})
+}
+
+// expirePlayerLocked remove um jogador desconectado cujo prazo de reconexão acabou; quem chama deve segurar playersMu
+func (gs *GameState) expirePlayerLocked(player *Player) {
+	gs.record(recordEntry{Type: recordExpire, PlayerID: player.ID})
+	delete(gs.Players, player.ID)
+	gs.publish(GameEvent{Type: EventPlayerLeft, PlayerID: player.ID, Bot: player.bot})
+	slog.Info("Jogador não reconectou a tempo e foi removido", "room", gs.RoomID, "player_id", player.ID, "action", "leave", "players", len(gs.Players))
+}
+
+// ReconnectPlayer religa um jogador a uma nova conexão, preservando a pontuação e, se a célula dele continuar
+// livre, a posição. Enquanto ele estava desconectado a célula ficou livre, então um item ou outro jogador pode
+// tê-la ocupado; nesse caso ele volta numa célula livre sorteada. Retorna nil se o jogador não existe mais na
+// sala, ou ErrBoardFull se a célula foi ocupada e não sobrou nenhuma livre (o jogador continua aguardando).
+func (gs *GameState) ReconnectPlayer(id string) (*Player, chan []byte, error) {
+	gs.lockAll() // Precisa das células livres e do rng
+	defer gs.unlockAll()
+
+	player, ok := gs.Players[id]
+	if !ok {
+		return nil, nil, nil
+	}
+	if _, free := gs.freeIndex[player.Pos]; !player.IsActive && !free {
+		pos, ok := gs.randomFreeCellLocked()
+		if !ok {
+			return nil, nil, ErrBoardFull
+		}
+		slog.Info("Célula ocupada durante a desconexão, jogador volta em outra", "room", gs.RoomID, "player_id", id, "x", pos.X, "y", pos.Y)
+		player.Pos = pos
+	}
+	gs.record(recordEntry{Type: recordReconnect, PlayerID: id}) // Só a reconexão aceita: o replay sorteia a mesma célula
+	if player.IsActive {
+		// A conexão antiga ainda não caiu do lado do servidor: o 'writer' dela encerra e fecha o socket antigo,
+		// e a limpeza do 'reader' antigo é ignorada por DisconnectPlayer, pois o canal não confere mais
+		close(player.sendChan)
+	}
+	player.session++ // Invalida uma remoção agendada pela desconexão anterior
+	player.sendChan = make(chan []byte, gs.sendBuffer)
+	player.dropped = 0
+	player.overflowed = false
+	player.latency = 0 // A medição era da conexão antiga
+	player.lang = ""   // Também o idioma, que a nova conexão informa de novo
+	player.IsActive = true
+	player.lastActivity = gs.now()
+	gs.playerCells[player.Pos] = player
+	gs.refreshCellLocked(player.Pos) // Volta a ocupar a célula
+	gs.signalWake()
+	slog.Info("Jogador reconectou", "room", gs.RoomID, "player_id", id, "action", "reconnect", "x", player.Pos.X, "y", player.Pos.Y, "score", player.Score)
+	return player, player.sendChan, nil
+}
+
+func (gs *GameState) RemovePlayer(id string) {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	gs.removePlayerLocked(id)
+}
+
+// removePlayerLocked remove o jogador imediatamente; quem chama deve segurar playersMu (e não itemsMu)
+func (gs *GameState) removePlayerLocked(id string) {
+	if player, ok := gs.Players[id]; ok {
+		gs.record(recordEntry{Type: recordRemove, PlayerID: id})
+		if player.IsActive {
+			player.IsActive = false // Marca como inativo
+			close(player.sendChan)  // Fecha o canal de envio, sinalizando para a goroutine 'writer' parar
+			delete(gs.playerCells, player.Pos)
+		}
+		delete(gs.Players, id) // Remove do mapa principal
+		gs.publish(GameEvent{Type: EventPlayerLeft, PlayerID: id, Bot: player.bot})
+		player.speedUntil = time.Time{}
+		gs.dropTrail(player)
+		gs.updateCell(player.Pos)
+		slog.Info("Jogador removido", "room", gs.RoomID, "player_id", id, "action", "leave", "players", len(gs.Players))
+	}
+}
+
+// KickIdle remove os jogadores conectados que não se movem há mais de Config.IdleTimeout, com o aviso montado por
+// notice (veja KickPlayer). Bots, espectadores, jogadores eliminados e desconectados (que já têm o prazo de
+// reconexão) não são afetados, e nada é verificado entre partidas, quando ninguém pode se mover.
+func (gs *GameState) KickIdle(notice func(lang string) []byte) int {
+	if gs.idleTimeout <= 0 {
+		return 0
+	}
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	gs.itemsMu.RLock() // Só para ler GameOver; removePlayerLocked trava itemsMu de novo para liberar as células
+	gameOver := gs.GameOver
+	gs.itemsMu.RUnlock()
+	if gameOver {
+		return 0
+	}
+
+	now := gs.now()
+	ids := []string{}
+	for id, p := range gs.Players {
+		if !p.bot && p.IsActive && !p.Out && now.Sub(p.lastActivity) >= gs.idleTimeout {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids) // Mesma ordem de remoção (e de células liberadas) na gravação e no replay
+
+	for _, id := range ids {
+		slog.Info("Jogador inativo removido", "room", gs.RoomID, "player_id", id, "action", "idle_kick", "idle_timeout", gs.idleTimeout)
+		gs.kickLocked(gs.Players[id], notice)
+	}
+	return len(ids)
+}
+
+// KickPlayer remove um jogador ou espectador imediatamente, sem prazo de reconexão. notice monta a mensagem de
+// aviso no idioma da conexão (o registrado por SetLang, ou vazio), que é entregue antes de o canal ser fechado,
+// para que o 'writer' a envie e encerre a conexão com um frame de fechamento. A remoção é a mesma de
+// RemovePlayer, então o próximo broadcast e os eventos já refletem a saída. Retorna false se o ID não está na sala.
+func (gs *GameState) KickPlayer(id string, notice func(lang string) []byte) bool {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	p, ok := gs.Players[id]
+	if !ok {
+		p, ok = gs.Spectators[id]
+	}
+	if !ok {
+		return false
+	}
+	slog.Info("Jogador expulso", "room", gs.RoomID, "player_id", id, "action", "kick")
+	gs.kickLocked(p, notice)
+	return true
+}
+
+// kickLocked entrega o aviso sem bloquear e remove o jogador ou espectador; quem chama deve segurar playersMu (e não itemsMu)
+func (gs *GameState) kickLocked(p *Player, notice func(lang string) []byte) {
+	if p.IsActive {
+		select {
+		case p.sendChan <- notice(p.lang):
+		default: // Canal cheio: o cliente só vê a conexão fechar
+		}
+	}
+	if p.Spectator {
+		gs.removeSpectatorLocked(p.ID)
+	} else {
+		gs.removePlayerLocked(p.ID)
+	}
+}
+
+// CloseAllPlayers remove todos os jogadores e espectadores, fechando seus canais de envio para que cada 'writer'
+// esvazie as mensagens pendentes e encerre a conexão com um frame de fechamento normal
+func (gs *GameState) CloseAllPlayers() {
+	gs.playersMu.Lock()
+	defer gs.playersMu.Unlock()
+
+	for id, player := range gs.Players {
+		if player.IsActive { // Jogadores aguardando reconexão já tiveram o canal fechado
+			player.IsActive = false
+			close(player.sendChan)
+		}
+		delete(gs.Players, id)
+	}
+	for id, spectator := range gs.Spectators {
+		spectator.IsActive = false
+		close(spectator.sendChan)
+		delete(gs.Spectators, id)
+	}
+	gs.itemsMu.Lock()
+	gs.resetFreeCellsLocked()
+	gs.itemsMu.Unlock()
+	slog.Info("Todos os jogadores e espectadores foram desconectados", "room", gs.RoomID)
+}
+
+// playerSlotsLocked conta as vagas ocupadas para Config.MaxPlayers: jogadores reais, inclusive os desconectados
+// que ainda podem reconectar. Quem chama deve segurar playersMu.
+func (gs *GameState) playerSlotsLocked() int {
+	count := 0
+	for _, p := range gs.Players {
+		if !p.bot {
+			count++
+		}
+	}
+	return count
+}
+
+// updateCell atualiza o conjunto de células livres depois que um jogador ativo saiu de uma célula ou voltou a
+// ocupá-la numa reconexão. Quem chama deve segurar playersMu; itemsMu é travado aqui, respeitando a ordem dos locks.
+func (gs *GameState) updateCell(pos Point) {
+	gs.itemsMu.Lock()
+	defer gs.itemsMu.Unlock()
+	gs.refreshCellLocked(pos)
+}
+
+// collectComboLocked soma uma coleta com pontos à sequência do jogador e retorna o multiplicador dela: a
+// sequência recomeça se a coleta anterior foi há mais de comboWindow, e o multiplicador para em comboMax.
+// Quem chama deve segurar playersMu para escrita.
+func (gs *GameState) collectComboLocked(p *Player, now time.Time) int {
+	if !gs.comboOn() {
+		return 1
+	}
+	if now.Sub(p.lastCollect) > gs.comboWindow {
+		p.streak = 0
+	}
+	p.streak++
+	p.lastCollect = now
+	return min(p.streak, gs.comboMax)
+}
+
+// comboLocked é o multiplicador da sequência em andamento no instante now (1 sem sequência ou com a janela
+// vencida). Quem chama deve segurar playersMu.
+func (gs *GameState) comboLocked(p *Player, now time.Time) int {
+	if !gs.comboOn() || p.streak == 0 || now.Sub(p.lastCollect) > gs.comboWindow {
+		return 1
+	}
+	return min(p.streak, gs.comboMax)
+}
+
+// comboOn diz se o multiplicador de sequência está ligado
+func (gs *GameState) comboOn() bool {
+	return gs.comboWindow > 0 && gs.comboMax > 1
+}
+
+// boosted diz se o jogador está com o power-up de velocidade no instante now. Quem chama deve segurar playersMu.
+func (p *Player) boosted(now time.Time) bool {
+	return now.Before(p.speedUntil)
+}
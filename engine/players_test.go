@@ -0,0 +1,329 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Rode com -race: entradas, movimentos e saídas concorrentes, com o gameLoop aplicando os ticks ao mesmo tempo
+func TestConcurrentJoinMoveLeave(t *testing.T) {
+	gs := newTestGame(t, Config{BoardWidth: 12, BoardHeight: 12, NumItems: 40})
+	gs.InitializeItems()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // O papel do gameLoop
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			gs.ProcessTick()
+			gs.ResetIfOver()
+		}
+	}()
+	directions := []string{"up", "down", "left", "right", "up_left", "down_right"}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var joined []string
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				id := fmt.Sprintf("w%d-%d", w, i)
+				if _, _, err := gs.AddPlayer(id, id, 0); err != nil {
+					if !errors.Is(err, ErrBoardFull) {
+						t.Errorf("AddPlayer(%q): %v", id, err)
+					}
+				} else {
+					joined = append(joined, id)
+				}
+				for _, id := range joined {
+					gs.QueueMove(id, directions[(i+len(id))%len(directions)])
+				}
+				if len(joined) > 3 { // Cada goroutine mantém até três jogadores na sala
+					gs.RemovePlayer(joined[0])
+					joined = joined[1:]
+				}
+			}
+		}()
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	checkBoard(t, gs)
+}
+
+func TestReconnectIntoTakenCell(t *testing.T) {
+	gs := newTestGame(t, Config{ReconnectGrace: time.Minute})
+	a := joinAt(t, gs, "a", Point{1, 1})
+	joinAt(t, gs, "b", Point{2, 1})
+	putItem(gs, Point{4, 4}, 1)
+	gs.DisconnectPlayer("a", a.sendChan)
+
+	// b entra na célula que a deixou livre
+	if err := gs.QueueMove("b", "left"); err != nil {
+		t.Fatal(err)
+	}
+	gs.ProcessTick()
+	p, _, err := gs.ReconnectPlayer("a")
+	if err != nil || p != a {
+		t.Fatalf("ReconnectPlayer: %v, %v", p, err)
+	}
+	if a.Pos == (Point{1, 1}) {
+		t.Error("a voltou para a célula ocupada por b")
+	}
+	checkBoard(t, gs)
+
+	// Sem célula livre, a reconexão é recusada e a continua aguardando
+	gs.DisconnectPlayer("a", a.sendChan)
+	gs.lockAll()
+	for len(gs.freeCells) > 0 {
+		gs.placeItemLocked(gs.freeCells[0], ItemKind{Name: "common", Value: 1})
+	}
+	gs.unlockAll()
+	if _, _, err := gs.ReconnectPlayer("a"); !errors.Is(err, ErrBoardFull) || a.IsActive {
+		t.Errorf("reconexão sem célula livre: %v, ativo %v", err, a.IsActive)
+	}
+	checkBoard(t, gs)
+}
+
+// Rode com -race: tudo o que mexe na sala ao mesmo tempo (entradas, movimentos, quedas, reconexões, ticks e
+// broadcasts). No fim, as invariantes do tabuleiro e a contagem de itens do snapshot precisam valer.
+func TestConcurrentStress(t *testing.T) {
+	gs := newTestGame(t, Config{BoardWidth: 10, BoardHeight: 10, NumItems: 30, ReconnectGrace: time.Minute})
+	gs.InitializeItems()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // O papel do gameLoop
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			gs.ProcessTick()
+			gs.BroadcastGameState()
+			gs.ResetIfOver()
+		}
+	}()
+	type conn struct {
+		id        string
+		ch        chan []byte
+		connected bool
+	}
+	directions := []string{"up", "down", "left", "right", "up_left", "down_right"}
+	for w := 0; w < 6; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var conns []*conn
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, c := range conns { // O papel dos 'writer's
+					for len(c.ch) > 0 {
+						<-c.ch
+					}
+				}
+				switch i % 4 {
+				case 0:
+					id := fmt.Sprintf("w%d-%d", w, i)
+					if _, ch, err := gs.AddPlayer(id, id, 0); err == nil {
+						conns = append(conns, &conn{id, ch, true})
+					} else if !errors.Is(err, ErrBoardFull) {
+						t.Errorf("AddPlayer(%q): %v", id, err)
+					}
+				case 1:
+					for _, c := range conns {
+						gs.QueueMove(c.id, directions[(i+len(c.id))%len(directions)])
+					}
+				case 2:
+					if len(conns) > 0 {
+						if c := conns[i%len(conns)]; c.connected {
+							gs.DisconnectPlayer(c.id, c.ch)
+							c.connected = false
+						}
+					}
+				case 3:
+					for _, c := range conns {
+						if !c.connected {
+							if _, ch, err := gs.ReconnectPlayer(c.id); err == nil && ch != nil {
+								c.ch, c.connected = ch, true
+							} else if err != nil && !errors.Is(err, ErrBoardFull) {
+								t.Errorf("ReconnectPlayer(%q): %v", c.id, err)
+							}
+						}
+					}
+				}
+				if len(conns) > 4 { // Cada goroutine mantém até quatro jogadores na sala
+					gs.RemovePlayer(conns[0].id)
+					conns = conns[1:]
+				}
+			}
+		}()
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	checkBoard(t, gs)
+	data, err := gs.snapshotForClient(false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state stateSnapshot
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.ItemsLeft != len(gs.Items) || len(state.Items) != len(gs.Items) {
+		t.Errorf("snapshot com itemsRemaining %d e %d itens, a sala tem %d", state.ItemsLeft, len(state.Items), len(gs.Items))
+	}
+}
+
+// withTimeout roda f e falha o teste se ela não retornar em um segundo
+func withTimeout(t *testing.T, name string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s não retornou com o tabuleiro cheio", name)
+	}
+}
+
+func TestFullBoard(t *testing.T) {
+	// 2x2 com paredes numa diagonal: sobram duas células, uma para o jogador e uma para o item
+	gs := newTestGame(t, Config{BoardWidth: 2, BoardHeight: 2, NumItems: 3})
+	gs.lockAll()
+	for _, wall := range []Point{{0, 0}, {1, 1}} {
+		gs.Obstacles = append(gs.Obstacles, wall)
+		gs.obstacleSet[wall] = true
+	}
+	gs.resetFreeCellsLocked()
+	gs.unlockAll()
+	joinAt(t, gs, "a", Point{1, 0})
+
+	withTimeout(t, "InitializeItems", func() { gs.InitializeItems() })
+	if len(gs.Items) != 1 || itemAt(gs, Point{0, 1}) == nil {
+		t.Errorf("com uma célula livre, InitializeItems colocou %d itens", len(gs.Items))
+	}
+	withTimeout(t, "AddPlayer", func() {
+		if _, _, err := gs.AddPlayer("b", "b", 0); !errors.Is(err, ErrBoardFull) {
+			t.Errorf("AddPlayer no tabuleiro cheio: %v, deveria ser ErrBoardFull", err)
+		}
+	})
+	checkBoard(t, gs)
+}
+
+func TestKickNoticePerConnectionLang(t *testing.T) {
+	gs := newTestGame(t, Config{IdleTimeout: time.Minute})
+	chans := make(map[string]chan []byte)
+	for i, lang := range []string{"pt", "en", ""} { // Sem SetLang, o aviso é montado com o idioma vazio
+		p := joinAt(t, gs, "p"+lang, Point{i, 0})
+		chans[lang] = p.sendChan
+		if lang != "" {
+			gs.SetLang(p.ID, p.sendChan, lang)
+		}
+	}
+	later := time.Now().Add(2 * time.Minute)
+	gs.clock = func() time.Time { return later }
+
+	if kicked := gs.KickIdle(func(lang string) []byte { return []byte("idle:" + lang) }); kicked != 3 {
+		t.Fatalf("KickIdle removeu %d jogadores, deveria remover 3", kicked)
+	}
+	for lang, ch := range chans {
+		if got := string(<-ch); got != "idle:"+lang {
+			t.Errorf("aviso da conexão em %q: %q", lang, got)
+		}
+	}
+}
+
+// Entrada numa sala grande: AddPlayer seguido do estado completo por SendSnapshot, como faz o wsHandler. Em
+// "concorrente", quatro goroutines montam snapshots ao mesmo tempo e só o AddPlayer é medido, para ver quanto ele
+// espera pelo lock exclusivo.
+func BenchmarkAddPlayer(b *testing.B) {
+	for _, bc := range []struct {
+		players    int
+		concurrent bool
+	}{{10, false}, {100, false}, {500, false}, {500, true}} {
+		name := fmt.Sprintf("jogadores=%d", bc.players)
+		if bc.concurrent {
+			name += "/concorrente"
+		}
+		b.Run(name, func(b *testing.B) {
+			gs := newTestGame(b, Config{BoardWidth: 200, BoardHeight: 200, NumItems: 500})
+			for i := 0; i < bc.players; i++ {
+				id := fmt.Sprintf("p%d", i)
+				if _, _, err := gs.AddPlayer(id, id, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+			gs.InitializeItems()
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			if bc.concurrent {
+				for w := 0; w < 4; w++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						ch := make(chan []byte, 1)
+						for {
+							select {
+							case <-stop:
+								return
+							default:
+							}
+							gs.SendSnapshot("", ch) // Sem um jogador com esse ID, só monta e serializa
+						}
+					}()
+				}
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				id := fmt.Sprintf("novo%d", i)
+				_, ch, err := gs.AddPlayer(id, id, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				if !bc.concurrent {
+					b.StartTimer()
+					gs.SendSnapshot(id, ch)
+					<-ch
+					b.StopTimer()
+				}
+				gs.RemovePlayer(id) // Mantém a sala com o mesmo número de jogadores
+				b.StartTimer()
+			}
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
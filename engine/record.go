@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Gravação de partidas: a sala registra a seed e a configuração com que foi criada e, depois, cada acontecimento
+// que muda o estado fora do sorteio (entradas, saídas, resets, reaparições de itens e os movimentos aplicados em
+// cada tick), com o número do tick e o instante em que aconteceu. Como o sorteio só depende da seed e o tick
+// aplica as intenções em ordem fixa, Replay reconstrói a mesma sequência de coletas e vencedores.
+// O formato é JSON Lines: um recordHeader na primeira linha e um recordEntry por linha em seguida.
+
+// Tipos de recordEntry
+const (
+	recordJoin       = "join"
+	recordName       = "name"
+	recordDisconnect = "disconnect"
+	recordReconnect  = "reconnect"
+	recordRemove     = "remove"
+	recordExpire     = "expire" // Remoção pelo fim do prazo de reconexão
+	recordReset      = "reset"
+	recordRespawn    = "respawn"
+	recordGolden     = "golden" // Diamante dourado sorteado por ProcessTick (recolocado por SpawnGolden no replay)
+	recordTick       = "tick"
+	recordRestore    = "restore" // Partida restaurada de um estado salvo (Restore)
+)
+
+// recordHeader abre a gravação com o necessário para recriar a sala
+type recordHeader struct {
+	RoomID string    `json:"roomId"`
+	Seed   int64     `json:"seed"` // Seed efetiva do sorteio (a derivada do relógio, se Config.RandomSeed era 0)
+	Start  time.Time `json:"start"`
+	Config Config    `json:"config"`
+}
+
+type recordedMove struct {
+	PlayerID  string `json:"playerId"`
+	Direction string `json:"direction"`
+}
+
+// recordEntry é um acontecimento gravado. Só os campos que fazem sentido para o Type são preenchidos.
+type recordEntry struct {
+	Type     string         `json:"type"`
+	Tick     int            `json:"tick"` // Ticks processados até aqui (para recordTick, o número do próprio tick)
+	At       time.Duration  `json:"at"`   // Tempo desde o início da gravação
+	PlayerID string         `json:"playerId,omitempty"`
+	Name     string         `json:"name,omitempty"`
+	Team     int            `json:"team,omitempty"`
+	Bot      bool           `json:"bot,omitempty"`
+	Moves    []recordedMove `json:"moves,omitempty"` // Intenções consumidas no tick, em ordem de ID
+	State    *SavedState    `json:"state,omitempty"` // Estado aplicado por recordRestore
+}
+
+// Recorder grava uma sala em JSON Lines. Cada sala precisa do seu; a escrita é bufferizada e descarregada a cada
+// fim de partida e em Close, para não fazer uma chamada ao sistema por tick.
+type Recorder struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	c     io.Closer
+	enc   *json.Encoder
+	began time.Time // Início da gravação, referência dos recordEntry.At
+	err   error     // Primeiro erro de escrita; depois dele a gravação para
+}
+
+// NewRecorder cria um Recorder que escreve em w, a ser passado em Config.Recorder. w é fechado por Close.
+func NewRecorder(w io.WriteCloser) *Recorder {
+	buf := bufio.NewWriter(w)
+	return &Recorder{w: buf, c: w, enc: json.NewEncoder(buf)}
+}
+
+// start grava o cabeçalho; é chamado por NewGameState
+func (r *Recorder) start(roomID string, seed int64, cfg Config, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.began = now
+	cfg.RandomSeed = seed
+	r.writeLocked(recordHeader{RoomID: roomID, Seed: seed, Start: now, Config: cfg})
+}
+
+// writeLocked codifica uma linha, guardando o primeiro erro. Quem chama deve segurar r.mu.
+func (r *Recorder) writeLocked(v any) {
+	if r.err != nil {
+		return
+	}
+	if r.err = r.enc.Encode(v); r.err != nil {
+		slog.Error("Erro ao gravar a partida, gravação interrompida", "err", r.err)
+	}
+}
+
+// Flush descarrega o que está no buffer
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+// Close descarrega o buffer e fecha o destino da gravação
+func (r *Recorder) Close() error {
+	err := r.Flush()
+	if closeErr := r.c.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// record grava um acontecimento da sala, se a gravação estiver ligada. Quem chama deve segurar ao menos
+// playersMu, e a entrada deve ser gravada sob o mesmo lock em que o acontecimento é aplicado, para que a ordem
+// da gravação seja a ordem em que o estado mudou.
+func (gs *GameState) record(e recordEntry) {
+	if gs.recorder == nil {
+		return
+	}
+	now := gs.now()
+	r := gs.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.Tick = gs.ticks
+	e.At = now.Sub(r.began)
+	r.writeLocked(e)
+}
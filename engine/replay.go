@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Replay recria uma sala a partir de uma gravação feita com Config.Recorder, reaplicando cada acontecimento com o
+// relógio da sala parado no instante gravado. events recebe os mesmos eventos da sala original (coletas, fins de
+// jogo...), com os horários da gravação. Retorna o estado da sala ao fim da gravação; se ela terminar cortada
+// (o servidor caiu no meio de uma linha), retorna também o estado até a última entrada completa.
+func Replay(r io.Reader, events EventSink) (*GameState, error) {
+	dec := json.NewDecoder(r)
+	var header recordHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("cabeçalho da gravação inválido: %w", err)
+	}
+
+	cfg := header.Config
+	cfg.RandomSeed = header.Seed
+	cfg.Events = events
+	now := header.Start
+	gs := NewGameState(header.RoomID, cfg)
+	gs.clock = func() time.Time { return now }
+	gs.replaying = true
+
+	for {
+		var e recordEntry
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return gs, nil
+		}
+		if err != nil {
+			return gs, fmt.Errorf("entrada da gravação inválida: %w", err)
+		}
+		now = header.Start.Add(e.At)
+		if err := gs.replayEntry(e); err != nil {
+			return gs, fmt.Errorf("tick %d: %w", e.Tick, err)
+		}
+	}
+}
+
+// replayEntry aplica uma entrada da gravação pelo mesmo caminho usado na sala original
+func (gs *GameState) replayEntry(e recordEntry) error {
+	switch e.Type {
+	case recordJoin:
+		if _, _, err := gs.addPlayer(e.PlayerID, e.Name, e.Team, e.Bot); err != nil {
+			return err
+		}
+	case recordName:
+		gs.SetPlayerName(e.PlayerID, e.Name)
+	case recordDisconnect:
+		gs.playersMu.Lock()
+		if player, ok := gs.Players[e.PlayerID]; ok && player.IsActive {
+			gs.disconnectLocked(player)
+		}
+		gs.playersMu.Unlock()
+	case recordReconnect:
+		gs.ReconnectPlayer(e.PlayerID) // Gravada só quando aceita, então não falha aqui
+	case recordRemove:
+		gs.RemovePlayer(e.PlayerID)
+	case recordExpire:
+		gs.playersMu.Lock()
+		if player, ok := gs.Players[e.PlayerID]; ok && !player.IsActive {
+			gs.expirePlayerLocked(player)
+		}
+		gs.playersMu.Unlock()
+	case recordReset:
+		gs.InitializeItems()
+	case recordRespawn:
+		gs.RespawnItem()
+	case recordGolden:
+		gs.SpawnGolden()
+	case recordRestore:
+		if e.State == nil {
+			return errors.New("restauração gravada sem estado")
+		}
+		gs.lockAll()
+		gs.restoreLocked(*e.State)
+		gs.unlockAll()
+	case recordTick:
+		gs.lockAll()
+		gs.ticks = e.Tick - 1 // Ticks sem movimentos não são gravados; ProcessTick volta a contar este
+		for _, move := range e.Moves {
+			if player, ok := gs.Players[move.PlayerID]; ok {
+				player.intents = append(player.intents, move.Direction) // Na ordem gravada, que é a de aplicação
+			}
+		}
+		gs.unlockAll()
+		gs.ProcessTick()
+	default:
+		return fmt.Errorf("tipo de entrada desconhecido na gravação: %q", e.Type)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// eventLog guarda os eventos publicados pela sala, na ordem
+type eventLog []GameEvent
+
+func (l *eventLog) Publish(e GameEvent) { *l = append(*l, e) }
+
+// withoutTimes copia os eventos sem horário nem duração, que dependem do relógio e não do jogo
+func (l eventLog) withoutTimes() []GameEvent {
+	events := make([]GameEvent, len(l))
+	for i, e := range l {
+		e.Time, e.Duration = time.Time{}, 0
+		events[i] = e
+	}
+	return events
+}
+
+// recordingBuffer é o destino da gravação no teste; Close não faz nada
+type recordingBuffer struct{ bytes.Buffer }
+
+func (*recordingBuffer) Close() error { return nil }
+
+func TestReplayReproducesGame(t *testing.T) {
+	var out recordingBuffer
+	rec := NewRecorder(&out)
+	var original eventLog
+	// O diamante dourado some logo depois de aparecer, para vários serem sorteados ao longo das partidas
+	gs := newTestGame(t, Config{BoardWidth: 8, BoardHeight: 6, NumItems: 5, RandomSeed: 3, ReconnectGrace: time.Minute, Events: &original, Recorder: rec,
+		TickDelay: 100 * time.Millisecond, GoldenChance: 0.5, GoldenLifetime: time.Microsecond, GoldenValue: 20})
+
+	ids := []string{"a", "b", "c"}
+	chans := make(map[string]chan []byte)
+	for _, id := range ids {
+		_, ch, err := gs.AddPlayer(id, id, 0)
+		if err != nil {
+			t.Fatalf("AddPlayer(%q): %v", id, err)
+		}
+		chans[id] = ch
+	}
+	gs.InitializeItems()
+
+	directions := []string{"up", "down", "left", "right", "up_left", "up_right", "down_left", "down_right"}
+	moves := rand.New(rand.NewSource(1))
+	games := 0
+	for tick := 0; tick < 2000 && games < 2; tick++ {
+		if tick == 10 { // Uma queda e uma reconexão no meio da partida também entram na gravação
+			gs.DisconnectPlayer("b", chans["b"])
+			_, chans["b"], _ = gs.ReconnectPlayer("b")
+		}
+		for _, id := range ids {
+			gs.QueueMove(id, directions[moves.Intn(len(directions))])
+		}
+		gs.ProcessTick()
+		if gs.GameOver {
+			games++
+			gs.ResetIfOver()
+		}
+	}
+	if games < 2 {
+		t.Fatalf("só %d partidas terminaram", games)
+	}
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(`"type":"golden"`)) {
+		t.Fatal("nenhum diamante dourado apareceu na partida original")
+	}
+
+	var replayed eventLog
+	replay, err := Replay(bytes.NewReader(out.Bytes()), &replayed)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+
+	want, got := original.withoutTimes(), replayed.withoutTimes()
+	collected, winners := 0, 0
+	for _, e := range want {
+		switch e.Type {
+		case EventItemCollected:
+			collected++
+		case EventGameOver:
+			winners++
+		}
+	}
+	if collected == 0 || winners != 2 {
+		t.Fatalf("a partida original teve %d coletas e %d fins de jogo", collected, winners)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("o replay publicou eventos diferentes:\n%+v\ndeveria ser:\n%+v", got, want)
+	}
+	for _, id := range ids {
+		if p, q := gs.Players[id], replay.Players[id]; q == nil || p.Pos != q.Pos || p.Score != q.Score {
+			t.Errorf("jogador %q: original %+v, replay %+v", id, p, q)
+		}
+	}
+}
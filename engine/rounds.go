@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"log/slog"
+	"sort"
+)
+
+// Modo de rodadas (Config.Rounds): a sala joga séries de partidas, e cada vitória numa partida vale uma rodada
+// para o(s) vencedor(es), inclusive em caso de empate. Ao fim da última rodada, quem venceu mais rodadas é o
+// campeão da série; empates são desfeitos pela soma dos pontos de todas as rodadas e, se persistirem, o título é
+// dividido. Rodadas vencidas e pontos acumulados sobrevivem aos resets entre rodadas, mas não a uma saída da sala.
+
+// roundsOn informa se a sala joga séries de rodadas
+func (gs *GameState) roundsOn() bool {
+	return gs.rounds > 1
+}
+
+// startRoundLocked avança para a próxima rodada da série. Se a série acabou, ou se a rodada atual foi
+// interrompida por um reset antes do fim, começa uma série nova, zerando o que foi acumulado. Chamado por
+// initializeItemsLocked antes de a partida anterior ser apagada; quem chama deve segurar os dois mutexes.
+func (gs *GameState) startRoundLocked() {
+	if !gs.roundsOn() {
+		return
+	}
+	if gs.GameOver && gs.round > 0 && gs.round < gs.rounds {
+		gs.round++
+		return
+	}
+	gs.round = 1
+	gs.ChampionIDs = nil
+	for _, player := range gs.Players {
+		player.roundsWon = 0
+		player.totalScore = 0
+	}
+}
+
+// scoreRoundLocked credita a rodada que acabou de terminar: os pontos de cada jogador entram no total da série,
+// cada vencedor ganha uma rodada e, na última, os campeões são declarados. Quem chama deve segurar playersMu.
+func (gs *GameState) scoreRoundLocked(winners []string) {
+	if !gs.roundsOn() {
+		return
+	}
+	for _, player := range gs.Players {
+		player.totalScore += player.Score
+	}
+	for _, id := range winners {
+		if player, ok := gs.Players[id]; ok {
+			player.roundsWon++
+		}
+	}
+	if gs.round < gs.rounds {
+		return
+	}
+	gs.ChampionIDs = gs.championsLocked()
+	slog.Info("Série de rodadas encerrada", "room", gs.RoomID, "action", "series_over", "rounds", gs.rounds, "champions", gs.ChampionIDs)
+}
+
+// championsLocked retorna os jogadores ativos com mais rodadas vencidas, desempatando pela soma dos pontos da
+// série (mais de um se o empate persistir, nenhum se não houver jogadores ativos)
+func (gs *GameState) championsLocked() []string {
+	var champions []string
+	var best *Player
+	for _, p := range gs.Players {
+		if !p.IsActive {
+			continue
+		}
+		switch {
+		case best == nil || p.roundsWon > best.roundsWon || (p.roundsWon == best.roundsWon && p.totalScore > best.totalScore):
+			best = p
+			champions = []string{p.ID}
+		case p.roundsWon == best.roundsWon && p.totalScore == best.totalScore:
+			champions = append(champions, p.ID)
+		}
+	}
+	sort.Strings(champions) // Ordem estável, independente da iteração do mapa
+	return champions
+}
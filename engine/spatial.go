@@ -0,0 +1,81 @@
+package engine
+
+import "sort"
+
+const itemGridSize = 8 // Lado (em células) de cada balde do índice espacial de itens
+
+// itemGrid é um índice espacial dos itens: o tabuleiro dividido em baldes de itemGridSize x itemGridSize células,
+// cada um com os itens que estão nele. Uma busca por raio só olha os baldes que cruzam a área, em vez de todos
+// os itens. Fica sempre em sincronia com gs.Items (spawnItemLocked, coleta e reset) e, como ele, é protegido por
+// itemsMu.
+type itemGrid map[Point]map[Point]*Item
+
+func (g itemGrid) add(item *Item) {
+	bucket := Point{item.Pos.X / itemGridSize, item.Pos.Y / itemGridSize}
+	if g[bucket] == nil {
+		g[bucket] = make(map[Point]*Item)
+	}
+	g[bucket][item.Pos] = item
+}
+
+func (g itemGrid) remove(item *Item) {
+	bucket := Point{item.Pos.X / itemGridSize, item.Pos.Y / itemGridSize}
+	delete(g[bucket], item.Pos)
+	if len(g[bucket]) == 0 {
+		delete(g, bucket)
+	}
+}
+
+// ItemsWithin retorna os itens a até radius células de center (distância de Chebyshev, respeitando as bordas
+// ligadas no tabuleiro toroidal), do mais próximo ao mais distante (empates pelo ID)
+func (gs *GameState) ItemsWithin(center Point, radius int) []*Item {
+	gs.itemsMu.RLock()
+	defer gs.itemsMu.RUnlock()
+	return gs.itemsWithinLocked(center, radius)
+}
+
+// itemsWithinLocked é o corpo de ItemsWithin; quem chama deve segurar itemsMu (leitura basta)
+func (gs *GameState) itemsWithinLocked(center Point, radius int) []*Item {
+	columns := gs.gridLines(center.X, radius, gs.BoardWidth)
+	rows := gs.gridLines(center.Y, radius, gs.BoardHeight)
+
+	items := []*Item{}
+	for _, bx := range columns {
+		for _, by := range rows {
+			for _, item := range gs.itemGrid[Point{bx, by}] {
+				if gs.distance(item.Pos, center) <= radius {
+					items = append(items, item)
+				}
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		di, dj := gs.distance(items[i].Pos, center), gs.distance(items[j].Pos, center)
+		if di != dj {
+			return di < dj
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items
+}
+
+// gridLines lista, sem repetir, os índices de balde de uma coordenada ao longo de [c-radius, c+radius], dando a
+// volta no tabuleiro toroidal ou parando nas bordas do normal. size é a largura (ou altura) do tabuleiro.
+func (gs *GameState) gridLines(c, radius, size int) []int {
+	lo, hi := c-radius, c+radius
+	if !gs.wrap {
+		lo, hi = max(lo, 0), min(hi, size-1)
+	} else if hi-lo+1 >= size { // A área dá a volta no eixo inteiro
+		lo, hi = 0, size-1
+	}
+	lines := []int{}
+	last := -1
+	for v := lo; v <= hi; v++ {
+		b := ((v%size + size) % size) / itemGridSize
+		if b != last && (len(lines) == 0 || b != lines[0]) { // Com a volta, o primeiro balde pode reaparecer no fim
+			lines = append(lines, b)
+			last = b
+		}
+	}
+	return lines
+}
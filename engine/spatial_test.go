@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// bruteItemsWithin é a busca sem índice: percorre todos os itens e mede a distância de Chebyshev à mão
+func bruteItemsWithin(gs *GameState, center Point, radius int) []string {
+	axis := func(a, b, size int) int {
+		d := max(a-b, b-a)
+		if gs.wrap {
+			d = min(d, size-d)
+		}
+		return d
+	}
+	ids := []string{}
+	for _, item := range gs.Items {
+		if max(axis(item.Pos.X, center.X, gs.BoardWidth), axis(item.Pos.Y, center.Y, gs.BoardHeight)) <= radius {
+			ids = append(ids, item.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestItemsWithinMatchesBruteForce(t *testing.T) {
+	for _, wrap := range []bool{false, true} {
+		t.Run(fmt.Sprintf("wrap=%v", wrap), func(t *testing.T) {
+			// Dimensões que não são múltiplas de itemGridSize, para o último balde de cada eixo ficar incompleto
+			gs := newTestGame(t, Config{BoardWidth: 30, BoardHeight: 21, NumItems: 150, Wrap: wrap})
+			gs.InitializeItems()
+
+			for _, center := range []Point{{0, 0}, {29, 20}, {0, 20}, {15, 10}, {7, 8}, {8, 7}, {28, 1}} {
+				for radius := 0; radius <= 22; radius++ {
+					items := gs.ItemsWithin(center, radius)
+					got := make([]string, len(items))
+					for i, item := range items {
+						got[i] = item.ID
+						if i > 0 && gs.distance(items[i-1].Pos, center) > gs.distance(item.Pos, center) {
+							t.Errorf("centro %v, raio %d: %s fora da ordem de distância", center, radius, item.ID)
+						}
+					}
+					sort.Strings(got)
+					if want := bruteItemsWithin(gs, center, radius); !reflect.DeepEqual(got, want) {
+						t.Errorf("centro %v, raio %d: %v, deveria ser %v", center, radius, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGridLinesCoversEachBucketOnce(t *testing.T) {
+	for _, wrap := range []bool{false, true} {
+		gs := newTestGame(t, Config{BoardWidth: 30, BoardHeight: 30, Wrap: wrap})
+		for c := 0; c < 30; c++ {
+			for radius := 0; radius <= 31; radius++ {
+				want := make(map[int]bool) // Baldes das coordenadas a até radius de c, uma a uma
+				for v := c - radius; v <= c+radius; v++ {
+					switch {
+					case wrap:
+						want[((v%30+30)%30)/itemGridSize] = true
+					case v >= 0 && v < 30:
+						want[v/itemGridSize] = true
+					}
+				}
+				lines := gs.gridLines(c, radius, 30)
+				got := make(map[int]bool)
+				for _, b := range lines {
+					if got[b] {
+						t.Errorf("wrap=%v, c=%d, raio %d: balde %d repetido em %v", wrap, c, radius, b, lines)
+					}
+					got[b] = true
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("wrap=%v, c=%d, raio %d: baldes %v, deveriam ser %v", wrap, c, radius, lines, want)
+				}
+			}
+		}
+	}
+}
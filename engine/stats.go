@@ -0,0 +1,67 @@
+package engine
+
+import "sort"
+
+// PlayerStats é a pontuação de um jogador ativo, como aparece em Stats
+type PlayerStats struct {
+	ID         string `json:"id"`
+	Name       string `json:"name,omitempty"`
+	Score      int    `json:"score"`
+	Team       int    `json:"team,omitempty"`
+	Bot        bool   `json:"bot,omitempty"`
+	RoundsWon  int    `json:"roundsWon,omitempty"`  // Modo de rodadas: rodadas vencidas na série
+	TotalScore int    `json:"totalScore,omitempty"` // Modo de rodadas: pontos das rodadas já encerradas da série
+	PingMs     int    `json:"pingMs,omitempty"`     // Latência medida por ping/pong; omitida enquanto desconhecida
+}
+
+// RoomStats é um resumo somente leitura da sala, para placares externos
+type RoomStats struct {
+	Players        int           `json:"players"`
+	Spectators     int           `json:"spectators"`
+	Scores         []PlayerStats `json:"scores"` // Ordenado da maior para a menor pontuação
+	ItemsRemaining int           `json:"itemsRemaining"`
+	GameOver       bool          `json:"gameOver"`
+	WinnerIDs      []string      `json:"winnerIds,omitempty"`
+	TeamScores     map[int]int   `json:"teamScores,omitempty"` // Só no modo de equipes
+	WinningTeams   []int         `json:"winningTeams,omitempty"`
+}
+
+// Stats copia as contagens e pontuações da sala sob o lock, sem alterar o estado
+func (gs *GameState) Stats() RoomStats {
+	gs.rLockAll()
+	defer gs.rUnlockAll()
+
+	stats := RoomStats{
+		Spectators:     len(gs.Spectators),
+		ItemsRemaining: len(gs.Items),
+		GameOver:       gs.GameOver,
+		WinnerIDs:      append([]string(nil), gs.WinnerIDs...),
+		TeamScores:     gs.teamScoresLocked(),
+		WinningTeams:   append([]int(nil), gs.WinningTeams...),
+		Scores:         gs.scoresLocked(),
+	}
+	stats.Players = len(stats.Scores)
+	return stats
+}
+
+// scoresLocked lista a pontuação dos jogadores ativos, da maior para a menor (empates por ID).
+// Quem chama deve segurar playersMu.
+func (gs *GameState) scoresLocked() []PlayerStats {
+	return gs.appendScoresLocked([]PlayerStats{})
+}
+
+// appendScoresLocked é scoresLocked acrescentando a scores, para quem reaproveita a memória entre chamadas
+func (gs *GameState) appendScoresLocked(scores []PlayerStats) []PlayerStats {
+	for _, p := range gs.Players {
+		if p.IsActive {
+			scores = append(scores, PlayerStats{ID: p.ID, Name: p.Name, Score: p.Score, Team: p.Team, Bot: p.bot, RoundsWon: p.roundsWon, TotalScore: p.totalScore, PingMs: p.latencyMs()})
+		}
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].ID < scores[j].ID
+	})
+	return scores
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"log/slog"
+	"sort"
+)
+
+const MaxTeams = 4 // Quantidade máxima de equipes, uma para cada cor do cliente
+
+// assignTeamLocked escolhe a equipe de um jogador que está entrando: a pedida, se for válida, ou a que tem menos
+// jogadores ativos (empate fica com a de menor número), o que distribui as entradas em rodízio.
+// Retorna 0 fora do modo de equipes. Quem chama deve segurar playersMu.
+func (gs *GameState) assignTeamLocked(requested int) int {
+	if gs.teams == 0 {
+		return 0
+	}
+	if requested >= 1 && requested <= gs.teams {
+		return requested
+	}
+	members := make([]int, gs.teams+1)
+	for _, p := range gs.Players {
+		if p.IsActive {
+			members[p.Team]++
+		}
+	}
+	team := 1
+	for t := 2; t <= gs.teams; t++ {
+		if members[t] < members[team] {
+			team = t
+		}
+	}
+	return team
+}
+
+// teamScoresLocked soma a pontuação dos jogadores ativos de cada equipe (todas aparecem, mesmo zeradas).
+// Retorna nil fora do modo de equipes. Quem chama deve segurar playersMu.
+func (gs *GameState) teamScoresLocked() map[int]int {
+	if gs.teams == 0 {
+		return nil
+	}
+	scores := make(map[int]int, gs.teams)
+	for t := 1; t <= gs.teams; t++ {
+		scores[t] = 0
+	}
+	for _, p := range gs.Players {
+		if p.IsActive {
+			scores[p.Team] += p.Score
+		}
+	}
+	return scores
+}
+
+// finishTeamsLocked encerra a partida dando a vitória às equipes informadas: os vencedores são os jogadores
+// ativos dessas equipes. Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) finishTeamsLocked(teams []int, teamScore int) {
+	var winners []string
+	for _, t := range teams {
+		for _, p := range gs.Players {
+			if p.IsActive && p.Team == t {
+				winners = append(winners, p.ID)
+			}
+		}
+	}
+	sort.Strings(winners)
+	gs.WinningTeams = teams
+	slog.Info("Equipe(s) vencedora(s)", "room", gs.RoomID, "teams", teams, "score", teamScore)
+	gs.finishGameLocked(winners, teamScore)
+}
+
+// endTeamsLocked encerra a partida no modo de equipes, declarando vencedora(s) a(s) equipe(s) de maior soma.
+// Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) endTeamsLocked() {
+	teams, best := gs.leadingTeamsLocked()
+	gs.finishTeamsLocked(teams, best)
+}
+
+// leadingTeamsLocked retorna a(s) equipe(s) de maior soma, em ordem, e a soma delas. Quem chama deve segurar
+// playersMu.
+func (gs *GameState) leadingTeamsLocked() ([]int, int) {
+	best := 0
+	var teams []int
+	for t, score := range gs.teamScoresLocked() {
+		if teams == nil || score > best { // Sem pontuação mínima, a melhor soma pode ser negativa
+			best = score
+			teams = []int{t}
+		} else if score == best {
+			teams = append(teams, t)
+		}
+	}
+	sort.Ints(teams)
+	return teams, best
+}
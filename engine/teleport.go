@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"log/slog"
+	"math/rand"
+)
+
+// Teleport é um par de portais: entrar em A leva o jogador para B, e entrar em B leva para A
+type Teleport struct {
+	A Point `json:"a"`
+	B Point `json:"b"`
+}
+
+// generateTeleports sorteia 'pairs' pares de portais fora das células de blocked (paredes e gelo), com o mesmo
+// gerador do layout de paredes. Os portais não bloqueiam nada, então não mexem na conectividade do tabuleiro, mas
+// os dois portais de um par nunca são vizinhos: o jogador que entrasse num deles voltaria sempre para o outro, sem
+// conseguir passar por ali.
+func generateTeleports(width, height, pairs int, rng *rand.Rand, blocked map[Point]bool) []Teleport {
+	pads := make([]Point, 0, 2*pairs)
+	for _, idx := range rng.Perm(width * height) {
+		if len(pads) == 2*pairs {
+			break
+		}
+		p := Point{X: idx % width, Y: idx / width}
+		if blocked[p] {
+			continue
+		}
+		if len(pads)%2 == 1 && adjacent(pads[len(pads)-1], p) {
+			continue
+		}
+		pads = append(pads, p)
+	}
+	teleports := make([]Teleport, 0, len(pads)/2)
+	for i := 0; i+1 < len(pads); i += 2 {
+		teleports = append(teleports, Teleport{A: pads[i], B: pads[i+1]})
+	}
+	return teleports
+}
+
+// adjacent informa se a e b são células vizinhas, inclusive na diagonal
+func adjacent(a, b Point) bool {
+	return max(a.X-b.X, b.X-a.X) <= 1 && max(a.Y-b.Y, b.Y-a.Y) <= 1
+}
+
+// teleportLocked leva o jogador que acabou de entrar num portal para o outro portal do par, onde ele coleta o item
+// que houver. Um jogador é teleportado no máximo uma vez por tick: o portal de saída não o manda de volta, nem um
+// passo seguinte do mesmo tick (velocidade ou MovesPerTick) num portal. Com a saída ocupada por outro jogador ou
+// por um rastro, ele fica no portal de entrada. Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) teleportLocked(player *Player) {
+	dest, ok := gs.teleportTo[player.Pos]
+	if !ok || player.teleportedTick == gs.ticks {
+		return
+	}
+	if gs.playerAt(dest) != nil || gs.trailAt(dest) != nil {
+		return
+	}
+
+	from := player.Pos
+	gs.moveHeadLocked(player, dest)
+	player.teleportedTick = gs.ticks
+	slog.Debug("Jogador teleportado", "room", gs.RoomID, "player_id", player.ID, "from_x", from.X, "from_y", from.Y, "x", dest.X, "y", dest.Y)
+	gs.collectItemLocked(player, dest)
+}
@@ -0,0 +1,50 @@
+package engine
+
+import "log/slog"
+
+// Modo rastro (estilo Tron/snake): cada item coletado acrescenta um segmento ao Body do jogador, que segue
+// atrás dele. Entrar em qualquer segmento, de outro jogador ou do próprio, elimina quem entrou.
+
+// trailAt retorna o jogador ativo cujo rastro passa pela posição, ou nil. Quem chama deve segurar playersMu.
+func (gs *GameState) trailAt(pos Point) *Player {
+	return gs.trailCells[pos]
+}
+
+// eliminateLocked tira o jogador da partida atual: ele fica parado onde está, perde o rastro e não pode mais
+// se mover até a próxima partida, mas mantém os pontos. Se todos forem eliminados, a partida termina.
+// Quem chama deve segurar os dois mutexes para escrita.
+func (gs *GameState) eliminateLocked(player *Player) {
+	body := player.Body
+	player.Body = nil
+	player.Out = true
+	for _, segment := range body {
+		delete(gs.trailCells, segment)
+		gs.refreshCellLocked(segment)
+	}
+	slog.Info("Jogador bateu num rastro e foi eliminado", "room", gs.RoomID, "player_id", player.ID, "action", "eliminated")
+
+	for _, p := range gs.Players {
+		if p.IsActive && !p.Out {
+			return
+		}
+	}
+	slog.Info("Todos os jogadores foram eliminados", "room", gs.RoomID)
+	gs.endGameLocked()
+}
+
+// dropTrail apaga o rastro de um jogador que saiu da sala ou desconectou, liberando as células.
+// Quem chama deve segurar playersMu; itemsMu é travado aqui, respeitando a ordem dos locks.
+func (gs *GameState) dropTrail(player *Player) {
+	if len(player.Body) == 0 {
+		return
+	}
+	gs.itemsMu.Lock()
+	defer gs.itemsMu.Unlock()
+
+	body := player.Body
+	player.Body = nil
+	for _, segment := range body {
+		delete(gs.trailCells, segment)
+		gs.refreshCellLocked(segment)
+	}
+}
@@ -0,0 +1,52 @@
+package engine
+
+import "fmt"
+
+// Modo de visão limitada (Config.ViewRadius): cada jogador recebe só os jogadores e itens a até ViewRadius células
+// de distância. A distância é a de Chebyshev (o maior entre |dx| e |dy|), a mesma contagem de passos de quem anda
+// também nas diagonais, então a área visível é um quadrado centrado no jogador. Paredes, placar e o restante do
+// estado continuam completos; espectadores, que não têm posição, recebem tudo.
+
+// distance é a distância de Chebyshev entre duas células, considerando as bordas ligadas no tabuleiro toroidal
+func (gs *GameState) distance(a, b Point) int {
+	dx, dy := abs(a.X-b.X), abs(a.Y-b.Y)
+	if gs.wrap {
+		dx, dy = min(dx, gs.BoardWidth-dx), min(dy, gs.BoardHeight-dy)
+	}
+	return max(dx, dy)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// visibleFrom retorna uma cópia do snapshot só com os jogadores a até gs.viewRadius de center e com os itens
+// informados (a busca no índice espacial, feita por quem chama sob itemsMu). Um jogador aparece inteiro se a
+// cabeça ou qualquer segmento do rastro estiver na área, para que ninguém bata num rastro invisível. Os mapas são
+// novos; o restante é compartilhado com o snapshot original, que não é alterado.
+func (gs *GameState) visibleFrom(snapshot stateSnapshot, center Point, items []*Item) stateSnapshot {
+	players := make(map[string]*playerView)
+	for id, p := range snapshot.Players {
+		if gs.distance(p.Pos, center) <= gs.viewRadius || gs.bodyNear(p.Body, center) {
+			players[id] = p
+		}
+	}
+	snapshot.Players = players
+	snapshot.Items = make(map[string]*Item, len(items))
+	for _, item := range items {
+		snapshot.Items[fmt.Sprintf("%d,%d", item.Pos.X, item.Pos.Y)] = item
+	}
+	return snapshot
+}
+
+func (gs *GameState) bodyNear(body []Point, center Point) bool {
+	for _, segment := range body {
+		if gs.distance(segment, center) <= gs.viewRadius {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MinTickLag = 5 * time.Second // Atraso mínimo tolerado antes de considerar um gameLoop travado
+)
+
+var shuttingDown atomic.Bool // Marcado no início do encerramento gracioso
+
+// maxTickLag é quanto tempo um gameLoop pode ficar sem completar um tick antes de ser considerado travado
+func maxTickLag() time.Duration {
+	return max(MinTickLag, 3*max(config.TickDelay, config.IdleTickDelay))
+}
+
+// healthHandler atende /healthz e /readyz. Responde 200 enquanto o servidor está no ar e os gameLoops de todas
+// as salas continuam completando ticks; um loop travado (por exemplo, num deadlock) aparece como 503 em vez de
+// parecer saudável. Durante o encerramento responde 503, para que o orquestrador pare de mandar tráfego antes de
+// as conexões serem fechadas.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Load() {
+		http.Error(w, "encerrando", http.StatusServiceUnavailable)
+		return
+	}
+	if stalled := rooms.stalledRooms(maxTickLag()); len(stalled) > 0 {
+		http.Error(w, fmt.Sprintf("gameLoop parado nas salas: %s", strings.Join(stalled, ", ")), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
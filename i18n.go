@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultLang = "pt" // Idioma das mensagens do servidor quando o cliente não pede um conhecido
+)
+
+// Chaves de catálogo que não são códigos de erro
+const (
+	msgMalformedMsgpack = "malformed_msgpack" // Variante de ErrCodeMalformedJSON para mensagens binárias
+	msgBanned           = "banned"            // Texto do ErrCodeKicked para quem teve o IP bloqueado
+
+	// Textos do aviso de fim de jogo, montado pelo cliente: vão nas boas-vindas (clientTexts), com %s no lugar
+	// dos nomes ou números
+	msgWinner     = "winner"
+	msgTie        = "tie"
+	msgNoWinner   = "no_winner"
+	msgTeamWinner = "team_winner"
+	msgTeamTie    = "team_tie"
+	msgChampion   = "champion"
+	msgChampions  = "champions"
+	msgNextRound  = "next_round"
+	msgNextSeries = "next_series"
+	msgResetHint  = "reset_hint"
+)
+
+// clientTextKeys são as chaves enviadas ao cliente por clientTexts
+var clientTextKeys = []string{msgWinner, msgTie, msgNoWinner, msgTeamWinner, msgTeamTie, msgChampion, msgChampions, msgNextRound, msgNextSeries, msgResetHint}
+
+// catalogs traz, por idioma, o texto de cada mensagem do servidor, indexado pelo código de erro (ou por uma das
+// chaves msg*). Os textos com verbos de formatação recebem os argumentos de translate. Um idioma não precisa ter
+// todas as chaves: o que falta sai no DefaultLang.
+var catalogs = map[string]map[string]string{
+	"pt": {
+		ErrCodeMalformedJSON:    "mensagem não é um JSON válido",
+		msgMalformedMsgpack:     "mensagem não é um MessagePack válido",
+		ErrCodeUnknownAction:    "ação desconhecida: %q",
+		ErrCodeMessageTooLarge:  "mensagem maior que o limite de %d bytes",
+		ErrCodeInvalidDirection: "direção inválida",
+		ErrCodeGameOver:         "a partida já terminou",
+		ErrCodeRateLimited:      "movimentos rápidos demais",
+		ErrCodeSpectator:        "espectadores não podem agir na partida",
+		ErrCodeEliminated:       "jogador eliminado nesta partida",
+		ErrCodeRoomFull:         "a sala está cheia; tente outra sala ou entre como espectador",
+		ErrCodeBoardFull:        "não há célula livre no tabuleiro; tente mais tarde ou entre como espectador",
+		ErrCodeIdle:             "removido da sala por inatividade",
+		ErrCodeKicked:           "expulso da sala pela administração",
+		msgBanned:               "seu IP foi bloqueado pela administração",
+		ErrCodeChatEmpty:        "mensagem de chat vazia",
+		ErrCodeChatRateLimited:  "mensagens de chat rápidas demais",
+		msgWinner:               "FIM DE JOGO! Vencedor: %s",
+		msgTie:                  "FIM DE JOGO! Empate entre: %s",
+		msgNoWinner:             "FIM DE JOGO! Nenhum vencedor.",
+		msgTeamWinner:           "FIM DE JOGO! Vitória da equipe %s",
+		msgTeamTie:              "FIM DE JOGO! Empate entre as equipes %s",
+		msgChampion:             "Campeão da série: %s!",
+		msgChampions:            "Campeões da série: %s!",
+		msgNextRound:            "Próxima rodada em %s...",
+		msgNextSeries:           "Nova série em %s...",
+		msgResetHint:            "Clique em Resetar Jogo para jogar de novo.",
+	},
+	"en": {
+		ErrCodeMalformedJSON:    "message is not valid JSON",
+		msgMalformedMsgpack:     "message is not valid MessagePack",
+		ErrCodeUnknownAction:    "unknown action: %q",
+		ErrCodeMessageTooLarge:  "message larger than the %d byte limit",
+		ErrCodeInvalidDirection: "invalid direction",
+		ErrCodeGameOver:         "the game is already over",
+		ErrCodeRateLimited:      "moving too fast",
+		ErrCodeSpectator:        "spectators cannot act in the game",
+		ErrCodeEliminated:       "eliminated in this game",
+		ErrCodeRoomFull:         "the room is full; try another room or join as a spectator",
+		ErrCodeBoardFull:        "there is no free cell on the board; try again later or join as a spectator",
+		ErrCodeIdle:             "removed from the room for inactivity",
+		ErrCodeKicked:           "kicked from the room by the administrators",
+		msgBanned:               "your IP was blocked by the administrators",
+		ErrCodeChatEmpty:        "empty chat message",
+		ErrCodeChatRateLimited:  "chat messages too fast",
+		msgWinner:               "GAME OVER! Winner: %s",
+		msgTie:                  "GAME OVER! Tie between: %s",
+		msgNoWinner:             "GAME OVER! No winner.",
+		msgTeamWinner:           "GAME OVER! Team %s wins",
+		msgTeamTie:              "GAME OVER! Tie between teams %s",
+		msgChampion:             "Series champion: %s!",
+		msgChampions:            "Series champions: %s!",
+		msgNextRound:            "Next round in %s...",
+		msgNextSeries:           "New series in %s...",
+		msgResetHint:            "Click Reset Game to play again.",
+	},
+	"es": {
+		ErrCodeMalformedJSON:    "el mensaje no es un JSON válido",
+		msgMalformedMsgpack:     "el mensaje no es un MessagePack válido",
+		ErrCodeUnknownAction:    "acción desconocida: %q",
+		ErrCodeMessageTooLarge:  "mensaje mayor que el límite de %d bytes",
+		ErrCodeInvalidDirection: "dirección inválida",
+		ErrCodeGameOver:         "la partida ya terminó",
+		ErrCodeRateLimited:      "movimientos demasiado rápidos",
+		ErrCodeSpectator:        "los espectadores no pueden actuar en la partida",
+		ErrCodeEliminated:       "jugador eliminado en esta partida",
+		ErrCodeRoomFull:         "la sala está llena; prueba otra sala o entra como espectador",
+		ErrCodeBoardFull:        "no hay celda libre en el tablero; prueba más tarde o entra como espectador",
+		ErrCodeIdle:             "expulsado de la sala por inactividad",
+		ErrCodeKicked:           "expulsado de la sala por la administración",
+		msgBanned:               "tu IP fue bloqueada por la administración",
+		ErrCodeChatEmpty:        "mensaje de chat vacío",
+		ErrCodeChatRateLimited:  "mensajes de chat demasiado rápidos",
+		msgWinner:               "¡FIN DEL JUEGO! Ganador: %s",
+		msgTie:                  "¡FIN DEL JUEGO! Empate entre: %s",
+		msgNoWinner:             "¡FIN DEL JUEGO! Sin ganador.",
+		msgTeamWinner:           "¡FIN DEL JUEGO! Victoria del equipo %s",
+		msgTeamTie:              "¡FIN DEL JUEGO! Empate entre los equipos %s",
+		msgChampion:             "¡Campeón de la serie: %s!",
+		msgChampions:            "¡Campeones de la serie: %s!",
+		msgNextRound:            "Próxima ronda en %s...",
+		msgNextSeries:           "Nueva serie en %s...",
+		msgResetHint:            "Haz clic en Reiniciar Juego para jugar de nuevo.",
+	},
+}
+
+// translate retorna o texto da chave no idioma lang, caindo para o DefaultLang quando o idioma não a tem, e para
+// a própria chave quando nenhum catálogo a conhece
+func translate(lang string, key string, args ...any) string {
+	text, ok := catalogs[lang][key]
+	if !ok {
+		if text, ok = catalogs[DefaultLang][key]; !ok {
+			return key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// clientTexts retorna, no idioma lang, os textos que o cliente usa no aviso de fim de jogo, sem formatar
+func clientTexts(lang string) map[string]string {
+	texts := make(map[string]string, len(clientTextKeys))
+	for _, key := range clientTextKeys {
+		texts[key] = translate(lang, key)
+	}
+	return texts
+}
+
+// negotiateLang escolhe o idioma das mensagens de uma conexão: ?lang= se houver catálogo para ele, senão o
+// primeiro idioma conhecido de Accept-Language (na ordem do cabeçalho, sem considerar os pesos q=, que os
+// navegadores já mandam em ordem decrescente), senão o DefaultLang. Só o idioma principal conta: "en-US" vale "en".
+func negotiateLang(r *http.Request) string {
+	candidates := []string{r.URL.Query().Get("lang")}
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		candidates = append(candidates, tag)
+	}
+	for _, tag := range candidates {
+		primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+		primary = strings.ToLower(primary)
+		if _, ok := catalogs[primary]; ok {
+			return primary
+		}
+	}
+	return DefaultLang
+}
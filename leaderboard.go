@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"game/engine"
+)
+
+const (
+	DefaultLeaderboardFile  = "leaderboard.json"
+	DefaultLeaderboardLimit = 10  // Resultados devolvidos por /leaderboard sem ?limit=
+	MaxLeaderboardLimit     = 100 // Maior ?limit= aceito por /leaderboard
+	LeaderboardBuffer       = 64  // Fins de jogo aguardando gravação antes de começar a descartar
+)
+
+var leaderboard *Leaderboard // Inicializado em main() a partir de LEADERBOARD_FILE
+
+// leaderboardRecord é o resultado de uma partida guardado no histórico
+type leaderboardRecord struct {
+	Time            time.Time `json:"time"`
+	RoomID          string    `json:"roomId"`
+	WinnerIDs       []string  `json:"winnerIds"`
+	WinnerNames     []string  `json:"winnerNames"`
+	Score           int       `json:"score"`   // Maior pontuação entre os vencedores
+	Players         int       `json:"players"` // Jogadores ativos no fim da partida
+	DurationSeconds float64   `json:"durationSeconds"`
+}
+
+// Leaderboard guarda o histórico de partidas e os ratings dos jogadores em memória e, se tiver um arquivo,
+// também em disco. Só a goroutine iniciada por start grava; /leaderboard e /stats leem a cópia em memória.
+type Leaderboard struct {
+	path    string  // Arquivo JSON do histórico (vazio mantém só em memória)
+	ratingK float64 // Fator K dos ratings
+	mu      sync.RWMutex
+	records []leaderboardRecord
+	ratings map[string]*playerRating // Por apelido
+}
+
+// leaderboardFile é o conteúdo do arquivo do histórico. Arquivos antigos, só com a lista de partidas, também
+// são aceitos na leitura.
+type leaderboardFile struct {
+	Records []leaderboardRecord `json:"records"`
+	Ratings []playerRating      `json:"ratings"`
+}
+
+// loadLeaderboard lê o histórico salvo em path. Um arquivo inexistente começa um histórico vazio.
+func loadLeaderboard(path string, ratingK float64) (*Leaderboard, error) {
+	lb := &Leaderboard{path: path, ratingK: ratingK, ratings: make(map[string]*playerRating)}
+	if path == "" {
+		return lb, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lb, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file leaderboardFile
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) { // Formato antigo, sem ratings
+		err = json.Unmarshal(data, &file.Records)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("histórico inválido em %s: %w", path, err)
+	}
+	lb.records = file.Records
+	for _, r := range file.Ratings {
+		lb.ratings[r.Name] = &r
+	}
+	return lb, nil
+}
+
+// start inicia a goroutine que registra os fins de jogo e retorna o EventSink que a alimenta. A gravação
+// em disco acontece nessa goroutine, então nunca atrasa o gameLoop.
+func (lb *Leaderboard) start() engine.EventSink {
+	queue := engine.NewChannelSink(LeaderboardBuffer, func(e engine.GameEvent) {
+		slog.Warn("Fila do histórico cheia, fim de jogo descartado", "room", e.RoomID)
+	})
+	go func() {
+		for e := range queue.C {
+			lb.add(e)
+		}
+	}()
+	return gameOverSink{queue: queue}
+}
+
+// add registra um fim de jogo, atualiza os ratings e regrava o arquivo. Partidas sem vencedor (sala vazia)
+// são ignoradas.
+func (lb *Leaderboard) add(e engine.GameEvent) {
+	if len(e.WinnerIDs) == 0 {
+		return
+	}
+	record := leaderboardRecord{
+		Time:            e.Time,
+		RoomID:          e.RoomID,
+		WinnerIDs:       e.WinnerIDs,
+		Players:         len(e.Scores),
+		DurationSeconds: e.Duration.Seconds(),
+	}
+	for _, s := range e.Scores {
+		for _, id := range e.WinnerIDs {
+			if s.ID == id {
+				record.WinnerNames = append(record.WinnerNames, s.Name)
+				record.Score = max(record.Score, s.Score)
+			}
+		}
+	}
+
+	lb.mu.Lock()
+	lb.records = append(lb.records, record)
+	rateGame(lb.ratings, e.Scores, lb.ratingK)
+	file := leaderboardFile{Records: make([]leaderboardRecord, len(lb.records)), Ratings: lb.ratingsLocked()}
+	copy(file.Records, lb.records)
+	lb.mu.Unlock()
+
+	if lb.path == "" {
+		return
+	}
+	if err := writeFileAtomic(lb.path, file); err != nil {
+		slog.Error("Erro ao salvar o histórico de partidas", "file", lb.path, "err", err)
+	}
+}
+
+// top retorna as n partidas de maior pontuação (empates ficam com a mais antiga primeiro)
+func (lb *Leaderboard) top(n int) []leaderboardRecord {
+	lb.mu.RLock()
+	records := make([]leaderboardRecord, len(lb.records))
+	copy(records, lb.records)
+	lb.mu.RUnlock()
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Score > records[j].Score
+	})
+	if len(records) > n {
+		records = records[:n]
+	}
+	return records
+}
+
+// ratingsLocked copia os ratings, do maior para o menor (empates por apelido). Quem chama deve segurar mu.
+func (lb *Leaderboard) ratingsLocked() []playerRating {
+	ratings := make([]playerRating, 0, len(lb.ratings))
+	for _, r := range lb.ratings {
+		ratings = append(ratings, *r)
+	}
+	sort.Slice(ratings, func(i, j int) bool {
+		if ratings[i].Rating != ratings[j].Rating {
+			return ratings[i].Rating > ratings[j].Rating
+		}
+		return ratings[i].Name < ratings[j].Name
+	})
+	return ratings
+}
+
+// topRatings retorna os n maiores ratings
+func (lb *Leaderboard) topRatings(n int) []playerRating {
+	lb.mu.RLock()
+	ratings := lb.ratingsLocked()
+	lb.mu.RUnlock()
+
+	if len(ratings) > n {
+		ratings = ratings[:n]
+	}
+	return ratings
+}
+
+// rating retorna o rating atual de um apelido, ou BaseRating se ele nunca jogou uma partida avaliada
+func (lb *Leaderboard) rating(name string) float64 {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	if r, ok := lb.ratings[name]; ok {
+		return r.Rating
+	}
+	return BaseRating
+}
+
+// writeFileAtomic grava v como JSON num arquivo temporário no mesmo diretório e o renomeia por cima de path,
+// para que uma queda no meio da escrita nunca deixe o histórico pela metade
+func writeFileAtomic(path string, v any) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Não faz nada depois do Rename
+
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// leaderboardHandler retorna em JSON as melhores partidas de todos os tempos e os maiores ratings (?limit=N,
+// padrão 10, vale para as duas listas)
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := DefaultLeaderboardLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > MaxLeaderboardLimit {
+			http.Error(w, fmt.Sprintf("limit deve ser um inteiro de 1 a %d", MaxLeaderboardLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	response := struct {
+		Entries []leaderboardRecord `json:"entries"`
+		Ratings []playerRating      `json:"ratings"`
+	}{
+		Entries: leaderboard.top(limit),
+		Ratings: leaderboard.topRatings(limit),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Erro ao enviar o histórico", "err", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"game/engine"
+)
+
+// collectAll leva o jogador id a cada item do tabuleiro, um tick por passo, até a partida terminar. Anda primeiro
+// na horizontal e, bloqueado, na vertical; num tabuleiro pequeno com poucos jogadores parados, isso basta.
+func collectAll(t *testing.T, gs *engine.GameState, id string) {
+	t.Helper()
+	p := gs.Players[id]
+	for tick := 0; tick < 200 && !gs.GameOver; tick++ {
+		var target engine.Point
+		for _, item := range gs.Items {
+			target = item.Pos
+			break
+		}
+		from := p.Pos
+		for _, direction := range directionsTowards(from, target) {
+			if err := gs.QueueMove(id, direction); err != nil {
+				t.Fatalf("QueueMove(%q, %q): %v", id, direction, err)
+			}
+			gs.ProcessTick()
+			if p.Pos != from {
+				break
+			}
+		}
+	}
+	if !gs.GameOver {
+		t.Fatal("a partida não terminou")
+	}
+}
+
+// directionsTowards lista as direções básicas que aproximam from de to, a horizontal primeiro
+func directionsTowards(from, to engine.Point) []string {
+	var directions []string
+	switch {
+	case to.X < from.X:
+		directions = append(directions, "left")
+	case to.X > from.X:
+		directions = append(directions, "right")
+	}
+	switch {
+	case to.Y < from.Y:
+		directions = append(directions, "up")
+	case to.Y > from.Y:
+		directions = append(directions, "down")
+	}
+	return directions
+}
+
+func TestLeaderboardRecordsFinishedGame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leaderboard.json")
+	lb, err := loadLeaderboard(path, DefaultRatingK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := engine.NewGameState("sala", engine.Config{BoardWidth: 5, BoardHeight: 2, NumItems: 2, RandomSeed: 7, Events: lb.start()})
+	if _, _, err := gs.AddPlayer("a", "Ana", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := gs.AddPlayer("b", "Bia", 0); err != nil {
+		t.Fatal(err)
+	}
+	gs.InitializeItems()
+	collectAll(t, gs, "a")
+
+	var records []leaderboardRecord
+	for deadline := time.Now().Add(2 * time.Second); len(records) == 0 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond) // A gravação acontece na goroutine do histórico
+		records = lb.top(10)
+	}
+	if len(records) != 1 {
+		t.Fatalf("histórico com %d partidas, deveria ter 1", len(records))
+	}
+	got := records[0]
+	if got.RoomID != "sala" || !reflect.DeepEqual(got.WinnerIDs, []string{"a"}) || !reflect.DeepEqual(got.WinnerNames, []string{"Ana"}) || got.Players != 2 || got.Score < 1 {
+		t.Errorf("registro inesperado: %+v", got)
+	}
+	if lb.rating("Ana") <= BaseRating || lb.rating("Bia") >= BaseRating {
+		t.Errorf("ratings depois da vitória de Ana: Ana %.1f, Bia %.1f", lb.rating("Ana"), lb.rating("Bia"))
+	}
+
+	reloaded, err := loadLeaderboard(path, DefaultRatingK) // O mesmo registro precisa estar no arquivo
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk := reloaded.top(10); len(disk) != 1 || !reflect.DeepEqual(disk[0].WinnerIDs, got.WinnerIDs) || disk[0].Score != got.Score {
+		t.Errorf("arquivo com %+v, deveria ter %+v", disk, got)
+	}
+}
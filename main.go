@@ -1,849 +1,1526 @@
 package main
 
 import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"game/engine"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
-	BoardWidth    = 20
-	BoardHeight   = 15
-	NumItems      = 15
-	GameTickDelay = 150 * time.Millisecond
+	DefaultBoardWidth   = 20
+	DefaultBoardHeight  = 15
+	DefaultNumItems     = 15
+	DefaultTickMs       = 150
+	MinTickMs           = 20   // Abaixo disso o tick (ou o broadcast) consome CPU demais sem ganho perceptível
+	MaxCatchUpSteps     = 5    // Passos de simulação recuperados num mesmo despertar do gameLoop; atraso maior é descartado
+	DefaultIdleTickMs   = 1000 // Ritmo de uma sala sem ninguém conectado
+	DefaultRoundBreak   = 10   // Segundos entre as rodadas de uma série quando AUTO_RESTART_SECONDS não é definida
+	DefaultRoomTTL      = 300  // Segundos que uma sala vazia sobrevive antes de ser removida
+	DefaultPingMs       = 20000
+	DefaultReconnectSec = 30 // Por quanto tempo um jogador desconectado pode voltar com seu token
+	DefaultSlowClient   = 10 // Mensagens descartadas seguidas antes de desconectar um cliente lento
+	ShutdownTimeout     = 5 * time.Second
+	DefaultWriteMs      = 10000 // Prazo padrão para cada escrita na conexão WebSocket
+	DefaultBotThreshold = 2     // Jogadores reais a partir dos quais os bots saem da sala
+	DefaultBotSkill     = 80    // Porcentagem de movimentos dos bots que seguem o menor caminho
+	DefaultSpeedSec     = 0     // Sem duração por padrão: o power-up de velocidade só entra no sorteio com SPEED_BOOST_SECONDS
+	DefaultGoldenChance = 0     // Sem chance por padrão: o diamante dourado só aparece com GOLDEN_CHANCE
+	DefaultGoldenSec    = 8     // Tempo padrão que o diamante dourado fica no tabuleiro
+	DefaultGoldenValue  = 20    // Pontos padrão do diamante dourado
+	DefaultItemSymbol   = "💎"   // Símbolo padrão dos itens no cliente
+	MaxSymbolLength     = 8     // Caracteres de um símbolo de ITEM_SYMBOL, ITEM_SYMBOLS ou PLAYER_SYMBOL (emojis compostos ocupam vários)
+	DefaultComboMs      = 0     // Sem janela por padrão: o multiplicador de sequência só liga com COMBO_WINDOW_MS
+	DefaultComboMax     = 3     // Multiplicador máximo padrão da sequência de coletas
+	DefaultOvertimeSec  = 30    // Duração máxima padrão da prorrogação de uma partida com tempo limite empatada
+	DefaultCompressMin  = 512   // Mensagens menores que isso saem sem compressão: o ganho não paga a CPU
+	DefaultChatMs       = 1000  // Intervalo mínimo padrão entre mensagens de chat de um jogador
+	DefaultMaxMessage   = 1024  // Tamanho máximo padrão de uma mensagem do cliente; cabe um chat de engine.MaxChatLength caracteres de até 4 bytes
 )
 
-type Point struct {
-	X int `json:"x"`
-	Y int `json:"y"`
+// Config reúne os parâmetros do servidor que podem ser ajustados via variáveis de ambiente: os da sala (engine.Config)
+// e os da camada de transporte
+type Config struct {
+	engine.Config
+	PingInterval     time.Duration // Intervalo entre pings enviados pelo 'writer'
+	PongWait         time.Duration // Tempo máximo sem receber pong antes de considerar o cliente desconectado
+	WriteTimeout     time.Duration // Prazo de cada escrita (mensagens, pings e fechamento) antes de desistir da conexão
+	SessionSecret    []byte        // Chave HMAC dos tokens de reconexão
+	WebhookURL       string        // URL que recebe um POST ao fim de cada partida (vazia desliga)
+	Leaderboard      string        // Arquivo JSON do histórico de partidas (vazio mantém só em memória)
+	RatingK          int           // Fator K dos ratings ELO atualizados a cada fim de jogo
+	RecordDir        string        // Diretório onde cada sala grava suas partidas para replay (vazio desliga)
+	SSEClients       int           // Máximo de assinantes simultâneos de /events
+	Compression      bool          // Oferece permessage-deflate aos clientes
+	CompressLevel    int           // Nível do deflate (1 = mais rápido, 9 = menor)
+	CompressMin      int           // Tamanho mínimo, em bytes, de uma mensagem comprimida
+	Origins          []string      // Origens aceitas no upgrade do WebSocket (vazio aceita todas)
+	TLSCertFile      string        // Certificado (PEM) para servir HTTPS/wss diretamente
+	TLSKeyFile       string        // Chave privada (PEM) do certificado
+	AdminToken       string        // Token Bearer exigido pelos endpoints /admin (vazio desliga os endpoints)
+	BanDuration      time.Duration // Duração padrão de um bloqueio de IP por /admin/ban-ip
+	TrustedProxyHops int           // Proxies reversos confiáveis na frente do servidor, para ler o IP do cliente em ProxyHeader
+	ProxyHeader      string        // Cabeçalho em que os proxies confiáveis anotam o IP do cliente
+	ConnRate         int           // Conexões WebSocket novas por minuto de um mesmo IP (0 desliga o limite)
+	ConnBurst        int           // Conexões seguidas que um IP pode abrir antes de ConnRate valer
+	MaxConnections   int           // Conexões WebSocket abertas no servidor inteiro, somando as salas (0 = sem limite)
+	IdleTickDelay    time.Duration // Intervalo entre ticks de uma sala sem conexões (0 mantém GAME_TICK_MS sempre)
+	RoomTTL          time.Duration // Tempo que uma sala vazia sobrevive antes de ser removida (0 = nunca)
+	MaxMessageBytes  int           // Tamanho máximo de uma mensagem do cliente; acima disso a conexão é encerrada
+	PprofAddr        string        // Endereço do listener de /debug/pprof (vazio desliga o profiler)
+	StateFile        string        // Arquivo JSON com a partida de cada sala, restaurada ao iniciar (vazio desliga)
+	StateInterval    time.Duration // Intervalo entre gravações de StateFile (0 grava só no encerramento)
+	ItemSymbol       string        // Símbolo padrão dos itens no cliente (os de ItemSymbols têm precedência)
+	PlayerSymbol     string        // Símbolo dos jogadores no tabuleiro (vazio = as iniciais do apelido)
 }
 
-type Player struct {
-	ID       string          `json:"id"`
-	Pos      Point           `json:"pos"`
-	Score    int             `json:"score"`
-	conn     *websocket.Conn `json:"-"`
-	sendChan chan []byte     `json:"-"`
-	IsActive bool            `json:"isActive"`
+type ClientMessage struct {
+	Action    string `json:"action"`
+	Direction string `json:"direction"`
+	Name      string `json:"name,omitempty"` // Usado pela ação "set_name"
+	Text      string `json:"text,omitempty"` // Usado pela ação "chat"
 }
 
-type Item struct {
-	ID  string `json:"id"`
-	Pos Point  `json:"pos"`
+// clientActions são os valores aceitos no campo "action" de ClientMessage
+var clientActions = map[string]bool{
+	"move":               true,
+	"set_name":           true,
+	"chat":               true,
+	"resync":             true,
+	"reset_game_request": true,
 }
 
-type GameState struct {
-	Players     map[string]*Player `json:"players"`
-	Items       map[string]*Item   `json:"items"`
-	BoardWidth  int                `json:"boardWidth"`
-	BoardHeight int                `json:"boardHeight"`
-	GameOver    bool               `json:"gameOver"`
-	WinnerID    string             `json:"winnerId,omitempty"`
-	mu          sync.Mutex         // Mutex para proteger o acesso concorrente ao estado
+// Tipos das mensagens do servidor que não são o estado do jogo
+const (
+	MsgTypeWelcome = "welcome"
+	MsgTypeError   = "error"
+	MsgTypeSystem  = "system" // Aviso da administração para todos os clientes (POST /admin/announce)
+	MsgTypeChat    = "chat"   // Mensagem de chat de um jogador, enviada a toda a sala
+)
+
+// Códigos enviados em mensagens MsgTypeError, para que o cliente reaja sem depender do texto
+const (
+	ErrCodeMalformedJSON    = "malformed_json"    // A mensagem não é um JSON (ou MessagePack, em mensagens binárias) válido
+	ErrCodeUnknownAction    = "unknown_action"    // Campo "action" desconhecido
+	ErrCodeMessageTooLarge  = "message_too_large" // Mensagem acima de MAX_MESSAGE_BYTES; a conexão é fechada em seguida
+	ErrCodeInvalidDirection = "invalid_direction" // Movimento com direção desconhecida (nem básica, nem diagonal)
+	ErrCodeGameOver         = "game_over"         // Movimento enviado depois do fim da partida
+	ErrCodeRateLimited      = "rate_limited"      // Movimento acima do limite MOVE_INTERVAL_MS
+	ErrCodeSpectator        = "spectator"         // Espectadores não podem agir na partida
+	ErrCodeEliminated       = "eliminated"        // Movimento de um jogador eliminado no modo rastro
+	ErrCodeRoomFull         = "room_full"         // A sala atingiu MAX_PLAYERS; a conexão é fechada em seguida
+	ErrCodeBoardFull        = "board_full"        // Sem célula livre para o jogador; a conexão é fechada em seguida
+	ErrCodeIdle             = "idle"              // Jogador sem se mover por IDLE_TIMEOUT_SECONDS; a conexão é fechada em seguida
+	ErrCodeKicked           = "kicked"            // Expulso por /admin/kick ou /admin/ban-ip; a conexão é fechada em seguida
+	ErrCodeChatEmpty        = "chat_empty"        // Mensagem de chat sem texto depois de removidos os caracteres de controle
+	ErrCodeChatRateLimited  = "chat_rate_limited" // Mensagem de chat acima do limite CHAT_INTERVAL_MS
+)
+
+// ServerError é a mensagem MsgTypeError enviada ao cliente quando uma ação dele é rejeitada
+type ServerError struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
-type ClientMessage struct {
-	Action    string `json:"action"`
-	Direction string `json:"direction"`
+// ChatMessage é a mensagem MsgTypeChat que leva o chat de um jogador a toda a sala
+type ChatMessage struct {
+	Type     string    `json:"type"`
+	PlayerID string    `json:"playerId"`
+	Name     string    `json:"name,omitempty"`
+	Text     string    `json:"text"`
+	Time     time.Time `json:"time"`
 }
 
-var game = &GameState{
-	Players:     make(map[string]*Player),
-	Items:       make(map[string]*Item),
-	BoardWidth:  BoardWidth,
-	BoardHeight: BoardHeight,
-	GameOver:    false,
+// SystemMessage é o aviso MsgTypeSystem que a administração envia a todos os clientes
+type SystemMessage struct {
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
 }
 
+var rooms *RoomManager // Inicializado em main() a partir da configuração
+
+//go:embed web/index.html web/admin.html
+var webFS embed.FS
+
+// indexTemplate é o cliente HTML/JS, renderizado com a configuração do servidor em cada requisição
+var indexTemplate = template.Must(template.ParseFS(webFS, "web/index.html"))
+
+// clientConfig são os valores de configuração injetados no template do cliente
+type clientConfig struct {
+	BoardWidth   int
+	BoardHeight  int
+	TickMs       int
+	ItemSymbol   string // Símbolo padrão dos itens
+	PlayerSymbol string // Símbolo dos jogadores; vazio desenha as iniciais
+}
+
+var config Config // Carregada do ambiente em main()
+
+var startedAt = time.Now() // Início do processo, para o uptime em /stats
+
+var openConns atomic.Int64 // Conexões WebSocket abertas em todas as salas (jogadores e espectadores), para MAX_CONNECTIONS
+
+var writers sync.WaitGroup // Acompanha as goroutines 'writer' para que o shutdown espere o envio dos frames de fechamento
+
+// connsCtx é o contexto de todas as conexões WebSocket; cancelado no shutdown se os escritores não encerrarem
+// sozinhos, ele derruba as conexões que sobraram (e, com elas, seus readers)
+var connsCtx, closeConns = context.WithCancel(context.Background())
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
+	CheckOrigin:  checkOrigin,
+	Subprotocols: []string{SubprotocolMsgpack, SubprotocolJSON}, // Em ordem de preferência, se o cliente oferecer os dois
+}
+
+// checkOrigin aceita o upgrade se ALLOWED_ORIGINS estiver vazia, se a requisição não tiver Origin (clientes
+// que não são navegadores) ou se o Origin bater com algum padrão da lista
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(config.Origins) == 0 || origin == "" {
 		return true
-	},
-}
-
-// initializeItems coloca os itens no tabuleiro em posições aleatórias
-func (gs *GameState) initializeItems() {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-
-	gs.Items = make(map[string]*Item)
-	for i := 0; i < NumItems; i++ {
-		var itemPos Point
-		uniquePos := false
-		for !uniquePos { // Garante que o item não sobreponha outro item ou jogador inicial
-			itemPos = Point{X: rand.Intn(BoardWidth), Y: rand.Intn(BoardHeight)}
-			key := fmt.Sprintf("%d,%d", itemPos.X, itemPos.Y)
-			if _, exists := gs.Items[key]; !exists {
-				playerOccupies := false
-				for _, p := range gs.Players { // Verifica se algum jogador já está lá
-					if p.Pos.X == itemPos.X && p.Pos.Y == itemPos.Y {
-						playerOccupies = true
-						break
-					}
-				}
-				if !playerOccupies {
-					uniquePos = true
-				}
-			}
-		}
-		itemID := "item_" + strconv.Itoa(i)
-		itemKey := fmt.Sprintf("%d,%d", itemPos.X, itemPos.Y)
-		gs.Items[itemKey] = &Item{ID: itemID, Pos: itemPos}
 	}
+	if originAllowed(strings.ToLower(origin), config.Origins) {
+		return true
+	}
+	slog.Warn("Conexão WebSocket recusada: origem não permitida", "origin", origin, "ip", clientIP(r))
+	return false
+}
 
-	gs.GameOver = false
-	gs.WinnerID = ""
-
-	for _, player := range gs.Players {
-		if player.IsActive {
-			player.Score = 0
+// originAllowed compara a origem com os padrões de ALLOWED_ORIGINS: "*" (qualquer uma), uma origem exata
+// ("https://jogo.example.com") ou um curinga de subdomínio ("https://*.example.com", que não inclui o próprio
+// example.com). A comparação é feita em minúsculas.
+func originAllowed(origin string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(pattern, "://*."); ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+domain) {
+			return true
 		}
 	}
+	return false
+}
 
-	log.Printf("Jogo iniciado/resetado com %d itens. Pontuações dos jogadores zeradas.", len(gs.Items))
+// setupLogging configura o slog padrão no nível de LOG_LEVEL (debug, info, warn ou error; info por padrão).
+// O pacote log também passa a escrever por ele, então log.Fatalf continua saindo no mesmo formato.
+func setupLogging() error {
+	level := slog.LevelInfo
+	if raw := os.Getenv("LOG_LEVEL"); raw != "" {
+		if err := level.UnmarshalText([]byte(raw)); err != nil {
+			return fmt.Errorf("LOG_LEVEL deve ser debug, info, warn ou error, recebido %q", raw)
+		}
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	return nil
 }
 
-func (gs *GameState) addPlayer(id string, conn *websocket.Conn) *Player {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
+// loadConfig lê a configuração do ambiente, usando os valores padrão quando as variáveis não estão definidas
+func loadConfig() (Config, error) {
+	cfg := Config{
+		Config: engine.Config{
+			BoardWidth:  DefaultBoardWidth,
+			BoardHeight: DefaultBoardHeight,
+			NumItems:    DefaultNumItems,
+			TickDelay:   DefaultTickMs * time.Millisecond,
+		},
+		PingInterval: DefaultPingMs * time.Millisecond,
+		WriteTimeout: DefaultWriteMs * time.Millisecond,
+	}
 
-	var startPos Point
-	uniquePos := false
-	for !uniquePos { // Encontra uma posição inicial única
-		startPos = Point{X: rand.Intn(BoardWidth), Y: rand.Intn(BoardHeight)}
-		occupied := false
-		for _, p := range gs.Players {
-			if p.Pos.X == startPos.X && p.Pos.Y == startPos.Y {
-				occupied = true
-				break
-			}
-		}
-		if occupied {
-			continue
-		}
-		itemKey := fmt.Sprintf("%d,%d", startPos.X, startPos.Y)
-		if _, exists := gs.Items[itemKey]; exists { // Não nascer em cima de um item
-			occupied = true
+	var err error
+	if cfg.BoardWidth, err = envPositiveInt("BOARD_WIDTH", cfg.BoardWidth); err != nil {
+		return cfg, err
+	}
+	if cfg.BoardHeight, err = envPositiveInt("BOARD_HEIGHT", cfg.BoardHeight); err != nil {
+		return cfg, err
+	}
+	if cfg.NumItems, err = envPositiveInt("NUM_ITEMS", cfg.NumItems); err != nil {
+		return cfg, err
+	}
+	if cfg.NumItems >= cfg.BoardWidth*cfg.BoardHeight {
+		return cfg, fmt.Errorf("NUM_ITEMS (%d) deve ser menor que o número de células do tabuleiro (%d)", cfg.NumItems, cfg.BoardWidth*cfg.BoardHeight)
+	}
+	if raw := os.Getenv("ITEM_DENSITY"); raw != "" {
+		if cfg.ItemDensity, err = strconv.ParseFloat(raw, 64); err != nil || cfg.ItemDensity < 0 || cfg.ItemDensity >= 1 {
+			return cfg, fmt.Errorf("ITEM_DENSITY deve ser um número de 0 (desligado) a 1 (exclusive), recebido %q", raw)
 		}
-		if !occupied {
-			uniquePos = true
+	}
+
+	tickMs, err := envPositiveInt("GAME_TICK_MS", DefaultTickMs)
+	if err != nil {
+		return cfg, err
+	}
+	if tickMs < MinTickMs {
+		return cfg, fmt.Errorf("GAME_TICK_MS (%d) deve ser de no mínimo %d ms", tickMs, MinTickMs)
+	}
+	cfg.TickDelay = time.Duration(tickMs) * time.Millisecond
+
+	broadcastMs, err := envNonNegativeInt("BROADCAST_MS", 0)
+	if err != nil {
+		return cfg, err
+	}
+	if broadcastMs > 0 && broadcastMs < MinTickMs {
+		return cfg, fmt.Errorf("BROADCAST_MS (%d) deve ser 0 ou no mínimo %d ms", broadcastMs, MinTickMs)
+	}
+	cfg.BroadcastDelay = time.Duration(broadcastMs) * time.Millisecond
+
+	idleTickMs, err := envNonNegativeInt("IDLE_TICK_MS", DefaultIdleTickMs)
+	if err != nil {
+		return cfg, err
+	}
+	if idleTickMs > 0 && idleTickMs < tickMs {
+		return cfg, fmt.Errorf("IDLE_TICK_MS (%d) deve ser 0 ou no mínimo GAME_TICK_MS (%d)", idleTickMs, tickMs)
+	}
+	cfg.IdleTickDelay = time.Duration(idleTickMs) * time.Millisecond
+
+	roomTTL, err := envNonNegativeInt("ROOM_TTL_SECONDS", DefaultRoomTTL)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.RoomTTL = time.Duration(roomTTL) * time.Second
+
+	pingMs, err := envPositiveInt("PING_INTERVAL_MS", DefaultPingMs)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.PingInterval = time.Duration(pingMs) * time.Millisecond
+	cfg.PongWait = cfg.PingInterval * 3 / 2 // Folga para a latência do pong antes de desistir do cliente
+
+	writeMs, err := envPositiveInt("WRITE_TIMEOUT_MS", DefaultWriteMs)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.WriteTimeout = time.Duration(writeMs) * time.Millisecond
+
+	durationSec, err := envNonNegativeInt("GAME_DURATION", 0)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.GameDuration = time.Duration(durationSec) * time.Second
+	overtimeSec, err := envNonNegativeInt("OVERTIME_SECONDS", DefaultOvertimeSec)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Overtime = time.Duration(overtimeSec) * time.Second
+
+	respawnMs, err := envNonNegativeInt("ITEM_RESPAWN_MS", 0)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.RespawnInterval = time.Duration(respawnMs) * time.Millisecond
+	if cfg.RespawnTarget, err = envPositiveInt("ITEM_RESPAWN_TARGET", cfg.NumItems); err != nil {
+		return cfg, err
+	}
+	if cfg.RespawnTarget >= cfg.BoardWidth*cfg.BoardHeight {
+		return cfg, fmt.Errorf("ITEM_RESPAWN_TARGET (%d) deve ser menor que o número de células do tabuleiro (%d)", cfg.RespawnTarget, cfg.BoardWidth*cfg.BoardHeight)
+	}
+
+	if cfg.ObstacleCount, err = envNonNegativeInt("OBSTACLE_COUNT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.ObstacleCount+max(cfg.NumItems, cfg.RespawnTarget) >= cfg.BoardWidth*cfg.BoardHeight {
+		return cfg, fmt.Errorf("OBSTACLE_COUNT (%d) não deixa células livres suficientes para os itens e jogadores", cfg.ObstacleCount)
+	}
+	if cfg.IceCount, err = envNonNegativeInt("ICE_COUNT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.ObstacleCount+cfg.IceCount > cfg.BoardWidth*cfg.BoardHeight {
+		return cfg, fmt.Errorf("ICE_COUNT (%d) não cabe nas células sem parede do tabuleiro", cfg.IceCount)
+	}
+	if cfg.TeleportPairs, err = envNonNegativeInt("TELEPORT_PAIRS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.ObstacleCount+cfg.IceCount+2*cfg.TeleportPairs > cfg.BoardWidth*cfg.BoardHeight {
+		return cfg, fmt.Errorf("TELEPORT_PAIRS (%d) não cabe nas células sem parede nem gelo do tabuleiro", cfg.TeleportPairs)
+	}
+	cfg.ObstacleSeed = time.Now().UnixNano()
+	if raw := os.Getenv("OBSTACLE_SEED"); raw != "" {
+		if cfg.ObstacleSeed, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return cfg, fmt.Errorf("OBSTACLE_SEED deve ser um inteiro, recebido %q", raw)
 		}
 	}
 
-	player := &Player{
-		ID:       id,
-		Pos:      startPos,
-		Score:    0,
-		conn:     conn,
-		sendChan: make(chan []byte, 256), // Canal bufferizado para mensagens de saída
-		IsActive: true,
+	if raw := os.Getenv("GAME_SEED"); raw != "" {
+		if cfg.RandomSeed, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return cfg, fmt.Errorf("GAME_SEED deve ser um inteiro, recebido %q", raw)
+		}
 	}
-	gs.Players[id] = player
-	log.Printf("Jogador %s entrou em (%d, %d). Total de jogadores: %d", id, player.Pos.X, player.Pos.Y, len(gs.Players))
-	return player
-}
 
-func (gs *GameState) removePlayer(id string) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
+	if cfg.MovesPerTick, err = envPositiveInt("MOVES_PER_TICK", 1); err != nil {
+		return cfg, err
+	}
 
-	if player, ok := gs.Players[id]; ok {
-		player.IsActive = false // Marca como inativo
-		close(player.sendChan)  // Fecha o canal de envio, sinalizando para a goroutine 'writer' parar
-		delete(gs.Players, id)  // Remove do mapa principal
-		log.Printf("Jogador %s removido. Total de jogadores: %d", id, len(gs.Players))
+	// Por padrão, no máximo MOVES_PER_TICK movimentos por tick: inputs extras entre ticks são descartados
+	moveMs, err := envNonNegativeInt("MOVE_INTERVAL_MS", tickMs/cfg.MovesPerTick)
+	if err != nil {
+		return cfg, err
 	}
-}
+	cfg.MoveInterval = time.Duration(moveMs) * time.Millisecond
 
-func (gs *GameState) handlePlayerMove(playerID string, direction string) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
+	reconnectSec, err := envNonNegativeInt("RECONNECT_GRACE_SECONDS", DefaultReconnectSec)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.ReconnectGrace = time.Duration(reconnectSec) * time.Second
 
-	if gs.GameOver {
-		return
+	speedSec, err := envNonNegativeInt("SPEED_BOOST_SECONDS", DefaultSpeedSec)
+	if err != nil {
+		return cfg, err
 	}
+	cfg.SpeedBoost = time.Duration(speedSec) * time.Second
 
-	player, ok := gs.Players[playerID]
-	if !ok || !player.IsActive {
-		return
+	cfg.GoldenChance = DefaultGoldenChance
+	if raw := os.Getenv("GOLDEN_CHANCE"); raw != "" {
+		if cfg.GoldenChance, err = strconv.ParseFloat(raw, 64); err != nil || cfg.GoldenChance < 0 || cfg.GoldenChance > 1 {
+			return cfg, fmt.Errorf("GOLDEN_CHANCE deve ser uma chance por segundo de 0 (desligado) a 1, recebido %q", raw)
+		}
+	}
+	goldenSec, err := envNonNegativeInt("GOLDEN_SECONDS", DefaultGoldenSec)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.GoldenLifetime = time.Duration(goldenSec) * time.Second
+	if cfg.GoldenValue, err = envPositiveInt("GOLDEN_VALUE", DefaultGoldenValue); err != nil {
+		return cfg, err
+	}
+	if cfg.GoldenChance == 0 || cfg.GoldenLifetime == 0 { // Qualquer um dos dois desliga o diamante
+		cfg.GoldenChance, cfg.GoldenLifetime = 0, 0
 	}
 
-	newPos := player.Pos
-	switch direction {
-	case "up":
-		if newPos.Y > 0 {
-			newPos.Y--
+	if cfg.ItemSymbol, err = envSymbol("ITEM_SYMBOL", DefaultItemSymbol); err != nil {
+		return cfg, err
+	}
+	if cfg.PlayerSymbol, err = envSymbol("PLAYER_SYMBOL", ""); err != nil {
+		return cfg, err
+	}
+	for _, entry := range strings.Split(os.Getenv("ITEM_SYMBOLS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
 		}
-	case "down":
-		if newPos.Y < BoardHeight-1 {
-			newPos.Y++
+		kind, symbol, ok := strings.Cut(entry, "=")
+		kind, symbol = strings.TrimSpace(kind), strings.TrimSpace(symbol)
+		if !ok || !engine.IsItemKind(kind) {
+			return cfg, fmt.Errorf("ITEM_SYMBOLS deve ter pares tipo=símbolo separados por vírgula (tipos: common, rare, legendary, speed, bomb, golden), recebido %q", entry)
 		}
-	case "left":
-		if newPos.X > 0 {
-			newPos.X--
+		if !validSymbol(symbol) {
+			return cfg, fmt.Errorf("ITEM_SYMBOLS: o símbolo de %s deve ter de 1 a %d caracteres visíveis, recebido %q", kind, MaxSymbolLength, symbol)
 		}
-	case "right":
-		if newPos.X < BoardWidth-1 {
-			newPos.X++
+		if cfg.ItemSymbols == nil {
+			cfg.ItemSymbols = make(map[string]string)
 		}
-	default:
-		return // Direção inválida
-	}
-
-	player.Pos = newPos // Atualiza a posição do jogador
-
-	// Verifica coleta de item
-	itemKey := fmt.Sprintf("%d,%d", newPos.X, newPos.Y)
-	if item, exists := gs.Items[itemKey]; exists {
-		player.Score++
-		delete(gs.Items, itemKey) // Remove o item do jogo
-		log.Printf("Jogador %s coletou item %s. Pontuação: %d. Itens restantes: %d", player.ID, item.ID, player.Score, len(gs.Items))
-
-		if len(gs.Items) == 0 { // Verifica se o jogo acabou
-			gs.GameOver = true
-			winnerScore := -1
-			var winners []string
-			for _, p := range gs.Players {
-				if p.IsActive {
-					if p.Score > winnerScore {
-						winnerScore = p.Score
-						winners = []string{p.ID}
-					} else if p.Score == winnerScore {
-						winners = append(winners, p.ID)
-					}
-				}
-			}
-			if len(winners) > 0 {
-				gs.WinnerID = fmt.Sprintf("%v", winners) // Pode haver empates
-				log.Printf("FIM DE JOGO! Vencedor(es): %s com %d pontos.", gs.WinnerID, winnerScore)
-			} else {
-				log.Printf("FIM DE JOGO! Nenhum jogador ativo para declarar vencedor.")
-			}
+		cfg.ItemSymbols[kind] = symbol
+	}
+
+	comboMs, err := envNonNegativeInt("COMBO_WINDOW_MS", DefaultComboMs)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.ComboWindow = time.Duration(comboMs) * time.Millisecond
+	if cfg.ComboMax, err = envPositiveInt("COMBO_MAX", DefaultComboMax); err != nil {
+		return cfg, err
+	}
+
+	cfg.Compression = true
+	if raw := os.Getenv("WS_COMPRESSION"); raw != "" {
+		if cfg.Compression, err = strconv.ParseBool(raw); err != nil {
+			return cfg, fmt.Errorf("WS_COMPRESSION deve ser true ou false, recebido %q", raw)
 		}
 	}
-}
+	if cfg.CompressLevel, err = envPositiveInt("WS_COMPRESSION_LEVEL", flate.BestSpeed); err != nil {
+		return cfg, err
+	}
+	if cfg.CompressLevel > flate.BestCompression {
+		return cfg, fmt.Errorf("WS_COMPRESSION_LEVEL deve estar entre 1 e 9, recebido %d", cfg.CompressLevel)
+	}
+	if cfg.CompressMin, err = envNonNegativeInt("WS_COMPRESSION_MIN_BYTES", DefaultCompressMin); err != nil {
+		return cfg, err
+	}
 
-// broadcastGameState envia o estado atual do jogo para todos os jogadores ativos
-func (gs *GameState) broadcastGameState() {
-	gs.mu.Lock() // Protege leitura do estado para criar o snapshot
+	if raw := os.Getenv("TRAIL"); raw != "" {
+		if cfg.Trail, err = strconv.ParseBool(raw); err != nil {
+			return cfg, fmt.Errorf("TRAIL deve ser true ou false, recebido %q", raw)
+		}
+	}
+	if raw := os.Getenv("WRAP"); raw != "" {
+		if cfg.Wrap, err = strconv.ParseBool(raw); err != nil {
+			return cfg, fmt.Errorf("WRAP deve ser true ou false, recebido %q", raw)
+		}
+	}
+	if raw := os.Getenv("TIE_BREAK"); raw != "" {
+		if cfg.TieBreak, err = strconv.ParseBool(raw); err != nil {
+			return cfg, fmt.Errorf("TIE_BREAK deve ser true ou false, recebido %q", raw)
+		}
+	}
+
+	if cfg.TargetScore, err = envNonNegativeInt("TARGET_SCORE", 0); err != nil {
+		return cfg, err
+	}
 
-	playersToSend := make(map[string]interface{})
-	for id, p := range gs.Players {
-		if p.IsActive {
-			playersToSend[id] = struct {
-				ID    string `json:"id"`
-				Pos   Point  `json:"pos"`
-				Score int    `json:"score"`
-			}{p.ID, p.Pos, p.Score}
+	if cfg.StartScore, err = envNonNegativeInt("START_SCORE", 0); err != nil {
+		return cfg, err
+	}
+	if raw := os.Getenv("SCORE_FLOOR"); raw == "none" {
+		cfg.NoScoreFloor = true
+	} else if raw != "" {
+		if cfg.ScoreFloor, err = strconv.Atoi(raw); err != nil {
+			return cfg, fmt.Errorf("SCORE_FLOOR deve ser um inteiro ou \"none\", recebido %q", raw)
 		}
 	}
+	if !cfg.NoScoreFloor && cfg.ScoreFloor > cfg.StartScore {
+		return cfg, fmt.Errorf("SCORE_FLOOR (%d) não pode ser maior que START_SCORE (%d)", cfg.ScoreFloor, cfg.StartScore)
+	}
+	if cfg.TargetScore > 0 && cfg.TargetScore <= cfg.StartScore {
+		return cfg, fmt.Errorf("TARGET_SCORE (%d) deve ser maior que START_SCORE (%d)", cfg.TargetScore, cfg.StartScore)
+	}
 
-	itemsToSend := make(map[string]*Item)
-	for id, i := range gs.Items {
-		itemsToSend[id] = i
+	restartSec, err := envNonNegativeInt("AUTO_RESTART_SECONDS", 0)
+	if err != nil {
+		return cfg, err
 	}
+	cfg.AutoRestart = time.Duration(restartSec) * time.Second
 
-	stateSnapshot := struct {
-		Players     map[string]interface{} `json:"players"`
-		Items       map[string]*Item       `json:"items"`
-		BoardWidth  int                    `json:"boardWidth"`
-		BoardHeight int                    `json:"boardHeight"`
-		GameOver    bool                   `json:"gameOver"`
-		WinnerID    string                 `json:"winnerId,omitempty"`
-	}{
-		Players:     playersToSend,
-		Items:       itemsToSend,
-		BoardWidth:  gs.BoardWidth,
-		BoardHeight: gs.BoardHeight,
-		GameOver:    gs.GameOver,
-		WinnerID:    gs.WinnerID,
+	if cfg.SlowClientLimit, err = envNonNegativeInt("SLOW_CLIENT_LIMIT", DefaultSlowClient); err != nil {
+		return cfg, err
 	}
-	gs.mu.Unlock() // Libera o mutex assim que a cópia é feita
 
-	message, err := json.Marshal(stateSnapshot)
+	if cfg.Teams, err = envNonNegativeInt("TEAMS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.Teams == 1 || cfg.Teams > engine.MaxTeams {
+		return cfg, fmt.Errorf("TEAMS deve ser 0 (sem equipes) ou de 2 a %d, recebido %d", engine.MaxTeams, cfg.Teams)
+	}
+
+	if cfg.Rounds, err = envNonNegativeInt("ROUNDS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.Rounds > 1 && cfg.AutoRestart == 0 { // A série precisa seguir sozinha de uma rodada para a outra
+		cfg.AutoRestart = DefaultRoundBreak * time.Second
+	}
+
+	chatMs, err := envNonNegativeInt("CHAT_INTERVAL_MS", DefaultChatMs)
 	if err != nil {
-		log.Printf("Erro ao serializar estado do jogo: %v", err)
-		return
+		return cfg, err
 	}
+	cfg.ChatInterval = time.Duration(chatMs) * time.Millisecond
 
-	// Coleta jogadores ativos para enviar a mensagem (para evitar segurar o lock durante os envios)
-	activePlayersToSendTo := []*Player{}
-	gs.mu.Lock()
-	for _, player := range gs.Players {
-		if player.IsActive {
-			activePlayersToSendTo = append(activePlayersToSendTo, player)
+	if cfg.ViewRadius, err = envNonNegativeInt("VIEW_RADIUS", 0); err != nil {
+		return cfg, err
+	}
+
+	if cfg.SendBuffer, err = envPositiveInt("SEND_BUFFER", engine.DefaultSendBuffer); err != nil {
+		return cfg, err
+	}
+
+	if cfg.MaxMessageBytes, err = envPositiveInt("MAX_MESSAGE_BYTES", DefaultMaxMessage); err != nil {
+		return cfg, err
+	}
+
+	if cfg.MaxPlayers, err = envNonNegativeInt("MAX_PLAYERS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxConnections, err = envNonNegativeInt("MAX_CONNECTIONS", 0); err != nil {
+		return cfg, err
+	}
+
+	idleSec, err := envNonNegativeInt("IDLE_TIMEOUT_SECONDS", 0)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.IdleTimeout = time.Duration(idleSec) * time.Second
+
+	if cfg.BotCount, err = envNonNegativeInt("BOT_COUNT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.BotThreshold, err = envPositiveInt("BOT_THRESHOLD", DefaultBotThreshold); err != nil {
+		return cfg, err
+	}
+	if cfg.BotSkill, err = envNonNegativeInt("BOT_SKILL", DefaultBotSkill); err != nil {
+		return cfg, err
+	}
+	if cfg.BotSkill > 100 {
+		return cfg, fmt.Errorf("BOT_SKILL deve estar entre 0 e 100, recebido %d", cfg.BotSkill)
+	}
+
+	if raw := os.Getenv("GAME_OVER_WEBHOOK_URL"); raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("GAME_OVER_WEBHOOK_URL deve ser uma URL http(s), recebido %q", raw)
 		}
+		cfg.WebhookURL = raw
 	}
-	gs.mu.Unlock()
 
-	for _, player := range activePlayersToSendTo {
-		select {
-		case player.sendChan <- message:
-		default:
-			log.Printf("Canal de envio do jogador %s cheio. Descartando mensagem de estado.", player.ID)
+	cfg.Leaderboard = DefaultLeaderboardFile
+	if path, ok := os.LookupEnv("LEADERBOARD_FILE"); ok {
+		cfg.Leaderboard = path
+	}
+	if cfg.RatingK, err = envPositiveInt("RATING_K_FACTOR", DefaultRatingK); err != nil {
+		return cfg, err
+	}
+
+	cfg.RecordDir = os.Getenv("RECORD_DIR")
+
+	if raw := os.Getenv("ENABLE_PPROF"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("ENABLE_PPROF deve ser true ou false, recebido %q", raw)
+		}
+		if enabled {
+			cfg.PprofAddr = DefaultPprofAddr
+			if addr := os.Getenv("PPROF_ADDR"); addr != "" {
+				cfg.PprofAddr = addr
+			}
 		}
 	}
+
+	cfg.StateFile = os.Getenv("STATE_FILE")
+	stateSec, err := envNonNegativeInt("STATE_SAVE_SECONDS", DefaultStateSaveSec)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.StateInterval = time.Duration(stateSec) * time.Second
+
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		if origin == "" {
+			continue
+		}
+		if origin != "*" && !strings.Contains(origin, "://") {
+			return cfg, fmt.Errorf("ALLOWED_ORIGINS deve ter origens completas como https://jogo.example.com (ou *), recebido %q", origin)
+		}
+		cfg.Origins = append(cfg.Origins, strings.TrimSuffix(origin, "/"))
+	}
+
+	if cfg.SSEClients, err = envPositiveInt("SSE_MAX_CLIENTS", DefaultSSEClients); err != nil {
+		return cfg, err
+	}
+
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+	banSec, err := envPositiveInt("BAN_DURATION_SECONDS", DefaultBanSec)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.BanDuration = time.Duration(banSec) * time.Second
+	if cfg.TrustedProxyHops, err = envNonNegativeInt("TRUSTED_PROXY_HOPS", 0); err != nil {
+		return cfg, err
+	}
+	cfg.ProxyHeader = http.CanonicalHeaderKey(strings.TrimSpace(os.Getenv("TRUSTED_PROXY_HEADER")))
+	if cfg.ProxyHeader == "" {
+		cfg.ProxyHeader = DefaultProxyHeader
+	}
+	if cfg.ConnRate, err = envNonNegativeInt("CONN_RATE_PER_MIN", DefaultConnRate); err != nil {
+		return cfg, err
+	}
+	if cfg.ConnBurst, err = envPositiveInt("CONN_BURST", DefaultConnBurst); err != nil {
+		return cfg, err
+	}
+
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return cfg, fmt.Errorf("TLS_CERT_FILE e TLS_KEY_FILE devem ser definidas juntas")
+	}
+
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		cfg.SessionSecret = []byte(secret)
+	} else {
+		cfg.SessionSecret = newSessionSecret()
+	}
+	return cfg, nil
+}
+
+// envPositiveInt lê uma variável de ambiente inteira e positiva, retornando def se ela não estiver definida
+func envPositiveInt(name string, def int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s deve ser um inteiro positivo, recebido %q", name, raw)
+	}
+	return value, nil
+}
+
+// envSymbol lê um símbolo de exibição do cliente, retornando def se a variável não estiver definida
+func envSymbol(name string, def string) (string, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def, nil
+	}
+	if !validSymbol(raw) {
+		return "", fmt.Errorf("%s deve ter de 1 a %d caracteres visíveis, recebido %q", name, MaxSymbolLength, raw)
+	}
+	return raw, nil
+}
+
+// validSymbol aceita símbolos curtos e sem caracteres de controle, que vão direto para o tabuleiro
+func validSymbol(symbol string) bool {
+	n := utf8.RuneCountInString(symbol)
+	return n >= 1 && n <= MaxSymbolLength && utf8.ValidString(symbol) && !strings.ContainsFunc(symbol, unicode.IsControl)
+}
+
+// envNonNegativeInt lê uma variável de ambiente inteira que pode ser zero (usado para desligar funcionalidades)
+func envNonNegativeInt(name string, def int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s deve ser um inteiro maior ou igual a zero, recebido %q", name, raw)
+	}
+	return value, nil
+}
+
+// heartbeat liga os pings enviados pelo 'writer' aos pongs lidos pelo 'reader' de uma conexão, para medir a
+// latência. O payload de cada ping é o instante do envio (ns desde o início do processo, pelo relógio monotônico),
+// que o cliente devolve no pong.
+type heartbeat struct {
+	gs       *engine.GameState
+	playerID string
+	sendChan chan []byte
+	lastPing atomic.Int64 // Payload do último ping enviado; 0 antes do primeiro
+	lastPong atomic.Int64 // Payload do último pong que respondeu ao ping mais recente
+}
+
+// ping prepara o payload do próximo ping. Se o anterior ficou sem resposta, a latência passa a ser desconhecida;
+// quem não responde por PONG_WAIT acaba desconectado pelo prazo de leitura do 'reader'.
+func (hb *heartbeat) ping() []byte {
+	if last := hb.lastPing.Load(); last != 0 && hb.lastPong.Load() != last {
+		hb.gs.SetLatency(hb.playerID, hb.sendChan, 0)
+	}
+	sent := time.Since(startedAt).Nanoseconds()
+	hb.lastPing.Store(sent)
+	return strconv.AppendInt(nil, sent, 10)
 }
 
-// writer é uma goroutine que envia mensagens do `sendChan` para o WebSocket do jogador
-func writer(player *Player) {
+// pong registra o RTT de um pong. Pongs atrasados (de um ping anterior ao último) e payloads que não são
+// nossos são ignorados.
+func (hb *heartbeat) pong(payload string) {
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil || sent == 0 || sent != hb.lastPing.Load() {
+		return
+	}
+	hb.lastPong.Store(sent)
+	hb.gs.SetLatency(hb.playerID, hb.sendChan, time.Since(startedAt)-time.Duration(sent))
+}
+
+// writer é uma goroutine que envia mensagens do `sendChan` para o WebSocket do jogador.
+// Recebe a conexão e o canal explicitamente porque uma reconexão os substitui no Player.
+// Com binary, cada mensagem é convertida para MessagePack e enviada como BinaryMessage. Se ctx for cancelado, envia
+// um fechamento "going away" e sai; o conn.Close do defer faz o 'reader' retornar em seguida. O primeiro ping sai
+// logo na entrada, para a latência aparecer sem esperar PING_INTERVAL_MS.
+func writer(ctx context.Context, player *engine.Player, conn *websocket.Conn, sendChan <-chan []byte, binary bool, hb *heartbeat) {
 	defer func() {
-		player.conn.Close() // Fecha a conexão ao sair
-		log.Printf("Escritor para o jogador %s encerrado.", player.ID)
+		conn.Close() // Fecha a conexão ao sair
+		slog.Debug("Escritor encerrado", "player_id", player.ID)
+		writers.Done()
 	}()
 
-	for message := range player.sendChan { // Loop até o canal ser fechado
-		if err := player.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Printf("Erro ao escrever para jogador %s: %v", player.ID, err)
-			return // Encerra se houver erro de escrita (conexão provavelmente perdida)
+	// SetCompressionLevel só tem efeito se o cliente negociou permessage-deflate
+	if err := conn.SetCompressionLevel(config.CompressLevel); err != nil {
+		slog.Debug("Nível de compressão inválido", "player_id", player.ID, "err", err)
+	}
+
+	// Os pings saem desta mesma goroutine, então nunca concorrem com as escritas de mensagens na conexão
+	pingTicker := time.NewTicker(config.PingInterval)
+	defer pingTicker.Stop()
+	if err := conn.WriteControl(websocket.PingMessage, hb.ping(), time.Now().Add(config.WriteTimeout)); err != nil {
+		slog.Debug("Erro ao enviar ping", "player_id", player.ID, "err", err)
+		return
+	}
+
+	for {
+		select {
+		case message, ok := <-sendChan:
+			if !ok {
+				// Canal fechado: avisa o cliente com um fechamento normal em vez de simplesmente derrubar a conexão
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "conexão encerrada pelo servidor")
+				conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(config.WriteTimeout))
+				return
+			}
+			messageType := websocket.TextMessage
+			if binary {
+				packed, err := jsonToMsgpack(message)
+				if err != nil {
+					slog.Error("Erro ao converter mensagem para MessagePack", "player_id", player.ID, "err", err)
+					continue
+				}
+				messageType, message = websocket.BinaryMessage, packed
+			}
+			conn.EnableWriteCompression(len(message) >= config.CompressMin) // Estado sim; boas-vindas e erros não valem a pena
+			// Sem prazo, uma conexão TCP travada bloquearia esta goroutine para sempre enquanto o sendChan enche
+			conn.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+			if err := conn.WriteMessage(messageType, message); err != nil {
+				slog.Debug("Erro ao escrever para o jogador", "player_id", player.ID, "err", err)
+				return // Encerra se houver erro de escrita (conexão perdida ou prazo esgotado); o 'reader' faz a limpeza
+			}
+		case <-pingTicker.C:
+			if err := conn.WriteControl(websocket.PingMessage, hb.ping(), time.Now().Add(config.WriteTimeout)); err != nil {
+				slog.Debug("Erro ao enviar ping", "player_id", player.ID, "err", err)
+				return
+			}
+		case <-ctx.Done():
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "servidor encerrando")
+			conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(config.WriteTimeout))
+			return
 		}
 	}
 }
 
-// reader é uma goroutine que lê mensagens do WebSocket do jogador
-func reader(player *Player) {
+// reader é uma goroutine que lê mensagens do WebSocket do jogador. Os erros enviados a ele saem no idioma lang.
+func reader(gs *engine.GameState, player *engine.Player, sendChan chan []byte, conn *websocket.Conn, lang string, hb *heartbeat) {
 	defer func() {
-		log.Printf("Leitor para o jogador %s encerrando. Realizando limpeza.", player.ID)
-		game.removePlayer(player.ID) // Remove o jogador do jogo (isso fechará sendChan, parando o writer)
+		slog.Debug("Leitor encerrando, realizando limpeza", "player_id", player.ID)
+		if player.Spectator {
+			gs.RemoveSpectator(player.ID)
+		} else {
+			gs.DisconnectPlayer(player.ID, sendChan) // Fecha sendChan (parando o writer) e reserva o jogador para reconexão
+		}
 	}()
 
-	player.conn.SetReadLimit(512) // Define um limite de tamanho para mensagens lidas
+	// Sem pong dentro do prazo, ReadMessage falha com timeout e o jogador é removido pelo defer
+	conn.SetReadDeadline(time.Now().Add(config.PongWait))
+	conn.SetPongHandler(func(payload string) error {
+		hb.pong(payload)
+		return conn.SetReadDeadline(time.Now().Add(config.PongWait))
+	})
 	for {
-		messageType, p, err := player.conn.ReadMessage()
+		// O limite é aplicado aqui, e não com conn.SetReadLimit: este fecha a conexão na hora, sem chance de
+		// avisar o cliente. Lendo um byte além do limite, sabemos que a mensagem não cabe sem ler o resto dela.
+		messageType, r, err := conn.NextReader()
+		var p []byte
+		if err == nil {
+			p, err = io.ReadAll(io.LimitReader(r, int64(config.MaxMessageBytes)+1))
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("Erro de conexão inesperado para jogador %s: %v", player.ID, err)
+				slog.Warn("Erro de conexão inesperado", "room", gs.RoomID, "player_id", player.ID, "err", err)
 			} else {
-				log.Printf("Jogador %s desconectado: %v", player.ID, err)
+				slog.Debug("Conexão fechada pelo cliente", "room", gs.RoomID, "player_id", player.ID, "err", err)
 			}
 			break // Sai do loop em caso de erro (dispara o defer)
 		}
+		if len(p) > config.MaxMessageBytes {
+			slog.Warn("Mensagem do cliente acima do limite, encerrando conexão", "room", gs.RoomID, "player_id", player.ID, "limit", config.MaxMessageBytes)
+			sendError(gs, player.ID, sendChan, lang, ErrCodeMessageTooLarge, config.MaxMessageBytes)
+			break // O defer fecha o sendChan, e o 'writer' entrega o erro antes do frame de fechamento
+		}
 
-		if messageType == websocket.TextMessage {
+		if messageType == websocket.TextMessage || messageType == websocket.BinaryMessage {
+			if messageType == websocket.BinaryMessage { // MessagePack: vira JSON e segue o mesmo caminho
+				if p, err = msgpackToJSON(p); err != nil {
+					slog.Debug("Mensagem MessagePack inválida do cliente", "player_id", player.ID, "err", err)
+					sendErrorText(gs, player.ID, sendChan, ErrCodeMalformedJSON, translate(lang, msgMalformedMsgpack))
+					continue
+				}
+			}
 			var msg ClientMessage
 			if err := json.Unmarshal(p, &msg); err != nil {
-				log.Printf("Erro ao deserializar mensagem de %s: %v", player.ID, err)
+				slog.Debug("Mensagem inválida do cliente", "player_id", player.ID, "err", err)
+				sendError(gs, player.ID, sendChan, lang, ErrCodeMalformedJSON)
+				continue
+			}
+			if !clientActions[msg.Action] {
+				sendError(gs, player.ID, sendChan, lang, ErrCodeUnknownAction, msg.Action)
 				continue
 			}
 
-			if msg.Action == "move" {
-				game.handlePlayerMove(player.ID, msg.Direction)
-			} else if msg.Action == "reset_game_request" && game.GameOver {
-				log.Printf("Jogador %s solicitou reset do jogo.", player.ID)
-				game.initializeItems()
+			if msg.Action == "resync" {
+				gs.SendSnapshot(player.ID, sendChan) // Estado completo só para este cliente, sem esperar o próximo tick
+				continue
+			}
+
+			if player.Spectator {
+				// Espectadores só assistem: movimentos e demais ações são ignorados
+				sendError(gs, player.ID, sendChan, lang, ErrCodeSpectator)
+				continue
+			}
+
+			switch msg.Action {
+			case "move":
+				if err := gs.QueueMove(player.ID, msg.Direction); err != nil {
+					sendMoveError(gs, player.ID, sendChan, lang, err)
+				}
+			case "set_name":
+				gs.SetPlayerName(player.ID, msg.Name)
+			case "chat":
+				line, err := gs.Chat(player.ID, msg.Text)
+				if err != nil {
+					sendChatError(gs, player.ID, sendChan, lang, err)
+					continue
+				}
+				data, _ := json.Marshal(ChatMessage{Type: MsgTypeChat, PlayerID: line.PlayerID, Name: line.Name, Text: line.Text, Time: line.Time})
+				gs.Broadcast(data) // Inclusive para o remetente, que vê a mensagem como os outros (já limpa e cortada)
+			case "reset_game_request":
+				if gs.ResetIfOver() { // Ignorado enquanto a partida não terminou
+					slog.Info("Reset do jogo solicitado", "room", gs.RoomID, "player_id", player.ID, "action", "reset")
+				}
 			}
 		}
 	}
 }
 
-// wsHandler lida com novas conexões WebSocket
+// sendError envia uma mensagem MsgTypeError para o cliente, com o texto do código no idioma lang
+func sendError(gs *engine.GameState, playerID string, sendChan chan []byte, lang string, code string, args ...any) {
+	sendErrorText(gs, playerID, sendChan, code, translate(lang, code, args...))
+}
+
+// sendErrorText envia uma mensagem MsgTypeError com um texto já pronto
+func sendErrorText(gs *engine.GameState, playerID string, sendChan chan []byte, code string, message string) {
+	data, _ := json.Marshal(ServerError{Type: MsgTypeError, Code: code, Message: message})
+	gs.Send(playerID, sendChan, data)
+}
+
+// errorNotice monta um aviso MsgTypeError com o texto da chave key no idioma pedido, para as expulsões do engine
+// (KickPlayer e KickIdle), que o chamam com o idioma de cada conexão
+func errorNotice(code string, key string) func(lang string) []byte {
+	return func(lang string) []byte {
+		data, _ := json.Marshal(ServerError{Type: MsgTypeError, Code: code, Message: translate(lang, key)})
+		return data
+	}
+}
+
+// sendMoveError traduz um erro de engine.GameState.QueueMove no código correspondente
+func sendMoveError(gs *engine.GameState, playerID string, sendChan chan []byte, lang string, err error) {
+	switch {
+	case errors.Is(err, engine.ErrInvalidDirection):
+		sendError(gs, playerID, sendChan, lang, ErrCodeInvalidDirection)
+	case errors.Is(err, engine.ErrGameOver):
+		sendError(gs, playerID, sendChan, lang, ErrCodeGameOver)
+	case errors.Is(err, engine.ErrMoveRateExceeded):
+		sendError(gs, playerID, sendChan, lang, ErrCodeRateLimited)
+	case errors.Is(err, engine.ErrEliminated):
+		sendError(gs, playerID, sendChan, lang, ErrCodeEliminated)
+	default:
+		slog.Debug("Movimento descartado", "room", gs.RoomID, "player_id", playerID, "err", err) // Ex.: conexão antiga de um jogador que já reconectou
+	}
+}
+
+// sendChatError traduz um erro de engine.GameState.Chat no código correspondente
+func sendChatError(gs *engine.GameState, playerID string, sendChan chan []byte, lang string, err error) {
+	switch {
+	case errors.Is(err, engine.ErrChatEmpty):
+		sendError(gs, playerID, sendChan, lang, ErrCodeChatEmpty)
+	case errors.Is(err, engine.ErrChatRateExceeded):
+		sendError(gs, playerID, sendChan, lang, ErrCodeChatRateLimited)
+	default:
+		slog.Debug("Mensagem de chat descartada", "room", gs.RoomID, "player_id", playerID, "err", err)
+	}
+}
+
+// wsHandler lida com novas conexões WebSocket. A sala vem do caminho (/ws/{roomID}) ou de ?room=
 func wsHandler(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("roomID")
+	if roomID == "" {
+		roomID = r.URL.Query().Get("room")
+	}
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	if !validRoomID.MatchString(roomID) {
+		http.Error(w, "ID de sala inválido", http.StatusBadRequest)
+		return
+	}
+	// join cria a sala que ainda não existe (com loop, bots, gravação e a senha de ?password=), então uma requisição
+	// que o upgrade recusaria é barrada antes dele
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "esta rota só aceita conexões WebSocket", http.StatusBadRequest)
+		return
+	}
+	if !upgrader.CheckOrigin(r) {
+		http.Error(w, "origem não permitida", http.StatusForbidden)
+		return
+	}
+	ip := clientIP(r)
+	if bans.banned(ip) { // Antes do upgrade: um IP bloqueado não chega a ocupar uma conexão WebSocket
+		slog.Info("Conexão recusada: IP bloqueado", "ip", ip)
+		http.Error(w, "IP bloqueado", http.StatusForbidden)
+		return
+	}
+	if connLimiter != nil {
+		if ok, wait := connLimiter.allow(ip, time.Now()); !ok {
+			rateLimitedConns.Inc()
+			slog.Info("Conexão recusada: conexões novas rápidas demais", "ip", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+			http.Error(w, "conexões rápidas demais; tente de novo em instantes", http.StatusTooManyRequests)
+			return
+		}
+	}
+	if !acquireConn() {
+		slog.Warn("Conexão recusada: limite de conexões do servidor atingido", "ip", ip, "max_connections", config.MaxConnections)
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "servidor cheio: limite de conexões atingido; tente mais tarde", http.StatusServiceUnavailable)
+		return
+	}
+	handedOff := false // Passa a true quando o reader assume a conexão e, com ela, a liberação da vaga
+	defer func() {
+		if !handedOff {
+			releaseConn() // Senha errada, upgrade que falhou ou sala cheia: a vaga volta na hora
+		}
+	}()
+
+	gs, joined, err := rooms.join(roomID, r.URL.Query().Get("password")) // Também antes do upgrade: sem a senha, nem a conexão é aberta
+	if errors.Is(err, errRoomPassword) {
+		slog.Info("Conexão recusada: senha da sala incorreta", "room", roomID, "ip", ip)
+		http.Error(w, "sala privada: senha ausente ou incorreta", http.StatusForbidden)
+		return
+	}
+	defer joined() // Ao retornar, a conexão já está no GameState (ou desistiu); até lá a sala não é removida
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Falha ao fazer upgrade da conexão para WebSocket: %v", err)
+		slog.Warn("Falha ao fazer upgrade da conexão para WebSocket", "err", err)
 		return
 	}
 
-	playerID := uuid.NewString() // Geração de ID com UUID
-	log.Printf("Novo jogador tentando conectar com ID gerado: %s", playerID)
+	// MessagePack pelo subprotocolo ou por ?format=msgpack; o padrão é JSON. As mensagens recebidas são
+	// aceitas nos dois formatos, conforme o tipo do frame
+	binary := conn.Subprotocol() == SubprotocolMsgpack || r.URL.Query().Get("format") == "msgpack"
+
+	spectating := r.URL.Query().Get("spectate") == "1"
+	lang := negotiateLang(r) // Idioma dos erros desta conexão
+
+	var player *engine.Player
+	var sendChan chan []byte
+	reconnected := false
+	if token := r.URL.Query().Get("token"); token != "" && !spectating {
+		if id, ok := verifyReconnectToken(config.SessionSecret, gs.RoomID, token); ok {
+			player, sendChan, err = gs.ReconnectPlayer(id)
+			if errors.Is(err, engine.ErrBoardFull) { // A célula foi ocupada e não há outra: recusa como uma entrada
+				slog.Info("Sala sem célula livre para a reconexão, conexão recusada", "room", gs.RoomID, "player_id", id)
+				rejectConnection(conn, binary, ErrCodeBoardFull, translate(lang, ErrCodeBoardFull))
+				return
+			}
+			reconnected = player != nil
+		}
+		if !reconnected {
+			slog.Info("Token de reconexão inválido ou expirado, criando novo jogador", "room", gs.RoomID)
+		}
+	}
 
-	player := game.addPlayer(playerID, conn)
+	if player == nil {
+		playerID := uuid.NewString() // Geração de ID com UUID
+		slog.Debug("Nova conexão", "room", gs.RoomID, "player_id", playerID)
+		if spectating {
+			player, sendChan = gs.AddSpectator(playerID)
+		} else {
+			team, _ := strconv.Atoi(r.URL.Query().Get("team"))                              // Equipe opcional via ?team=; sem ela, rodízio
+			player, sendChan, err = gs.AddPlayer(playerID, r.URL.Query().Get("name"), team) // Apelido opcional via ?name=
+			if errors.Is(err, engine.ErrBoardFull) {
+				slog.Info("Sala sem célula livre, conexão recusada", "room", gs.RoomID)
+				rejectConnection(conn, binary, ErrCodeBoardFull, translate(lang, ErrCodeBoardFull))
+				return
+			}
+			if errors.Is(err, engine.ErrRoomFull) {
+				slog.Info("Sala cheia, conexão recusada", "room", gs.RoomID, "max_players", config.MaxPlayers)
+				rejectConnection(conn, binary, ErrCodeRoomFull, translate(lang, ErrCodeRoomFull))
+				return
+			}
+		}
+	}
 
-	go writer(player)
-	go reader(player)
+	gs.SetLang(player.ID, sendChan, lang) // Para os avisos de expulsão, que o engine monta por conexão
+	client := clientConn{gs, player.ID, sendChan}
+	connections.add(ip, client)
+	writers.Add(1)
+	hb := &heartbeat{gs: gs, playerID: player.ID, sendChan: sendChan}
+	go writer(connsCtx, player, conn, sendChan, binary, hb)
+	handedOff = true
+	go func() {
+		reader(gs, player, sendChan, conn, lang, hb) // Retorna em qualquer desconexão: fechamento, erro, expulsão ou shutdown
+		connections.remove(ip, client)
+		releaseConn()
+	}()
 
-	// Enviar uma mensagem inicial de "boas-vindas" com o ID do jogador
-	welcomeMsg := map[string]string{"type": "welcome", "playerId": player.ID}
+	// Enviar uma mensagem inicial de "boas-vindas" com o ID do jogador e, para jogadores, o token de reconexão
+	welcomeMsg := map[string]interface{}{"type": MsgTypeWelcome, "playerId": player.ID, "name": player.Name, "roomId": gs.RoomID, "tickMs": gs.TickMs, "spectator": player.Spectator, "reconnected": reconnected, "lang": lang, "texts": clientTexts(lang)}
+	if !player.Spectator {
+		welcomeMsg["token"] = signReconnectToken(config.SessionSecret, gs.RoomID, player.ID)
+	}
 	welcomeData, _ := json.Marshal(welcomeMsg)
-	select {
-	case player.sendChan <- welcomeData:
-	default:
-		log.Printf("Não foi possível enviar mensagem de boas-vindas para %s", player.ID)
+	if !gs.Send(player.ID, sendChan, welcomeData) {
+		slog.Warn("Não foi possível enviar a mensagem de boas-vindas", "room", gs.RoomID, "player_id", player.ID)
 	}
+	gs.SendSnapshot(player.ID, sendChan) // O cliente desenha o tabuleiro sem esperar o próximo tick
 }
 
-// gameLoop é a goroutine principal do jogo que periodicamente envia o estado
-func gameLoop() {
-	ticker := time.NewTicker(GameTickDelay)
+// acquireConn reserva a vaga de uma conexão nova em openConns, recusando-a se o servidor já tem MAX_CONNECTIONS
+// abertas. Cada reserva aceita precisa de exatamente um releaseConn.
+func acquireConn() bool {
+	if n := openConns.Add(1); config.MaxConnections > 0 && n > int64(config.MaxConnections) {
+		openConns.Add(-1)
+		return false
+	}
+	return true
+}
+
+// releaseConn devolve a vaga reservada por acquireConn
+func releaseConn() {
+	openConns.Add(-1)
+}
+
+// rejectConnection envia um erro e fecha a conexão com um frame de fechamento "tente mais tarde", antes de
+// existirem as goroutines 'reader' e 'writer'
+func rejectConnection(conn *websocket.Conn, binary bool, code string, message string) {
+	defer conn.Close()
+
+	messageType := websocket.TextMessage
+	data, _ := json.Marshal(ServerError{Type: MsgTypeError, Code: code, Message: message})
+	if binary {
+		messageType = websocket.BinaryMessage
+		data, _ = jsonToMsgpack(data)
+	}
+	conn.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+	if err := conn.WriteMessage(messageType, data); err != nil {
+		return
+	}
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, code)
+	conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(config.WriteTimeout))
+}
+
+// statsHandler retorna em JSON um resumo somente leitura de todas as salas, para placares externos
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := rooms.stats()
+	ratings := make(map[string]float64) // Rating de cada apelido presente nas salas (bots não têm)
+	for _, room := range stats {
+		for _, s := range room.Scores {
+			if s.Name != "" && !s.Bot {
+				ratings[s.Name] = leaderboard.rating(s.Name)
+			}
+		}
+	}
+	response := struct {
+		UptimeSeconds  int64                       `json:"uptimeSeconds"`
+		Connections    int64                       `json:"connections"`              // Conexões WebSocket abertas no servidor
+		MaxConnections int                         `json:"maxConnections,omitempty"` // MAX_CONNECTIONS, se houver limite
+		Rooms          map[string]engine.RoomStats `json:"rooms"`
+		Ratings        map[string]float64          `json:"ratings"`
+	}{
+		UptimeSeconds:  int64(time.Since(startedAt) / time.Second),
+		Connections:    openConns.Load(),
+		MaxConnections: config.MaxConnections,
+		Rooms:          stats,
+		Ratings:        ratings,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Erro ao enviar estatísticas", "err", err)
+	}
+}
+
+// roomsHandler lista as salas ativas (o lobby), para o cliente escolher onde entrar
+func roomsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := struct {
+		Rooms []roomInfo `json:"rooms"`
+	}{
+		Rooms: rooms.lobby(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Erro ao enviar a lista de salas", "err", err)
+	}
+}
+
+// indexHandler serve o cliente HTML com a configuração atual do servidor
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var page bytes.Buffer
+	err := indexTemplate.Execute(&page, clientConfig{
+		BoardWidth:   config.BoardWidth,
+		BoardHeight:  config.BoardHeight,
+		TickMs:       int(config.BroadcastInterval() / time.Millisecond),
+		ItemSymbol:   config.ItemSymbol,
+		PlayerSymbol: config.PlayerSymbol,
+	})
+	if err != nil {
+		slog.Error("Erro ao renderizar o cliente HTML", "err", err)
+		http.Error(w, "erro interno", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
+
+// gameLoop é a goroutine de cada sala que simula a partida e envia o estado, até que ctx seja cancelado.
+// A simulação anda em passos fixos de cfg.TickDelay, contados num acumulador a partir do tempo real entre dois
+// despertares, e o estado sai a cada cfg.BroadcastDelay; as duas cadências são independentes, e o ticker
+// desperta no ritmo da mais rápida. lastTick recebe o momento de cada despertar, para os health checks. Com
+// cfg.IdleTickDelay, uma sala sem conexões passa a dar um passo nesse ritmo, sem broadcast, e volta ao normal
+// assim que alguém se conecta.
+func gameLoop(ctx context.Context, gs *engine.GameState, cfg Config, lastTick *atomic.Int64) {
+	step, flush := cfg.TickDelay, cfg.BroadcastInterval()
+	wake := min(step, flush)
+	ticker := time.NewTicker(wake)
 	defer ticker.Stop()
+	idle := false
+	last := time.Now()
+	var simAcc, flushAcc time.Duration // Tempo real ainda não consumido pela simulação e pelos broadcasts
+
+	var respawnC <-chan time.Time // Canal nulo (nunca dispara) quando o modo contínuo está desligado
+	if cfg.RespawnInterval > 0 {
+		respawnTicker := time.NewTicker(cfg.RespawnInterval)
+		defer respawnTicker.Stop()
+		respawnC = respawnTicker.C
+	}
+
+	// Aviso entregue a quem for removido por inatividade, logo antes de a conexão ser fechada, no idioma dela
+	idleNotice := errorNotice(ErrCodeIdle, ErrCodeIdle)
 
 	for {
-		<-ticker.C
-		game.broadcastGameState()
+		select {
+		case now := <-ticker.C:
+			elapsed := now.Sub(last)
+			last = now
+			if idle {
+				gs.ProcessTick() // No ritmo ocioso, um passo por despertar, sem acumular atraso
+				gs.KickIdle(idleNotice)
+			} else {
+				// Meia espera de tolerância: sem ela, o jitter do ticker alternaria entre zero e dois passos
+				simAcc += elapsed
+				steps := 0
+				for simAcc+wake/2 >= step && steps < MaxCatchUpSteps {
+					gs.ProcessTick()
+					simAcc -= step
+					steps++
+				}
+				if steps == MaxCatchUpSteps && simAcc+wake/2 >= step {
+					slog.Warn("gameLoop atrasado, passos de simulação descartados", "room", gs.RoomID, "behind", simAcc)
+					simAcc = 0 // Recuperar tudo só atrasaria mais
+				}
+				if steps > 0 {
+					gs.KickIdle(idleNotice) // Antes do broadcast, que já sai sem os jogadores removidos
+				}
+				flushAcc += elapsed
+				if flushAcc+wake/2 >= flush {
+					gs.BroadcastGameState()
+					flushAcc = max(flushAcc-flush, 0)
+				}
+			}
+			lastTick.Store(time.Now().UnixNano())
+			if cfg.IdleTickDelay > 0 && !idle {
+				select { // Descarta sinais antigos antes de conferir, para não perder uma conexão que chegue logo depois
+				case <-gs.Wake():
+				default:
+				}
+				if !gs.HasConnections() {
+					idle = true
+					ticker.Reset(cfg.IdleTickDelay)
+					slog.Debug("Sala sem conexões, ticks em ritmo ocioso", "room", gs.RoomID, "tick", cfg.IdleTickDelay)
+				}
+			}
+		case <-gs.Wake():
+			if idle {
+				idle = false
+				last, simAcc, flushAcc = time.Now(), 0, 0
+				ticker.Reset(wake) // O primeiro passo normal sai em até wake; o estado inicial já foi enviado na conexão
+				slog.Debug("Conexão na sala, ticks em ritmo normal", "room", gs.RoomID, "tick", step, "broadcast", flush)
+			}
+		case <-respawnC:
+			gs.RespawnItem()
+		case <-ctx.Done():
+			slog.Info("Loop do jogo encerrado", "room", gs.RoomID)
+			return
+		}
 	}
 }
 
+// waitWithTimeout espera o WaitGroup terminar, desistindo após o timeout
+func waitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// newMux monta as rotas do servidor sem começar a escutar, para que main (ou um httptest.Server) as sirva. Usa um
+// mux próprio: o DefaultServeMux recebe os handlers de net/http/pprof, que não devem ficar na porta pública. Os
+// handlers dependem de config e rooms, que precisam estar prontos antes da primeira requisição.
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", wsHandler)                   // Endpoint WebSocket (sala padrão ou ?room=)
+	mux.HandleFunc("/ws/{roomID}", wsHandler)          // Endpoint WebSocket de uma sala específica
+	mux.HandleFunc("/", indexHandler)                  // Servir o cliente HTML
+	mux.Handle("/metrics", promhttp.Handler())         // Métricas no formato do Prometheus
+	mux.HandleFunc("/stats", statsHandler)             // Resumo das salas em JSON
+	mux.HandleFunc("/rooms", roomsHandler)             // Lista das salas ativas (lobby)
+	mux.HandleFunc("/leaderboard", leaderboardHandler) // Melhores partidas de todos os tempos
+	mux.HandleFunc("/events", eventsHandler)           // Eventos das salas via Server-Sent Events
+	mux.HandleFunc("/healthz", healthHandler)          // Liveness e readiness para orquestradores
+	mux.HandleFunc("/readyz", healthHandler)
+	mux.HandleFunc("/admin", adminPageHandler)                                  // Painel de administração (HTML)
+	mux.HandleFunc("/admin/reset", adminOnly(adminResetHandler))                // Reinicia a partida de uma sala (ADMIN_TOKEN)
+	mux.HandleFunc("/admin/kick", adminOnly(adminKickHandler))                  // Expulsa um jogador ou espectador
+	mux.HandleFunc("/admin/ban-ip", adminOnly(adminBanHandler))                 // Bloqueia um IP e expulsa suas conexões
+	mux.HandleFunc("/admin/announce", adminOnly(adminAnnounceHandler))          // Aviso para todos os clientes conectados
+	mux.HandleFunc("/admin/room-password", adminOnly(adminRoomPasswordHandler)) // Torna uma sala privada (ou pública)
+	return mux
+}
+
 func main() {
-	rand.Seed(time.Now().UnixNano())
-	game.initializeItems()
+	if err := setupLogging(); err != nil {
+		log.Fatalf("Configuração inválida: %v", err)
+	}
+	if path := os.Getenv("REPLAY_FILE"); path != "" { // Modo de reprodução: reexecuta a gravação e sai, sem abrir o servidor
+		if err := runReplay(path, os.Stdout); err != nil {
+			log.Fatalf("Erro ao reproduzir %s: %v", path, err)
+		}
+		return
+	}
 
-	http.HandleFunc("/ws", wsHandler)                                   // Endpoint WebSocket
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { // Servir o cliente HTML
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
+	var err error
+	config, err = loadConfig()
+	if err != nil {
+		log.Fatalf("Configuração inválida: %v", err)
+	}
+	slog.Info("Tabuleiro configurado", "width", config.BoardWidth, "height", config.BoardHeight, "items", config.NumItems)
+	if config.ItemDensity > 0 {
+		slog.Info("Quantidade de itens proporcional ao tabuleiro", "item_density", config.ItemDensity)
+	}
+	slog.Info("Tick do jogo configurado", "tick", config.TickDelay)
+	if config.BroadcastDelay > 0 {
+		slog.Info("Broadcasts com cadência própria", "broadcast", config.BroadcastDelay)
+	}
+	if config.IdleTickDelay > 0 {
+		slog.Info("Salas sem conexões ticam em ritmo ocioso", "idle_tick", config.IdleTickDelay)
+	}
+	if config.ObstacleCount > 0 || config.IceCount > 0 || config.TeleportPairs > 0 {
+		slog.Info("Paredes em cada sala", "obstacles", config.ObstacleCount, "ice", config.IceCount, "teleport_pairs", config.TeleportPairs, "obstacle_seed", config.ObstacleSeed)
+	}
+	if config.RandomSeed != 0 {
+		slog.Info("Sorteio de itens e posições reproduzível", "game_seed", config.RandomSeed)
+	}
+	if config.GameDuration > 0 {
+		slog.Info("Partidas com tempo limite", "duration", config.GameDuration, "overtime", config.Overtime)
+	}
+	if config.SpeedBoost > 0 {
+		slog.Info("Power-up de velocidade ligado", "duration", config.SpeedBoost)
+	}
+	if config.ComboWindow > 0 && config.ComboMax > 1 {
+		slog.Info("Multiplicador de sequência ligado", "window", config.ComboWindow, "max", config.ComboMax)
+	}
+	if config.ItemSymbol != DefaultItemSymbol || config.PlayerSymbol != "" || len(config.ItemSymbols) > 0 {
+		slog.Info("Símbolos do cliente personalizados", "item", config.ItemSymbol, "player", config.PlayerSymbol, "kinds", config.ItemSymbols)
+	}
+	if config.GoldenChance > 0 {
+		slog.Info("Diamante dourado ligado", "chance", config.GoldenChance, "lifetime", config.GoldenLifetime, "value", config.GoldenValue)
+	}
+	if config.MovesPerTick > 1 {
+		slog.Info("Vários movimentos por tick", "moves_per_tick", config.MovesPerTick, "move_interval", config.MoveInterval)
+	}
+	if config.TieBreak {
+		slog.Info("Empates desfeitos por quem atingiu a pontuação primeiro")
+	}
+	if config.Trail {
+		slog.Info("Modo rastro: cada item coletado aumenta o rastro do jogador, e bater num rastro elimina")
+	}
+	if config.Wrap {
+		slog.Info("Tabuleiro toroidal: sair por uma borda entra pela oposta")
+	}
+	if config.TargetScore > 0 {
+		slog.Info("Vence quem atingir a meta primeiro", "target_score", config.TargetScore)
+	}
+	if config.AutoRestart > 0 {
+		slog.Info("Nova partida começa automaticamente após o fim da anterior", "delay", config.AutoRestart)
+	}
+	if config.RespawnInterval > 0 {
+		slog.Info("Modo contínuo ligado", "respawn_interval", config.RespawnInterval, "respawn_target", config.RespawnTarget)
+	}
+	slog.Info("Limite de movimentos por jogador", "move_interval", config.MoveInterval)
+	if config.SlowClientLimit > 0 {
+		slog.Info("Clientes lentos são desconectados após mensagens descartadas seguidas", "slow_client_limit", config.SlowClientLimit)
+	}
+	if config.Teams > 0 {
+		slog.Info("Modo de equipes ligado", "teams", config.Teams)
+	}
+	if config.StartScore != 0 || config.ScoreFloor != 0 || config.NoScoreFloor {
+		slog.Info("Pontuação inicial e mínima ajustadas", "start_score", config.StartScore, "score_floor", config.ScoreFloor, "no_score_floor", config.NoScoreFloor)
+	}
+	if config.Rounds > 1 {
+		slog.Info("Modo de rodadas ligado", "rounds", config.Rounds, "round_break", config.AutoRestart)
+	}
+	if config.RecordDir != "" {
+		if err := os.MkdirAll(config.RecordDir, 0o755); err != nil {
+			log.Fatalf("Erro ao criar RECORD_DIR: %v", err)
 		}
-		html := `
-<!DOCTYPE html>
-<html lang="pt-BR">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Go Diamond Collector</title>
-    <style>
-        :root {
-            --primary-bg: #f4f7f6;
-            --secondary-bg: #ffffff;
-            --accent-color: #3498db; /* Azul suave */
-            --accent-hover: #2980b9;
-            --text-color: #333333;
-            --border-color: #dddddd;
-            --item-bg: #f1c40f; /* Dourado para itens */
-            --player-bg: #87ceeb; /* Azul céu para jogador */
-            --self-player-bg: #5dade2; /* Azul mais forte para jogador local */
-            --shadow-color: rgba(0,0,0,0.1);
-        }
-        body { 
-            font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; 
-            display: flex; 
-            flex-direction: column; 
-            align-items: center; 
-            margin: 0; 
-            padding: 20px; 
-            background-color: var(--primary-bg); 
-            color: var(--text-color);
-            line-height: 1.6;
-        }
-        h1 { 
-            margin-bottom: 0.5em;
-            font-size: 2.2em; 
-            color: var(--accent-color);
-            font-weight: 300;
-        }
-        #game-description {
-            background-color: var(--secondary-bg);
-            padding: 15px 20px;
-            border-radius: 8px;
-            margin-bottom: 25px;
-            max-width: 700px;
-            box-shadow: 0 2px 4px var(--shadow-color);
-            text-align: left;
-        }
-        #game-description h2 {
-            margin-top: 0;
-            color: var(--accent-color);
-            font-size: 1.4em;
-            font-weight: 400;
-            border-bottom: 1px solid var(--border-color);
-            padding-bottom: 0.5em;
-            margin-bottom: 0.8em;
-        }
-        #game-description p, #game-description ul {
-            font-size: 0.95em;
-            margin-bottom: 0.8em;
-        }
-        #game-description ul {
-            list-style-type: disc;
-            padding-left: 20px;
-        }
-        #game-description strong {
-            color: var(--accent-color);
-        }
-        #game-container { 
-            display: flex; 
-            flex-wrap: wrap; 
-            gap: 25px; 
-            justify-content: center;
-            width: 100%;
-        }
-        #board-wrapper { 
-            width: auto; /* Ajusta-se ao conteúdo */
-            max-width: 100%; /* Não ultrapassa a tela */
-            overflow-x: auto; 
-            display: flex;
-            justify-content: center; 
-            padding: 5px; /* Pequeno padding para não cortar a borda do tabuleiro */
-            background-color: var(--secondary-bg);
-            border-radius: 8px;
-            box-shadow: 0 2px 4px var(--shadow-color);
-        }
-        #board {
-            border-collapse: collapse;
-            font-family: monospace;
-            table-layout: fixed; 
-            border: 1px solid var(--border-color); /* Borda mais suave */
-        }
-        #board td {
-            border: 1px solid #e7e7e7; /* Linhas de grade ainda mais suaves */
-            width: 30px;   
-            height: 30px;  
-            text-align: center;
-            vertical-align: middle;
-            font-size: 16px; 
-            overflow: hidden; 
-            box-sizing: border-box; 
-            white-space: nowrap; 
-            line-height: 28px; 
-        }
-        .player { background-color: var(--player-bg); border-radius: 50%; }
-        .item { background-color: var(--item-bg); color: white; border-radius: 3px; animation: pulseItem 1.5s infinite ease-in-out; }
-        .self { font-weight: bold; background-color: var(--self-player-bg); box-shadow: 0 0 5px 3px var(--accent-hover); } 
-        @keyframes pulseItem {
-            0% { transform: scale(0.9); }
-            50% { transform: scale(1.05); }
-            100% { transform: scale(0.9); }
-        }
-        #info { 
-            text-align: left; 
-            padding: 20px; 
-            border: 1px solid var(--border-color); 
-            background-color: var(--secondary-bg); 
-            border-radius: 8px; 
-            min-width: 280px; 
-            box-shadow: 0 2px 4px var(--shadow-color);
-        }
-        #info h3 { 
-            margin-top: 0; 
-            margin-bottom: 10px; 
-            font-size: 1.3em;
-            color: var(--accent-color);
-            font-weight: 400;
-        }
-        #info pre { 
-            margin-top: 5px; 
-            margin-bottom: 15px; 
-            white-space: pre-wrap; 
-            background-color: #f9f9f9; 
-            padding: 10px;
-            border-radius: 4px;
-            font-size: 0.9em;
-            border: 1px solid #efefef;
-        }
-        #controls { 
-            margin-top: 25px; 
-            text-align: center; 
-            width: 100%; 
-        }
-        #controls button { 
-            padding: 12px 20px; 
-            margin: 8px; 
-            font-size: 1.05em; 
-            cursor: pointer; 
-            border: none; 
-            border-radius: 5px;
-            background-color: var(--accent-color); 
-            color: white;
-            transition: background-color 0.2s ease, transform 0.1s ease;
-            min-width: 80px; /* Largura mínima para botões de controle */
-        }
-        #controls button:hover { background-color: var(--accent-hover); }
-        #controls button:active { transform: scale(0.95); }
-
-        #log-container { width: 100%; max-width: 700px; margin-top:25px; }
-        #log { 
-            font-size:0.85em; 
-            max-height: 120px; 
-            overflow-y: scroll; 
-            border: 1px solid var(--border-color); 
-            padding:10px; 
-            background-color: var(--secondary-bg);
-            white-space: pre-wrap; 
-            word-break: break-all;
-            border-radius: 4px;
-            font-family: monospace;
-        }
-        #game-over-msg { 
-            padding: 15px;
-            background-color: #ffdddd;
-            border: 1px solid #ffaaaa;
-            color: #d8000c; 
-            font-weight:bold; 
-            margin-bottom: 15px; 
-            font-size: 1.2em; 
-            border-radius: 5px;
-            text-align: center;
-            display: none; /* Escondido por padrão, JS mostra */
-        }
-        #resetButton {
-            background-color: #5bc0de; /* Azul informativo */
-        }
-        #resetButton:hover {
-            background-color: #31b0d5;
-        }
-
-        /* === Media Queries para Responsividade === */
-        @media (max-width: 768px) {
-            body { padding: 15px; }
-            h1 { font-size: 1.8em; }
-            #game-description { width: 95%; padding: 15px; margin-bottom: 20px;}
-            #game-description h2 { font-size: 1.3em; }
-            #game-description p, #game-description ul { font-size: 0.9em; }
-
-            #game-container {
-                flex-direction: column; 
-                align-items: center;
-                gap: 20px;
-            }
-            #board-wrapper { margin-bottom: 20px; }
-            #board td {
-                width: 26px;  
-                height: 26px;
-                font-size: 14px; 
-                line-height: 24px;
-            }
-            #info {
-                width: 90%; 
-                max-width: 480px; 
-                min-width: unset;
-                padding: 15px;
-            }
-             #info h3 { font-size: 1.2em; }
-
-            #controls {
-                display: grid;
-                grid-template-columns: 1fr 1fr 1fr;
-                grid-template-rows: auto auto auto;
-                gap: 10px; 
-                max-width: 250px; 
-                margin-left: auto;
-                margin-right: auto;
-                padding: 15px;
-                background-color: var(--secondary-bg);
-                border-radius: 10px;
-                box-shadow: 0 2px 4px var(--shadow-color);
-            }
-            #controls button {
-                margin: 0; 
-                width: 100%; 
-                height: 55px; 
-                font-size: 1em;
-                display: flex; /* Para centralizar ícone/texto */
-                align-items: center;
-                justify-content: center;
-            }
-            #btn-up    { grid-column: 2; grid-row: 1; }
-            #btn-left  { grid-column: 1; grid-row: 2; }
-            #btn-placeholder { grid-column: 2; grid-row: 2; visibility: hidden; } 
-            #btn-right { grid-column: 3; grid-row: 2; }
-            #btn-down  { grid-column: 2; grid-row: 3; }
-
-            #controls br { display: none; } 
-        }
-
-        @media (max-width: 480px) {
-            h1 { font-size: 1.6em; }
-            #game-description h2 { font-size: 1.2em; }
-            #board td {
-                width: 22px;  
-                height: 22px;
-                font-size: 12px;
-                line-height: 20px;
-            }
-            #controls {
-                max-width: 220px; 
-                gap: 8px;
-                padding: 10px;
-            }
-            #controls button {
-                height: 50px;
-                font-size: 0.95em;
-            }
-            #info { width: 95%; padding: 12px; }
-             #info h3 { font-size: 1.1em; }
-             #info pre { font-size: 0.85em; padding: 8px;}
-        }
-    </style>
-</head>
-<body>
-    <h1>Go Diamond Collector</h1>
-
-    <div id="game-description">
-        <h2>Como Jogar:</h2>
-        <p><strong>Objetivo:</strong> Ser o jogador com mais diamantes (💎) coletados quando todos os itens do tabuleiro acabarem!</p>
-        <ul>
-            <li>Use as teclas <strong>W, A, S, D</strong> ou as <strong>Setas Direcionais</strong> do teclado para se mover.</li>
-            <li>Em dispositivos móveis, use os <strong>botões de controle</strong> na tela.</li>
-            <li>Passe por cima de um diamante (💎) para coletá-lo e aumentar sua pontuação.</li>
-            <li>Fique de olho na pontuação dos outros jogadores!</li>
-            <li>O jogo termina quando não houver mais diamantes. O jogador com mais diamantes vence. Boa sorte!</li>
-        </ul>
-    </div>
-
-    <div id="game-container">
-        <div id="board-wrapper"> 
-            <table id="board"></table>
-        </div>
-        <div id="info">
-            <h3>Seu ID: <span id="my-id">---</span></h3>
-            <h3>Pontuações:</h3>
-            <pre id="scores"></pre>
-            <div id="game-over-msg"></div>
-            <button id="resetButton" style="display:none;">Resetar Jogo</button>
-        </div>
-    </div>
-    <div id="controls">
-        <button id="btn-up" onclick="sendMove('up')" title="Mover para Cima (W ou Seta para Cima)">&#x25B2;</button> <br> 
-        <button id="btn-left" onclick="sendMove('left')" title="Mover para Esquerda (A ou Seta para Esquerda)">&#x25C0;</button> <span id="btn-placeholder"></span> 
-        <button id="btn-right" onclick="sendMove('right')" title="Mover para Direita (D ou Seta para Direita)">&#x25B6;</button> <br> 
-        <button id="btn-down" onclick="sendMove('down')" title="Mover para Baixo (S ou Seta para Baixo)">&#x25BC;</button> </div>
-    <div id="log-container">
-      <h4>Log de Eventos (Debug):</h4>
-      <pre id="log"></pre>
-    </div>
-
-    <script>
-        const boardElement = document.getElementById('board');
-        const scoresElement = document.getElementById('scores');
-        const logElement = document.getElementById('log'); // Log na tela
-        const myIdElement = document.getElementById('my-id');
-        const gameOverMsgElement = document.getElementById('game-over-msg');
-        const resetButton = document.getElementById('resetButton');
-
-        const wsProtocol = window.location.protocol === "https:" ? "wss:" : "ws:";
-        const ws = new WebSocket(wsProtocol + "//" + window.location.host + "/ws");
-        let myPlayerId = null;
-
-        function clientLog(message) {
-            console.log(message); // Log no console do navegador
-            const now = new Date();
-            const timeString = now.getHours().toString().padStart(2, '0') + ':' + 
-                               now.getMinutes().toString().padStart(2, '0') + ':' + 
-                               now.getSeconds().toString().padStart(2, '0');
-            if (logElement.textContent.length > 2000) { 
-                logElement.textContent = logElement.textContent.substring(0,1500);
-            }
-            logElement.textContent = timeString + ": " + message + "\n" + logElement.textContent;
-        }
-
-        function drawBoard(gameState) {
-            boardElement.innerHTML = ''; 
-            for (let y = 0; y < gameState.boardHeight; y++) {
-                const row = boardElement.insertRow();
-                for (let x = 0; x < gameState.boardWidth; x++) {
-                    const cell = row.insertCell();
-                    cell.id = 'cell-' + x + '-' + y;
-                }
-            }
-
-            for (const key in gameState.items) {
-                const item = gameState.items[key];
-                const cell = document.getElementById('cell-' + item.pos.x + '-' + item.pos.y);
-                if (cell) {
-                    cell.classList.add('item');
-                    cell.textContent = '💎'; 
-                }
-            }
-            
-            let scoresHTML = "";
-            for (const id in gameState.players) {
-                const player = gameState.players[id];
-                const cell = document.getElementById('cell-' + player.pos.x + '-' + player.pos.y);
-                if (cell) {
-                    cell.classList.add('player');
-                    cell.textContent = player.id.substring(0,2); 
-                    if (player.id === myPlayerId) {
-                        cell.classList.add('self');
-                    }
-                }
-                scoresHTML += player.id.substring(0,8) + "...: " + player.score + "\n";
-            }
-            scoresElement.textContent = scoresHTML;
-
-            if (gameState.gameOver) {
-                gameOverMsgElement.textContent = "FIM DE JOGO! Vencedor(es): " + gameState.winnerId;
-                resetButton.style.display = 'inline-block'; // Mostrar botão
-            } else {
-                gameOverMsgElement.textContent = "";
-                resetButton.style.display = 'none'; // Esconder botão
-            }
-        }
-
-        ws.onopen = function(event) {
-            clientLog("Conectado ao servidor WebSocket.");
-        };
-
-        ws.onmessage = function(event) {
-            const data = JSON.parse(event.data);
-            
-            if (data.type === "welcome") {
-                myPlayerId = data.playerId;
-                myIdElement.textContent = myPlayerId.substring(0,8) + "..."; // Mostra ID abreviado
-                clientLog("Meu ID de jogador definido: " + myPlayerId);
-                return; 
-            }
-            drawBoard(data);
-        };
-
-        ws.onclose = function(event) {
-            clientLog("Desconectado do servidor WebSocket. Código: " + event.code + " Razão: " + event.reason);
-            gameOverMsgElement.textContent = "DESCONECTADO DO SERVIDOR";
-            gameOverMsgElement.style.display = 'block';
-        };
-
-        ws.onerror = function(error) {
-            clientLog("Erro no WebSocket: " + JSON.stringify(error));
-        };
-
-        function sendMove(direction) {
-            if (!ws || ws.readyState !== WebSocket.OPEN) {
-                clientLog("WebSocket não está aberto para enviar movimento.");
-                return;
-            }
-            if (!myPlayerId) {
-                clientLog("Meu ID de jogador ainda não está definido. Não é possível enviar movimento.");
-                return;
-            }
-            ws.send(JSON.stringify({ action: 'move', direction: direction }));
-        }
-        
-        resetButton.onclick = function() {
-            if (!ws || ws.readyState !== WebSocket.OPEN) return;
-            ws.send(JSON.stringify({ action: 'reset_game_request' }));
-            clientLog("Solicitação de reset do jogo enviada.");
-        };
-
-        document.addEventListener('keydown', function(event) {
-            if (!ws || ws.readyState !== WebSocket.OPEN) return;
-            let direction = null;
-            switch (event.key) {
-                case 'w': case 'W': case 'ArrowUp': direction = 'up'; break;
-                case 's': case 'S': case 'ArrowDown': direction = 'down'; break;
-                case 'a': case 'A': case 'ArrowLeft': direction = 'left'; break;
-                case 'd': case 'D': case 'ArrowRight': direction = 'right'; break;
-            }
-            if (direction) {
-                sendMove(direction);
-                event.preventDefault();
-            }
-        });
-    </script>
-</body>
-</html>
-`
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		fmt.Fprint(w, html)
-	})
+		slog.Info("Salas gravadas para replay", "record_dir", config.RecordDir)
+	}
+	if config.MaxPlayers > 0 {
+		slog.Info("Limite de jogadores por sala", "max_players", config.MaxPlayers)
+	}
+	if config.AdminToken == "" {
+		slog.Info("ADMIN_TOKEN não definida, endpoints /admin desligados")
+	}
+	if config.ViewRadius > 0 {
+		slog.Info("Modo de visão limitada ligado", "view_radius", config.ViewRadius)
+	}
+	if config.SendBuffer != engine.DefaultSendBuffer {
+		slog.Info("Fila de envio por conexão ajustada", "send_buffer", config.SendBuffer)
+	}
+	if config.MaxMessageBytes != DefaultMaxMessage {
+		slog.Info("Tamanho máximo das mensagens dos clientes ajustado", "max_message_bytes", config.MaxMessageBytes)
+	}
+	if config.MaxConnections > 0 {
+		slog.Info("Limite de conexões do servidor", "max_connections", config.MaxConnections)
+	}
+	if config.ConnRate > 0 {
+		connLimiter = newConnLimiter(config.ConnRate, config.ConnBurst)
+	} else {
+		slog.Info("Limite de conexões novas por IP desligado")
+	}
+	if config.TrustedProxyHops > 0 {
+		slog.Info("IP do cliente lido do cabeçalho dos proxies", "header", config.ProxyHeader, "trusted_proxy_hops", config.TrustedProxyHops)
+	}
+	if config.IdleTimeout > 0 {
+		slog.Info("Jogadores inativos serão removidos", "idle_timeout", config.IdleTimeout)
+	}
+	if config.BotCount > 0 {
+		slog.Info("Bots ligados", "bots", config.BotCount, "bot_threshold", config.BotThreshold, "bot_skill", config.BotSkill)
+	}
+	slog.Info("Heartbeat configurado", "ping_interval", config.PingInterval, "pong_wait", config.PongWait)
+	slog.Info("Prazo de escrita nas conexões", "write_timeout", config.WriteTimeout)
+	upgrader.EnableCompression = config.Compression
+	if len(config.Origins) > 0 {
+		slog.Info("Conexões WebSocket aceitas só das origens permitidas", "origins", config.Origins)
+	}
+	if config.Compression {
+		slog.Info("Compressão permessage-deflate oferecida aos clientes", "level", config.CompressLevel, "min_bytes", config.CompressMin)
+	}
+
+	config.Metrics = prometheusMetrics{}
+	if leaderboard, err = loadLeaderboard(config.Leaderboard, float64(config.RatingK)); err != nil {
+		log.Fatalf("Erro ao carregar o histórico de partidas: %v", err)
+	}
+	if config.Leaderboard != "" {
+		slog.Info("Histórico de partidas salvo em disco", "file", config.Leaderboard)
+	}
+	eventHub = newEventHub(config.SSEClients)
+	sinks := engine.MultiSink{leaderboard.start(), eventHub.start()}
+	if config.WebhookURL != "" {
+		slog.Info("Fins de jogo serão enviados por webhook", "webhook_host", webhookHost(config.WebhookURL))
+		sinks = append(sinks, startWebhook(config.WebhookURL))
+	}
+	config.Events = sinks
+	rooms = newRoomManager(config)
+	if config.RoomTTL > 0 {
+		go rooms.reapEmpty(config.RoomTTL)
+		slog.Info("Salas vazias são removidas", "room_ttl", config.RoomTTL)
+	}
+	if config.StateFile != "" {
+		if os.Getenv("SESSION_SECRET") == "" {
+			slog.Warn("STATE_FILE sem SESSION_SECRET: os tokens de reconexão não valem depois de um reinício, então os jogadores restaurados não conseguem voltar")
+		}
+		if _, err := rooms.restoreState(config.StateFile); err != nil {
+			log.Fatalf("Erro ao restaurar o estado das salas: %v", err)
+		}
+		if config.StateInterval > 0 {
+			rooms.loops.Add(1) // O shutdown espera a gravação em andamento antes de fazer a última
+			go func() {
+				defer rooms.loops.Done()
+				rooms.persistState(config.StateFile, config.StateInterval)
+			}()
+		}
+		slog.Info("Estado das salas salvo em disco", "file", config.StateFile, "interval", config.StateInterval)
+	}
+	rooms.getOrCreate(DefaultRoomID)
+	if config.PprofAddr != "" {
+		startPprof(config.PprofAddr)
+	}
+
+	mux := newMux()
 
 	// Determina a porta para escutar
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080" // Porta padrão se PORT não estiver definida
-		log.Printf("Variável PORT não definida, usando porta padrão: %s", port)
+		slog.Info("Variável PORT não definida, usando porta padrão", "port", port)
 	}
 
-	go gameLoop() // Inicia o loop principal do jogo em uma goroutine separada
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+
+	// Encerramento gracioso: para os loops das salas, fecha as conexões WebSocket (que o Shutdown não acompanha) e então o servidor HTTP
+	shutdownDone := make(chan struct{})
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigChan
+		slog.Info("Sinal recebido, encerrando servidor", "signal", sig.String())
+		shuttingDown.Store(true) // /readyz e /healthz passam a falhar antes de as conexões serem fechadas
+
+		rooms.shutdown(ShutdownTimeout)
+		eventHub.close() // Os streams de /events não terminam sozinhos, e o Shutdown esperaria por eles
+		if !waitWithTimeout(&writers, ShutdownTimeout) {
+			slog.Warn("Tempo esgotado esperando os escritores encerrarem as conexões; derrubando as restantes")
+			closeConns()
+			waitWithTimeout(&writers, ShutdownTimeout)
+		}
+		closeConns()
+
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Erro ao encerrar servidor HTTP", "err", err)
+		}
+		close(shutdownDone)
+	}()
 
-	log.Printf("Servidor Go Diamond Collector iniciando na porta :%s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Erro ao iniciar servidor ListenAndServe: %v", err) // Usar log.Fatalf para sair em caso de erro fatal
+	if config.TLSCertFile != "" {
+		slog.Info("Servidor Go Diamond Collector iniciando com TLS (https/wss)", "port", port, "cert", config.TLSCertFile)
+		err = server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+	} else {
+		slog.Info("Servidor Go Diamond Collector iniciando sem TLS (http/ws)", "port", port)
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Erro ao iniciar servidor: %v", err) // Erro fatal: registra e sai
 	}
+	<-shutdownDone
+	slog.Info("Servidor encerrado")
 }
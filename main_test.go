@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startServer prepara config e rooms como main faria, com a configuração padrão ajustada por setup, e serve newMux
+// num httptest.Server. As salas e o servidor são encerrados no fim do teste.
+func startServer(t *testing.T, setup func(*Config)) *httptest.Server {
+	t.Helper()
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RandomSeed = 1
+	if setup != nil {
+		setup(&cfg)
+	}
+	config = cfg
+	rooms = newRoomManager(cfg)
+	srv := httptest.NewServer(newMux())
+	t.Cleanup(func() {
+		// Como no shutdown de main: fecha os canais de envio e espera os 'writer's fecharem as conexões; cada
+		// 'reader' devolve sua vaga em openConns ao sair. Só então o próximo teste pode trocar config.
+		rooms.shutdown(time.Second)
+		if !waitWithTimeout(&writers, time.Second) {
+			t.Error("'writer's ainda rodando depois do shutdown")
+		}
+		for deadline := time.Now().Add(time.Second); openConns.Load() > 0; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Errorf("%d conexões ainda abertas depois do shutdown", openConns.Load())
+				break
+			}
+		}
+		srv.Close()
+	})
+	return srv
+}
+
+// dial abre uma conexão WebSocket com o servidor de teste; path inclui a rota e a query (ex.: "/ws/sala?name=Ana")
+func dial(t *testing.T, srv *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatalf("Dial(%q): %v", path, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readMessage lê a próxima mensagem JSON do servidor, falhando se nada chegar em dois segundos
+func readMessage(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var msg map[string]any
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("mensagem inválida do servidor %q: %v", data, err)
+	}
+	return msg
+}
+
+// readUntil descarta mensagens até chegar uma do tipo msgType e a retorna
+func readUntil(t *testing.T, conn *websocket.Conn, msgType string) map[string]any {
+	t.Helper()
+	for {
+		if msg := readMessage(t, conn); msg["type"] == msgType {
+			return msg
+		}
+	}
+}
+
+func TestRoomReapLeavesNoGoroutines(t *testing.T) {
+	srv := startServer(t, func(cfg *Config) { cfg.ReconnectGrace = 0 })
+	before := runtime.NumGoroutine()
+
+	// Cada conexão cria um 'reader' e um 'writer'; a sala, um gameLoop
+	for i := 0; i < 3; i++ {
+		conn := dial(t, srv, "/ws/vazamento")
+		readUntil(t, conn, MsgTypeWelcome)
+		conn.Close()
+	}
+	gs := rooms.getOrCreate("vazamento")
+	for deadline := time.Now().Add(2 * time.Second); !gs.Empty(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("a sala não ficou vazia depois das desconexões")
+		}
+	}
+	now := time.Now()
+	rooms.sweep(time.Minute, now) // Marca a sala como vazia...
+	rooms.sweep(time.Minute, now.Add(time.Minute))
+	if rooms.states()["vazamento"] != nil {
+		t.Fatal("a sala vazia não foi removida")
+	}
+
+	after := runtime.NumGoroutine()
+	for deadline := time.Now().Add(2 * time.Second); after > before && time.Now().Before(deadline); after = runtime.NumGoroutine() {
+		time.Sleep(5 * time.Millisecond) // As goroutines encerradas podem levar um instante para sair
+	}
+	if after > before {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines antes da sala e %d depois de removê-la:\n%s", before, after, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestReaderRejectsBadMessages(t *testing.T) {
+	srv := startServer(t, nil)
+	conn := dial(t, srv, "/ws/leitor")
+	readUntil(t, conn, MsgTypeWelcome)
+
+	send := func(data string) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// As duas primeiras mantêm a conexão aberta; só a mensagem grande demais a fecha
+	send(`{"action": "move", `)
+	if msg := readUntil(t, conn, MsgTypeError); msg["code"] != ErrCodeMalformedJSON {
+		t.Errorf("JSON inválido: erro %v, deveria ser %s", msg["code"], ErrCodeMalformedJSON)
+	}
+	send(`{"action": "voar"}`)
+	if msg := readUntil(t, conn, MsgTypeError); msg["code"] != ErrCodeUnknownAction || !strings.Contains(msg["message"].(string), "voar") {
+		t.Errorf("ação desconhecida: %v", msg)
+	}
+	send(`{"action": "chat", "text": "` + strings.Repeat("a", config.MaxMessageBytes) + `"}`)
+	if msg := readUntil(t, conn, MsgTypeError); msg["code"] != ErrCodeMessageTooLarge {
+		t.Errorf("mensagem grande demais: erro %v, deveria ser %s", msg["code"], ErrCodeMessageTooLarge)
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("depois da mensagem grande demais, a conexão deveria ser fechada normalmente: %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestBoardFullRejectsConnection(t *testing.T) {
+	// Duas células: uma para o item da partida e outra para o primeiro jogador
+	srv := startServer(t, func(cfg *Config) { cfg.BoardWidth, cfg.BoardHeight, cfg.NumItems = 1, 2, 1 })
+	first := dial(t, srv, "/ws/lotada")
+	readUntil(t, first, MsgTypeWelcome)
+
+	second := dial(t, srv, "/ws/lotada?lang=en")
+	if msg := readMessage(t, second); msg["type"] != MsgTypeError || msg["code"] != ErrCodeBoardFull || msg["message"] != translate("en", ErrCodeBoardFull) {
+		t.Errorf("no lugar das boas-vindas, deveria chegar o erro %s em inglês: %v", ErrCodeBoardFull, msg)
+	}
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := second.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Errorf("a conexão recusada deveria fechar com %d: %v", websocket.CloseTryAgainLater, err)
+	}
+	if stats := rooms.stats()["lotada"]; stats.Players != 1 || stats.Spectators != 0 {
+		t.Errorf("a sala deveria ter só o primeiro jogador: %+v", stats)
+	}
+}
+
+func TestWebSocketJoinAndMove(t *testing.T) {
+	srv := startServer(t, func(cfg *Config) { cfg.TickDelay = 20 * time.Millisecond })
+	conn := dial(t, srv, "/ws/ponta?name=Ana&lang=es")
+
+	welcome := readMessage(t, conn)
+	id, _ := welcome["playerId"].(string)
+	if welcome["type"] != MsgTypeWelcome || id == "" || welcome["token"] == nil || welcome["roomId"] != "ponta" || welcome["name"] != "Ana" || welcome["lang"] != "es" {
+		t.Fatalf("boas-vindas inesperadas: %v", welcome)
+	}
+	if texts, _ := welcome["texts"].(map[string]any); texts[msgWinner] != translate("es", msgWinner) {
+		t.Errorf("textos do fim de jogo fora do idioma da conexão: %v", welcome["texts"])
+	}
+
+	// Logo depois das boas-vindas vem o estado completo, com o jogador nele
+	state := readMessage(t, conn)
+	me, ok := state["players"].(map[string]any)[id].(map[string]any)
+	if !ok || state["type"] != nil || state["boardWidth"] != float64(config.BoardWidth) || state["itemsRemaining"] != float64(len(state["items"].(map[string]any))) {
+		t.Fatalf("estado inicial inesperado: %v", state)
+	}
+	pos := me["pos"].(map[string]any)
+	x, y := pos["x"].(float64), pos["y"].(float64)
+	direction, wantX := "right", x+1
+	if int(x) == config.BoardWidth-1 {
+		direction, wantX = "left", x-1
+	}
+
+	if err := conn.WriteJSON(map[string]string{"action": "move", "direction": direction}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if time.Now().After(deadline) {
+			t.Fatalf("nenhum estado com o jogador em (%v,%v)", wantX, y)
+		}
+		msg := readMessage(t, conn)
+		if msg["type"] == MsgTypeError {
+			t.Fatalf("movimento recusado: %v", msg)
+		}
+		players, _ := msg["players"].(map[string]any)
+		if me, ok := players[id].(map[string]any); ok {
+			if pos := me["pos"].(map[string]any); pos["x"] == wantX && pos["y"] == y {
+				break
+			}
+		}
+	}
+}
+
+func TestOnlyUpgradesCreateRooms(t *testing.T) {
+	srv := startServer(t, func(cfg *Config) { cfg.Origins = []string{"https://jogo.example.com"} })
+
+	resp, err := http.Get(srv.URL + "/ws/secreta?password=intrusa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET sem upgrade: status %d, deveria ser %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	header := http.Header{"Origin": {"https://outro.example.com"}}
+	if _, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/secreta?password=intrusa", header); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("upgrade de origem não permitida: %v, %v", resp, err)
+	}
+	if rooms.states()["secreta"] != nil {
+		t.Fatal("um pedido recusado criou a sala")
+	}
+
+	// O dono chega depois e ainda cria a sala com a própria senha
+	conn := dial(t, srv, "/ws/secreta?password=dono")
+	readUntil(t, conn, MsgTypeWelcome)
+	if _, _, err := rooms.join("secreta", "intrusa"); !errors.Is(err, errRoomPassword) {
+		t.Errorf("a sala deveria exigir a senha do dono: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Métricas expostas em /metrics. As que têm o rótulo "room" são separadas por sala.
+var (
+	activePlayers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jogo_active_players",
+		Help: "Jogadores conectados em cada sala.",
+	}, []string{"room"})
+	activeSpectators = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jogo_active_spectators",
+		Help: "Espectadores conectados em cada sala.",
+	}, []string{"room"})
+	itemsRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jogo_items_remaining",
+		Help: "Itens ainda no tabuleiro de cada sala.",
+	}, []string{"room"})
+	itemsCollected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jogo_items_collected_total",
+		Help: "Itens coletados desde o início do servidor.",
+	}, []string{"room"})
+	gamesCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jogo_games_completed_total",
+		Help: "Partidas encerradas (por coleta do último item ou fim do tempo).",
+	}, []string{"room"})
+	droppedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jogo_dropped_messages_total",
+		Help: "Mensagens descartadas porque o canal de envio do cliente estava cheio.",
+	}, []string{"room"})
+	rateLimitedConns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "jogo_rate_limited_connections_total",
+		Help: "Conexões WebSocket recusadas (429) pelo limite de conexões novas por IP.",
+	})
+	broadcastDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "jogo_broadcast_duration_seconds",
+		Help:    "Tempo para serializar e distribuir o estado de uma sala.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 12), // De 0,1 ms a ~200 ms
+	})
+)
+
+// forgetRoomMetrics apaga as séries de uma sala removida, para que /metrics não continue mostrando seus últimos valores
+func forgetRoomMetrics(roomID string) {
+	for _, vec := range []*prometheus.MetricVec{activePlayers.MetricVec, activeSpectators.MetricVec, itemsRemaining.MetricVec, itemsCollected.MetricVec, gamesCompleted.MetricVec, droppedMessages.MetricVec} {
+		vec.DeleteLabelValues(roomID)
+	}
+}
+
+// prometheusMetrics repassa os eventos das salas (engine.Metrics) para as métricas do Prometheus
+type prometheusMetrics struct{}
+
+func (prometheusMetrics) RoomSize(roomID string, players int, spectators int, items int) {
+	activePlayers.WithLabelValues(roomID).Set(float64(players))
+	activeSpectators.WithLabelValues(roomID).Set(float64(spectators))
+	itemsRemaining.WithLabelValues(roomID).Set(float64(items))
+}
+
+func (prometheusMetrics) ItemCollected(roomID string) {
+	itemsCollected.WithLabelValues(roomID).Inc()
+}
+
+func (prometheusMetrics) GameCompleted(roomID string) {
+	gamesCompleted.WithLabelValues(roomID).Inc()
+}
+
+func (prometheusMetrics) MessageDropped(roomID string) {
+	droppedMessages.WithLabelValues(roomID).Inc()
+}
+
+func (prometheusMetrics) BroadcastDuration(roomID string, d time.Duration) {
+	broadcastDuration.Observe(d.Seconds())
+}
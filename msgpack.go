@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Codificação MessagePack para clientes nativos que preferem um protocolo binário. As mensagens continuam sendo
+// montadas em JSON (com as mesmas tags de ServerMessage, estado, erros...) e só são convertidas na hora de
+// escrever na conexão, e as mensagens binárias dos clientes são convertidas para JSON antes de virar
+// ClientMessage. Assim os dois formatos têm exatamente os mesmos campos. Só os tipos que o JSON produz são
+// suportados: nil, bool, números, strings, arrays e mapas com chaves string (gravados em ordem alfabética).
+
+const (
+	SubprotocolJSON    = "json"    // Subprotocolo WebSocket do formato padrão
+	SubprotocolMsgpack = "msgpack" // Subprotocolo WebSocket do formato binário (também via ?format=msgpack)
+)
+
+var errMsgpackTruncated = errors.New("msgpack: mensagem truncada")
+
+// jsonToMsgpack converte uma mensagem JSON para MessagePack
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // Inteiros continuam inteiros em vez de virar float64
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeMsgpack(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// msgpackToJSON converte uma mensagem MessagePack para JSON
+func msgpackToJSON(data []byte) ([]byte, error) {
+	v, rest, err := decodeMsgpack(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("msgpack: %d bytes sobrando depois da mensagem", len(rest))
+	}
+	return json.Marshal(v)
+}
+
+// encodeMsgpack escreve um valor decodificado de JSON (com UseNumber)
+func encodeMsgpack(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			encodeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buf.Write([]byte{0xd9, byte(n)})
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xda)
+			binary.Write(buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(0xdb)
+			binary.Write(buf, binary.BigEndian, uint32(n))
+		}
+		buf.WriteString(v)
+	case []any:
+		writeMsgpackHeader(buf, len(v), 0x90, 0xdc, 0xdd)
+		for _, elem := range v {
+			if err := encodeMsgpack(buf, elem); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // Mesma mensagem, mesmos bytes
+		writeMsgpackHeader(buf, len(v), 0x80, 0xde, 0xdf)
+		for _, k := range keys {
+			encodeMsgpack(buf, k)
+			if err := encodeMsgpack(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: tipo não suportado %T", v)
+	}
+	return nil
+}
+
+// encodeMsgpackInt escreve um inteiro no menor formato que o comporta
+func encodeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i >= -32 && i < 0:
+		buf.WriteByte(byte(i)) // fixint negativo: 0xe0 a 0xff
+	case i >= 0 && i <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(i)})
+	case i >= 0 && i <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(i))
+	case i >= 0:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, uint64(i))
+	case i >= math.MinInt8:
+		buf.Write([]byte{0xd0, byte(int8(i))})
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// writeMsgpackHeader escreve o cabeçalho de um array ou mapa: a forma 'fix' (até 15 elementos), a de 16 ou a de 32 bits
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// decodeMsgpack lê um valor do início de data e retorna o restante. Strings e binários viram string, mapas
+// precisam de chaves string e extensões não são aceitas.
+func decodeMsgpack(data []byte) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errMsgpackTruncated
+	}
+	b, data := data[0], data[1:]
+	switch {
+	case b <= 0x7f:
+		return int64(b), data, nil
+	case b >= 0xe0:
+		return int64(int8(b)), data, nil
+	case b&0xe0 == 0xa0:
+		return decodeMsgpackString(data, int(b&0x1f))
+	case b&0xf0 == 0x90:
+		return decodeMsgpackArray(data, int(b&0x0f))
+	case b&0xf0 == 0x80:
+		return decodeMsgpackMap(data, int(b&0x0f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, data, nil
+	case 0xc2:
+		return false, data, nil
+	case 0xc3:
+		return true, data, nil
+	case 0xca:
+		raw, data, err := takeMsgpack(data, 4)
+		if err != nil {
+			return nil, nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), data, nil
+	case 0xcb:
+		raw, data, err := takeMsgpack(data, 8)
+		if err != nil {
+			return nil, nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), data, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		raw, data, err := takeMsgpack(data, 1<<(b-0xcc))
+		if err != nil {
+			return nil, nil, err
+		}
+		return msgpackUint(raw), data, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		raw, data, err := takeMsgpack(data, 1<<(b-0xd0))
+		if err != nil {
+			return nil, nil, err
+		}
+		return msgpackInt(raw), data, nil
+	case 0xd9, 0xda, 0xdb: // str8/16/32
+		n, data, err := msgpackLength(data, 1<<(b-0xd9))
+		if err != nil {
+			return nil, nil, err
+		}
+		return decodeMsgpackString(data, n)
+	case 0xc4, 0xc5, 0xc6: // bin8/16/32, aceito como string
+		n, data, err := msgpackLength(data, 1<<(b-0xc4))
+		if err != nil {
+			return nil, nil, err
+		}
+		return decodeMsgpackString(data, n)
+	case 0xdc, 0xdd:
+		n, data, err := msgpackLength(data, 2<<(b-0xdc))
+		if err != nil {
+			return nil, nil, err
+		}
+		return decodeMsgpackArray(data, n)
+	case 0xde, 0xdf:
+		n, data, err := msgpackLength(data, 2<<(b-0xde))
+		if err != nil {
+			return nil, nil, err
+		}
+		return decodeMsgpackMap(data, n)
+	}
+	return nil, nil, fmt.Errorf("msgpack: formato 0x%02x não suportado", b)
+}
+
+func decodeMsgpackString(data []byte, n int) (any, []byte, error) {
+	raw, data, err := takeMsgpack(data, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	return string(raw), data, nil
+}
+
+func decodeMsgpackArray(data []byte, n int) (any, []byte, error) {
+	if n > len(data) { // Cada elemento ocupa ao menos um byte
+		return nil, nil, errMsgpackTruncated
+	}
+	arr := make([]any, n)
+	for i := range arr {
+		var err error
+		if arr[i], data, err = decodeMsgpack(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	return arr, data, nil
+}
+
+func decodeMsgpackMap(data []byte, n int) (any, []byte, error) {
+	if 2*n > len(data) { // Cada par ocupa ao menos dois bytes
+		return nil, nil, errMsgpackTruncated
+	}
+	m := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		k, rest, err := decodeMsgpack(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("msgpack: chave de mapa %T, esperado string", k)
+		}
+		if m[key], data, err = decodeMsgpack(rest); err != nil {
+			return nil, nil, err
+		}
+	}
+	return m, data, nil
+}
+
+// takeMsgpack separa os n primeiros bytes
+func takeMsgpack(data []byte, n int) ([]byte, []byte, error) {
+	if n < 0 || n > len(data) {
+		return nil, nil, errMsgpackTruncated
+	}
+	return data[:n], data[n:], nil
+}
+
+// msgpackLength lê um tamanho sem sinal de 'size' bytes
+func msgpackLength(data []byte, size int) (int, []byte, error) {
+	raw, data, err := takeMsgpack(data, size)
+	if err != nil {
+		return 0, nil, err
+	}
+	n := msgpackUint(raw)
+	if n > uint64(len(data)) {
+		return 0, nil, errMsgpackTruncated
+	}
+	return int(n), data, nil
+}
+
+func msgpackUint(raw []byte) uint64 {
+	var n uint64
+	for _, b := range raw {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+func msgpackInt(raw []byte) int64 {
+	n := int64(int8(raw[0])) // Estende o sinal a partir do byte mais significativo
+	for _, b := range raw[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+const (
+	DefaultPprofAddr = "127.0.0.1:6060" // Só a própria máquina: o profiler expõe detalhes internos e custa CPU
+)
+
+// startPprof serve os handlers de net/http/pprof em /debug/pprof num listener separado, em addr, para que o
+// profiler nunca fique na porta pública do jogo. Importar net/http/pprof registra os mesmos handlers no
+// http.DefaultServeMux, e por isso o servidor do jogo usa um mux próprio.
+func startPprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index) // Também atende heap, goroutine, block, mutex...
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	slog.Info("Profiler (pprof) disponível", "addr", addr, "path", "/debug/pprof/")
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Erro no servidor do profiler", "addr", addr, "err", err)
+		}
+	}()
+}
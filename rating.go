@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"game/engine"
+)
+
+const (
+	BaseRating     = 1500.0 // Rating de quem ainda não jogou nenhuma partida avaliada
+	DefaultRatingK = 32     // Variação máxima de rating numa partida contra um único adversário
+)
+
+// playerRating é o rating ELO de um apelido, guardado junto com o histórico de partidas
+type playerRating struct {
+	Name   string  `json:"name"`
+	Rating float64 `json:"rating"`
+	Games  int     `json:"games"` // Partidas avaliadas
+}
+
+// rateGame atualiza ratings com o resultado de uma partida. Só entram jogadores com apelido e que não são bots;
+// com menos de dois, nada muda. Cada par de participantes conta como um confronto decidido pela pontuação final
+// (empate vale meio ponto), e a variação de cada um é k vezes a soma de (resultado - esperado) dividida pelo
+// número de adversários, com os ratings de antes da partida. Repetir as mesmas entradas dá sempre o mesmo
+// resultado: os participantes são processados em ordem de apelido e os ratings arredondados em 0,1.
+func rateGame(ratings map[string]*playerRating, scores []engine.PlayerStats, k float64) {
+	best := make(map[string]int) // Apelido repetido na sala: vale a maior pontuação
+	for _, s := range scores {
+		if s.Name == "" || s.Bot {
+			continue
+		}
+		if score, ok := best[s.Name]; !ok || s.Score > score {
+			best[s.Name] = s.Score
+		}
+	}
+	if len(best) < 2 {
+		return
+	}
+
+	names := make([]string, 0, len(best))
+	for name := range best {
+		names = append(names, name)
+		if ratings[name] == nil {
+			ratings[name] = &playerRating{Name: name, Rating: BaseRating}
+		}
+	}
+	sort.Strings(names)
+
+	deltas := make([]float64, len(names))
+	for i, a := range names {
+		for _, b := range names {
+			if a == b {
+				continue
+			}
+			actual := 0.5
+			if best[a] > best[b] {
+				actual = 1
+			} else if best[a] < best[b] {
+				actual = 0
+			}
+			expected := 1 / (1 + math.Pow(10, (ratings[b].Rating-ratings[a].Rating)/400))
+			deltas[i] += actual - expected
+		}
+	}
+	for i, name := range names {
+		r := ratings[name]
+		r.Rating = math.Round((r.Rating+k*deltas[i]/float64(len(names)-1))*10) / 10
+		r.Games++
+	}
+}
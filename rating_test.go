@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"game/engine"
+)
+
+func TestRateGame(t *testing.T) {
+	tests := []struct {
+		name   string
+		before map[string]float64 // Ratings anteriores; quem não está aqui começa em BaseRating
+		scores []engine.PlayerStats
+		want   map[string]float64 // Ratings depois da partida; quem não está aqui não pode ter sido avaliado
+	}{
+		{
+			name:   "vitória entre iguais",
+			scores: []engine.PlayerStats{{Name: "Ana", Score: 5}, {Name: "Bia", Score: 2}},
+			want:   map[string]float64{"Ana": 1516, "Bia": 1484},
+		},
+		{
+			name:   "empate entre iguais",
+			scores: []engine.PlayerStats{{Name: "Ana", Score: 3}, {Name: "Bia", Score: 3}},
+			want:   map[string]float64{"Ana": 1500, "Bia": 1500},
+		},
+		{
+			name:   "zebra",
+			before: map[string]float64{"Ana": 1400, "Bia": 1600},
+			scores: []engine.PlayerStats{{Name: "Ana", Score: 4}, {Name: "Bia", Score: 1}},
+			want:   map[string]float64{"Ana": 1424.3, "Bia": 1575.7},
+		},
+		{
+			name:   "três jogadores",
+			scores: []engine.PlayerStats{{Name: "Ana", Score: 3}, {Name: "Bia", Score: 2}, {Name: "Caio", Score: 1}},
+			want:   map[string]float64{"Ana": 1516, "Bia": 1500, "Caio": 1484},
+		},
+		{
+			name:   "apelido repetido vale a maior pontuação",
+			scores: []engine.PlayerStats{{Name: "Ana", Score: 1}, {Name: "Ana", Score: 5}, {Name: "Bia", Score: 3}},
+			want:   map[string]float64{"Ana": 1516, "Bia": 1484},
+		},
+		{
+			name:   "bots e jogadores sem apelido não contam",
+			scores: []engine.PlayerStats{{Name: "Ana", Score: 1}, {Name: "Robô", Score: 9, Bot: true}, {Score: 7}},
+			want:   map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ratings := make(map[string]*playerRating)
+			for name, rating := range tt.before {
+				ratings[name] = &playerRating{Name: name, Rating: rating}
+			}
+			rateGame(ratings, tt.scores, DefaultRatingK)
+
+			for name, want := range tt.want {
+				r := ratings[name]
+				if r == nil {
+					t.Errorf("%s não foi avaliado", name)
+					continue
+				}
+				if r.Rating != want || r.Games != 1 {
+					t.Errorf("%s: rating %.1f em %d partidas, deveria ser %.1f em 1", name, r.Rating, r.Games, want)
+				}
+			}
+			for name, r := range ratings {
+				if _, ok := tt.want[name]; !ok && r.Games != 0 {
+					t.Errorf("%s não deveria ter sido avaliado: %+v", name, r)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+
+	"game/engine"
+)
+
+// printSink escreve cada evento como uma linha JSON. Só serve para o replay, em que não há gameLoop a atrasar.
+type printSink struct {
+	enc *json.Encoder
+}
+
+func (s printSink) Publish(e engine.GameEvent) {
+	if err := s.enc.Encode(e); err != nil {
+		slog.Error("Erro ao escrever evento do replay", "err", err)
+	}
+}
+
+// runReplay reexecuta uma gravação de RECORD_DIR e escreve em out os eventos da sala original (entradas, saídas,
+// coletas e fins de jogo), um por linha, na mesma ordem em que aconteceram
+func runReplay(path string, out io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gs, err := engine.Replay(f, printSink{enc: json.NewEncoder(out)})
+	if err != nil {
+		return err
+	}
+	stats := gs.Stats()
+	slog.Info("Replay concluído", "room", gs.RoomID, "game_over", stats.GameOver, "winners", stats.WinnerIDs, "items_left", stats.ItemsRemaining)
+	return nil
+}
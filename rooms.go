@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"game/engine"
+)
+
+const (
+	DefaultRoomID = "principal" // Sala usada quando o cliente não escolhe nenhuma
+)
+
+var validRoomID = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// errRoomPassword é retornado por join quando a sala é privada e a senha não confere
+var errRoomPassword = errors.New("senha da sala incorreta")
+
+// room é uma sala gerenciada pelo RoomManager: o estado do jogo e o pulso do seu gameLoop
+type room struct {
+	state        *engine.GameState
+	lastTick     atomic.Int64       // Momento (UnixNano) do último tick, lido pelos health checks sem travar o GameState
+	recorder     *engine.Recorder   // Gravação da sala (RECORD_DIR); nil quando desligada
+	passwordSalt []byte             // Sal aleatório da senha; nil em salas públicas
+	passwordHash []byte             // HMAC-SHA256 da senha com passwordSalt; a senha em si nunca é guardada
+	emptySince   time.Time          // Desde quando a sala está vazia, visto por sweep; zero enquanto tem alguém ou logo após um join
+	joining      int                // Conexões que passaram por join e ainda não entraram no GameState; a sala não é removida enquanto houver alguma
+	cancel       context.CancelFunc // Encerra o gameLoop e os bots da sala; chamado na remoção (o shutdown cancela o contexto pai)
+	loops        sync.WaitGroup     // O gameLoop e os bots desta sala
+}
+
+// roomInfo é a descrição pública de uma sala na listagem de /rooms
+type roomInfo struct {
+	ID         string `json:"id"`
+	Players    int    `json:"players"` // Jogadores ativos, inclusive bots
+	Spectators int    `json:"spectators"`
+	Private    bool   `json:"private"` // Exige ?password= para entrar
+	GameOver   bool   `json:"gameOver"`
+}
+
+// setPasswordLocked define a senha da sala; vazia, torna a sala pública. Quem chama deve segurar o mutex do
+// RoomManager.
+func (r *room) setPasswordLocked(password string) {
+	if password == "" {
+		r.passwordSalt, r.passwordHash = nil, nil
+		return
+	}
+	r.passwordSalt = make([]byte, 16)
+	rand.Read(r.passwordSalt)
+	r.passwordHash = hashRoomPassword(r.passwordSalt, password)
+}
+
+// allowsLocked informa se a senha dá acesso à sala (salas públicas aceitam qualquer uma). Quem chama deve
+// segurar o mutex do RoomManager.
+func (r *room) allowsLocked(password string) bool {
+	return r.passwordHash == nil || hmac.Equal(r.passwordHash, hashRoomPassword(r.passwordSalt, password))
+}
+
+func hashRoomPassword(salt []byte, password string) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// RoomManager mantém as salas de jogo ativas, cada uma com seu próprio GameState e gameLoop
+type RoomManager struct {
+	rooms  map[string]*room
+	cfg    Config
+	ctx    context.Context // Pai do contexto de cada sala; cancelado no shutdown
+	cancel context.CancelFunc
+	loops  sync.WaitGroup // Acompanha os gameLoops em execução (e a gravação periódica do estado)
+	mu     sync.Mutex     // Protege o mapa de salas (independente do mutex de cada GameState)
+}
+
+func newRoomManager(cfg Config) *RoomManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RoomManager{
+		rooms:  make(map[string]*room),
+		cfg:    cfg,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// getOrCreate retorna a sala com o ID informado, criando-a (e iniciando seu gameLoop e seus bots) se ainda não existir
+func (rm *RoomManager) getOrCreate(id string) *engine.GameState {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if r, ok := rm.rooms[id]; ok {
+		return r.state
+	}
+	return rm.createLocked(id).state
+}
+
+// join é o getOrCreate das conexões: numa sala privada, password precisa conferir (senão retorna
+// errRoomPassword); uma sala criada aqui com senha já nasce privada. Em salas públicas, a senha é ignorada.
+// Até quem chama chamar done (depois de entrar no GameState, ou de desistir), a sala não é removida por sweep.
+func (rm *RoomManager) join(id, password string) (gs *engine.GameState, done func(), err error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	r, ok := rm.rooms[id]
+	if ok && !r.allowsLocked(password) {
+		return nil, nil, errRoomPassword
+	}
+	if !ok {
+		r = rm.createLocked(id)
+		if password != "" {
+			r.setPasswordLocked(password)
+			slog.Info("Sala criada como privada", "room", id)
+		}
+	}
+	r.joining++
+	r.emptySince = time.Time{} // A contagem de vazia recomeça depois que a conexão entrar (ou desistir)
+	return r.state, func() {
+		rm.mu.Lock()
+		defer rm.mu.Unlock()
+		r.joining--
+	}, nil
+}
+
+// setPassword define ou remove (com senha vazia) a senha de uma sala, criando-a se ainda não existir. Quem já
+// está na sala continua nela. Retorna se a sala foi criada agora.
+func (rm *RoomManager) setPassword(id, password string) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	r, ok := rm.rooms[id]
+	if !ok {
+		r = rm.createLocked(id)
+	}
+	r.setPasswordLocked(password)
+	r.emptySince = time.Time{} // Quem definiu a senha espera que a sala dure ao menos mais um ROOM_TTL_SECONDS
+	return !ok
+}
+
+// createLocked cria a sala e inicia seu gameLoop e seus bots. Quem chama deve segurar rm.mu e saber que a sala
+// ainda não existe.
+func (rm *RoomManager) createLocked(id string) *room {
+	cfg := rm.cfg.Config
+	recorder := rm.newRecorder(id)
+	cfg.Recorder = recorder
+	gs := engine.NewGameState(id, cfg)
+	gs.InitializeItems()
+	ctx, cancel := context.WithCancel(rm.ctx) // A sala para no shutdown de todas ou na sua própria remoção
+	r := &room{state: gs, recorder: recorder, cancel: cancel}
+	r.lastTick.Store(time.Now().UnixNano())
+	rm.rooms[id] = r
+
+	rm.loops.Add(1)
+	r.loops.Add(1)
+	go func() {
+		defer rm.loops.Done()
+		defer r.loops.Done()
+		gameLoop(ctx, gs, rm.cfg, &r.lastTick)
+	}()
+	if rm.cfg.BotCount > 0 {
+		rm.loops.Add(1)
+		r.loops.Add(1)
+		go func() {
+			defer rm.loops.Done()
+			defer r.loops.Done()
+			engine.NewBotManager(gs, rm.cfg.Config).Run(ctx)
+		}()
+	}
+
+	slog.Info("Sala criada", "room", id, "rooms", len(rm.rooms))
+	return r
+}
+
+// get retorna uma sala existente, sem criá-la
+func (rm *RoomManager) get(id string) (*engine.GameState, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	r, ok := rm.rooms[id]
+	if !ok {
+		return nil, false
+	}
+	return r.state, true
+}
+
+// lobby descreve as salas ativas, em ordem de ID. O mapa de salas só fica travado para a cópia; a contagem de
+// cada sala usa o lock de leitura dela, como /stats, sem esperar nenhum gameLoop.
+func (rm *RoomManager) lobby() []roomInfo {
+	rm.mu.Lock()
+	infos := make([]roomInfo, 0, len(rm.rooms))
+	states := make([]*engine.GameState, 0, len(rm.rooms))
+	for id, r := range rm.rooms {
+		infos = append(infos, roomInfo{ID: id, Private: r.passwordHash != nil})
+		states = append(states, r.state)
+	}
+	rm.mu.Unlock()
+
+	for i, gs := range states {
+		stats := gs.Stats()
+		infos[i].Players, infos[i].Spectators, infos[i].GameOver = stats.Players, stats.Spectators, stats.GameOver
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// reapEmpty remove periodicamente as salas vazias há pelo menos ttl, até o shutdown. A sala padrão nunca é
+// removida.
+func (rm *RoomManager) reapEmpty(ttl time.Duration) {
+	ticker := time.NewTicker(max(time.Second, min(ttl/2, time.Minute)))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rm.sweep(ttl, time.Now())
+		case <-rm.ctx.Done():
+			return
+		}
+	}
+}
+
+// sweep marca as salas que ficaram vazias e remove as que continuam vazias há ttl. Uma sala com conexões entre
+// join e a entrada no GameState conta como ocupada, então quem reaparece numa sala prestes a ser removida a
+// reaproveita em vez de entrar numa sala sem loop.
+func (rm *RoomManager) sweep(ttl time.Duration, now time.Time) {
+	empty := make(map[string]bool)
+	for id, gs := range rm.states() { // Sem o mutex do mapa, que não deve esperar o lock de nenhuma sala
+		empty[id] = gs.Empty()
+	}
+
+	rm.mu.Lock()
+	reaped := make(map[string]*room)
+	for id, isEmpty := range empty {
+		r, ok := rm.rooms[id]
+		if !ok || id == DefaultRoomID {
+			continue
+		}
+		switch {
+		case !isEmpty || r.joining > 0:
+			r.emptySince = time.Time{}
+		case r.emptySince.IsZero():
+			r.emptySince = now
+		case now.Sub(r.emptySince) >= ttl:
+			delete(rm.rooms, id)
+			reaped[id] = r
+		}
+	}
+	remaining := len(rm.rooms)
+	rm.mu.Unlock()
+
+	for id, r := range reaped {
+		r.cancel()
+		r.loops.Wait() // Nenhum broadcast pode estar enviando quando os canais forem fechados
+		r.state.CloseAllPlayers()
+		r.closeRecorder(id)
+		forgetRoomMetrics(id)
+		slog.Info("Sala vazia removida", "room", id, "empty_for", now.Sub(r.emptySince), "rooms", remaining)
+	}
+}
+
+// closeRecorder fecha a gravação da sala, se houver
+func (r *room) closeRecorder(id string) {
+	if r.recorder == nil {
+		return
+	}
+	if err := r.recorder.Close(); err != nil {
+		slog.Error("Erro ao fechar a gravação da sala", "room", id, "err", err)
+	}
+}
+
+// shutdown para o loop de todas as salas, grava o estado delas (com STATE_FILE) e desconecta seus jogadores
+func (rm *RoomManager) shutdown(timeout time.Duration) {
+	rm.cancel()
+	if !waitWithTimeout(&rm.loops, timeout) { // Garante que nenhum broadcast esteja enviando para canais que serão fechados
+		slog.Warn("Tempo esgotado esperando os loops das salas encerrarem")
+	}
+	if rm.cfg.StateFile != "" { // Antes de CloseAllPlayers, que tira os jogadores das salas
+		if err := rm.saveState(rm.cfg.StateFile); err != nil {
+			slog.Error("Erro ao salvar o estado das salas", "file", rm.cfg.StateFile, "err", err)
+		} else {
+			slog.Info("Estado das salas salvo", "file", rm.cfg.StateFile)
+		}
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for id, r := range rm.rooms {
+		r.state.CloseAllPlayers()
+		r.closeRecorder(id)
+	}
+}
+
+// newRecorder abre o arquivo de gravação de uma sala nova em RECORD_DIR. Retorna nil se a gravação estiver
+// desligada ou o arquivo não puder ser criado (a sala funciona normalmente, só não é gravada).
+func (rm *RoomManager) newRecorder(id string) *engine.Recorder {
+	if rm.cfg.RecordDir == "" {
+		return nil
+	}
+	// O nome tem resolução de um segundo: se a sala for recriada no mesmo segundo, a nova gravação ganha um
+	// sufixo em vez de truncar a anterior
+	base := filepath.Join(rm.cfg.RecordDir, id+"-"+time.Now().Format("20060102-150405"))
+	path := base + ".jsonl"
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	for n := 2; errors.Is(err, os.ErrExist); n++ {
+		path = base + "-" + strconv.Itoa(n) + ".jsonl"
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	}
+	if err != nil {
+		slog.Error("Erro ao criar a gravação da sala", "room", id, "err", err)
+		return nil
+	}
+	slog.Info("Gravando a sala", "room", id, "file", path)
+	return engine.NewRecorder(f)
+}
+
+// states copia o mapa de salas, para que quem percorre todas não segure o mutex do mapa enquanto espera o
+// lock de cada GameState
+func (rm *RoomManager) states() map[string]*engine.GameState {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	states := make(map[string]*engine.GameState, len(rm.rooms))
+	for id, r := range rm.rooms {
+		states[id] = r.state
+	}
+	return states
+}
+
+// stats coleta o resumo de cada sala
+func (rm *RoomManager) stats() map[string]engine.RoomStats {
+	states := rm.states()
+	stats := make(map[string]engine.RoomStats, len(states))
+	for id, gs := range states {
+		stats[id] = gs.Stats()
+	}
+	return stats
+}
+
+// stalledRooms retorna as salas cujo gameLoop não completa um tick há mais de maxLag (por exemplo, travado
+// esperando um lock). Só usa o mutex do mapa de salas, nunca o de cada GameState.
+func (rm *RoomManager) stalledRooms(maxLag time.Duration) []string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	var stalled []string
+	now := time.Now()
+	for id, r := range rm.rooms {
+		if now.Sub(time.Unix(0, r.lastTick.Load())) > maxLag {
+			stalled = append(stalled, id)
+		}
+	}
+	return stalled
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewRecorderKeepsEarlierRecording(t *testing.T) {
+	dir := t.TempDir()
+	rm := newRoomManager(Config{RecordDir: dir})
+	// Sala recriada logo em seguida, quase sempre no mesmo segundo: as duas gravações precisam coexistir
+	for i := 0; i < 2; i++ {
+		rec := rm.newRecorder("sala")
+		if rec == nil {
+			t.Fatal("newRecorder retornou nil")
+		}
+		if err := rec.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("%d arquivos de gravação, deveriam ser 2", len(files))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// newSessionSecret gera uma chave aleatória para assinar tokens quando SESSION_SECRET não está definida.
+// Nesse caso os tokens deixam de valer quando o servidor reinicia.
+func newSessionSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err) // crypto/rand só falha se o sistema não tiver fonte de entropia
+	}
+	return secret
+}
+
+// signReconnectToken gera o token "<playerID>.<hmac>" que permite ao jogador voltar à mesma sala
+func signReconnectToken(secret []byte, roomID string, playerID string) string {
+	return playerID + "." + reconnectMAC(secret, roomID, playerID)
+}
+
+// verifyReconnectToken valida a assinatura do token para a sala e retorna o ID do jogador
+func verifyReconnectToken(secret []byte, roomID string, token string) (string, bool) {
+	playerID, mac, found := strings.Cut(token, ".")
+	if !found || playerID == "" {
+		return "", false
+	}
+	expected := reconnectMAC(secret, roomID, playerID)
+	if !hmac.Equal([]byte(mac), []byte(expected)) {
+		return "", false
+	}
+	return playerID, true
+}
+
+func reconnectMAC(secret []byte, roomID string, playerID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(roomID + ":" + playerID)) // A sala faz parte da assinatura: o token não vale em outra sala
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"game/engine"
+)
+
+const (
+	DefaultSSEClients = 50               // Assinantes simultâneos de /events
+	SSEBuffer         = 64               // Eventos pendentes por assinante antes de ele ser considerado lento
+	SSEHubBuffer      = 256              // Eventos aguardando distribuição antes de começar a descartar
+	SSEKeepAlive      = 15 * time.Second // Intervalo dos comentários que mantêm a conexão aberta em proxies
+)
+
+var eventHub *EventHub // Inicializado em main()
+
+// EventHub distribui os eventos das salas para os assinantes de /events. Cada assinante tem um canal
+// bufferizado; quem deixa o buffer encher é desconectado, então um cliente lento nunca atrasa os demais
+// nem a partida (o EventSink só repassa para a fila do hub).
+type EventHub struct {
+	mu     sync.Mutex
+	subs   map[chan engine.GameEvent]struct{}
+	max    int
+	closed bool
+}
+
+func newEventHub(max int) *EventHub {
+	return &EventHub{subs: make(map[chan engine.GameEvent]struct{}), max: max}
+}
+
+// start inicia a goroutine que distribui os eventos e retorna o EventSink que a alimenta
+func (h *EventHub) start() engine.EventSink {
+	queue := engine.NewChannelSink(SSEHubBuffer, func(e engine.GameEvent) {
+		slog.Debug("Fila de /events cheia, evento descartado", "room", e.RoomID, "type", e.Type)
+	})
+	go func() {
+		for e := range queue.C {
+			h.distribute(e)
+		}
+	}()
+	return queue
+}
+
+// distribute entrega o evento a cada assinante, desconectando os que não dão conta
+func (h *EventHub) distribute(e engine.GameEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+			slog.Info("Assinante lento de /events desconectado", "subscribers", len(h.subs))
+		}
+	}
+}
+
+// subscribe registra um assinante. Retorna false se o limite foi atingido ou o servidor está encerrando.
+func (h *EventHub) subscribe() (chan engine.GameEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed || len(h.subs) >= h.max {
+		return nil, false
+	}
+	ch := make(chan engine.GameEvent, SSEBuffer)
+	h.subs[ch] = struct{}{}
+	return ch, true
+}
+
+// unsubscribe remove o assinante, se ele ainda não foi desconectado pelo hub
+func (h *EventHub) unsubscribe(ch chan engine.GameEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// close desconecta todos os assinantes e recusa novos, para que o Shutdown do servidor não espere por
+// conexões que nunca terminariam sozinhas
+func (h *EventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// eventsHandler transmite os eventos das salas (entradas, saídas, coletas e fins de jogo) como Server-Sent
+// Events, com o tipo do evento no campo 'event' e o JSON no 'data'. ?room= restringe a uma sala.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	roomID := r.URL.Query().Get("room")
+	if roomID != "" && !validRoomID.MatchString(roomID) {
+		http.Error(w, "sala inválida", http.StatusBadRequest)
+		return
+	}
+
+	ch, ok := eventHub.subscribe()
+	if !ok {
+		http.Error(w, "limite de assinantes atingido", http.StatusServiceUnavailable)
+		return
+	}
+	defer eventHub.unsubscribe(ch)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	slog.Debug("Assinante de /events conectado", "room", roomID)
+
+	keepAlive := time.NewTicker(SSEKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var chunk []byte
+		select {
+		case <-r.Context().Done(): // Cliente desconectou
+			return
+		case e, ok := <-ch:
+			if !ok { // Desconectado pelo hub (cliente lento ou shutdown)
+				return
+			}
+			if roomID != "" && e.RoomID != roomID {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				slog.Error("Erro ao serializar evento para /events", "err", err)
+				continue
+			}
+			chunk = fmt.Appendf(nil, "event: %s\ndata: %s\n\n", e.Type, data)
+		case <-keepAlive.C:
+			chunk = []byte(": keep-alive\n\n")
+		}
+
+		// Uma escrita travada não segura o hub (o buffer do assinante enche e ele é desconectado), mas o prazo
+		// garante que esta goroutine também termine
+		rc.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+		if _, err := w.Write(chunk); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"game/engine"
+)
+
+const (
+	DefaultStateSaveSec = 30 // Intervalo padrão entre gravações do estado das salas em STATE_FILE
+)
+
+// savedRoom é uma sala em STATE_FILE: a partida e, se for privada, a senha (só o sal e o HMAC, como na memória)
+type savedRoom struct {
+	ID           string            `json:"id"`
+	PasswordSalt []byte            `json:"passwordSalt,omitempty"`
+	PasswordHash []byte            `json:"passwordHash,omitempty"`
+	State        engine.SavedState `json:"state"`
+}
+
+// stateFile é o conteúdo de STATE_FILE
+type stateFile struct {
+	SavedAt time.Time   `json:"savedAt"`
+	Rooms   []savedRoom `json:"rooms"`
+}
+
+// saveState grava a partida de todas as salas em path. Cada sala é copiada sob o seu próprio lock de leitura,
+// então a gravação não para os gameLoops; salas diferentes podem ser copiadas em instantes um pouco diferentes.
+func (rm *RoomManager) saveState(path string) error {
+	rm.mu.Lock()
+	saved := make([]savedRoom, 0, len(rm.rooms))
+	states := make([]*engine.GameState, 0, len(rm.rooms))
+	for id, r := range rm.rooms {
+		saved = append(saved, savedRoom{ID: id, PasswordSalt: r.passwordSalt, PasswordHash: r.passwordHash})
+		states = append(states, r.state)
+	}
+	rm.mu.Unlock()
+
+	for i, gs := range states {
+		saved[i].State = gs.Save()
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].ID < saved[j].ID })
+	return writeFileAtomic(path, stateFile{SavedAt: time.Now(), Rooms: saved})
+}
+
+// restoreState recria as salas gravadas em path por saveState. Um arquivo inexistente não restaura nada; uma
+// sala cujo estado não cabe na configuração atual (outro tamanho de tabuleiro, por exemplo) começa uma partida
+// nova, com um aviso no log. Retorna quantas salas foram restauradas.
+func (rm *RoomManager) restoreState(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var file stateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return 0, fmt.Errorf("estado inválido em %s: %w", path, err)
+	}
+
+	restored := 0
+	for _, saved := range file.Rooms {
+		if !validRoomID.MatchString(saved.ID) {
+			slog.Warn("Sala salva com ID inválido ignorada", "room", saved.ID)
+			continue
+		}
+		rm.mu.Lock()
+		r, ok := rm.rooms[saved.ID]
+		if !ok {
+			r = rm.createLocked(saved.ID)
+		}
+		r.passwordSalt, r.passwordHash = saved.PasswordSalt, saved.PasswordHash
+		rm.mu.Unlock()
+
+		if err := r.state.Restore(saved.State); err != nil {
+			slog.Warn("Estado salvo da sala descartado", "room", saved.ID, "err", err)
+			continue
+		}
+		restored++
+	}
+	slog.Info("Estado das salas restaurado", "file", path, "rooms", restored, "saved_at", file.SavedAt)
+	return restored, nil
+}
+
+// persistState grava o estado das salas em path a cada interval, até o shutdown (que faz a última gravação)
+func (rm *RoomManager) persistState(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := rm.saveState(path); err != nil {
+				slog.Error("Erro ao salvar o estado das salas", "file", path, "err", err)
+			}
+		case <-rm.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"game/engine"
+)
+
+const (
+	WebhookTimeout = 5 * time.Second // Prazo de cada tentativa de POST
+	WebhookRetries = 3               // Tentativas extras depois da primeira falha
+	WebhookBackoff = 2 * time.Second // Espera antes da primeira nova tentativa, dobrando a cada falha
+	WebhookBuffer  = 64              // Fins de jogo aguardando envio antes de começar a descartar
+)
+
+// gameOverPayload é o JSON enviado para GAME_OVER_WEBHOOK_URL ao fim de cada partida
+type gameOverPayload struct {
+	RoomID          string               `json:"roomId"`
+	EndedAt         time.Time            `json:"endedAt"`
+	WinnerIDs       []string             `json:"winnerIds"`
+	Scores          []engine.PlayerStats `json:"scores"`
+	DurationSeconds float64              `json:"durationSeconds"`
+}
+
+// gameOverSink repassa só os eventos de fim de jogo para a fila de uma goroutine consumidora
+type gameOverSink struct {
+	queue *engine.ChannelSink
+}
+
+func (s gameOverSink) Publish(e engine.GameEvent) {
+	if e.Type == engine.EventGameOver {
+		s.queue.Publish(e)
+	}
+}
+
+// startWebhook inicia a goroutine que envia os fins de jogo para a URL e retorna o EventSink que a alimenta.
+// Os envios acontecem um de cada vez, fora do gameLoop, então um endpoint lento só atrasa os próprios webhooks.
+func startWebhook(url string) engine.EventSink {
+	queue := engine.NewChannelSink(WebhookBuffer, func(e engine.GameEvent) {
+		slog.Warn("Fila do webhook cheia, fim de jogo descartado", "room", e.RoomID)
+	})
+	client := &http.Client{Timeout: WebhookTimeout}
+	go func() {
+		for e := range queue.C {
+			postGameOver(client, url, e)
+		}
+	}()
+	return gameOverSink{queue: queue}
+}
+
+// postGameOver envia um fim de jogo, tentando de novo com espera crescente até WebhookRetries vezes
+func postGameOver(client *http.Client, url string, e engine.GameEvent) {
+	body, err := json.Marshal(gameOverPayload{
+		RoomID:          e.RoomID,
+		EndedAt:         e.Time,
+		WinnerIDs:       e.WinnerIDs,
+		Scores:          e.Scores,
+		DurationSeconds: e.Duration.Seconds(),
+	})
+	if err != nil {
+		slog.Error("Erro ao serializar o webhook de fim de jogo", "room", e.RoomID, "err", err)
+		return
+	}
+
+	backoff := WebhookBackoff
+	for attempt := 0; ; attempt++ {
+		err = sendWebhook(client, url, body)
+		if err == nil {
+			slog.Debug("Webhook de fim de jogo enviado", "room", e.RoomID)
+			return
+		}
+		if attempt == WebhookRetries {
+			slog.Error("Webhook de fim de jogo falhou, desistindo", "room", e.RoomID, "attempts", attempt+1, "err", err)
+			return
+		}
+		slog.Warn("Webhook de fim de jogo falhou, tentando de novo", "room", e.RoomID, "attempt", attempt+1, "retry_in", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sendWebhook faz um único POST, considerando falha qualquer resposta fora da faixa 2xx
+func sendWebhook(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("resposta %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookHost retorna só o host da URL do webhook, para o log não expor tokens que estejam no caminho ou na query
+func webhookHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}